@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/exec"
+)
+
+// Terminal modes, named after the pod subresource the terminal streams through
+const (
+	TerminalModeExec   = "exec"
+	TerminalModeAttach = "attach"
+)
+
+// attachPollInterval is how often the container is checked after an attach stream ended
+// and attachExitTimeout how long the kubelet may take to report the process as exited
+var (
+	attachPollInterval = time.Second
+	attachExitTimeout  = 10 * time.Second
+)
+
+// streamLostError reports an attach stream that ended while the attached process still runs
+type streamLostError struct {
+	err error
+}
+
+func (e *streamLostError) Error() string {
+	if e.err == nil {
+		return "connection to the container was lost"
+	}
+	return fmt.Sprintf("connection to the container was lost: %v", e.err)
+}
+
+func (e *streamLostError) Unwrap() error {
+	return e.err
+}
+
+// containerStatus returns the status of the named container, init container or ephemeral
+// container of the pod
+func containerStatus(pod *corev1.Pod, name string) (corev1.ContainerStatus, bool) {
+	for _, list := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range list {
+			if status.Name == name {
+				return status, true
+			}
+		}
+	}
+	return corev1.ContainerStatus{}, false
+}
+
+// containerStdio reports whether the named container keeps stdin open and allocates a TTY
+func containerStdio(pod *corev1.Pod, name string) (stdin, tty bool) {
+	for _, list := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range list {
+			if c.Name == name {
+				return c.Stdin, c.TTY
+			}
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if c.Name == name {
+			return c.Stdin, c.TTY
+		}
+	}
+	return false, false
+}
+
+// processExit returns the exit code of the container's main process if it exited after
+// the container had restarted the given number of times
+func processExit(status corev1.ContainerStatus, restarts int32) (int, bool) {
+	if terminated := status.State.Terminated; terminated != nil {
+		return int(terminated.ExitCode), true
+	}
+	if terminated := status.LastTerminationState.Terminated; terminated != nil && status.RestartCount > restarts {
+		return int(terminated.ExitCode), true
+	}
+	return 0, false
+}
+
+// attachTerminal connects the terminal to the main process of the target container, such
+// as a REPL running as PID 1, instead of starting a shell. The container has to keep stdin
+// open; resizes are only forwarded to containers that allocate a TTY.
+func (s *Server) attachTerminal(w http.ResponseWriter, r *http.Request, target terminalTarget) {
+	pod, err := s.kubeClient.CoreV1().Pods(target.Namespace).Get(r.Context(), target.Pod, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("Pod %s/%s not found", target.Namespace, target.Pod), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to get pod %s/%s: %v", target.Namespace, target.Pod, err), http.StatusInternalServerError)
+		}
+		return
+	}
+	stdin, tty := containerStdio(pod, target.Container)
+	if !stdin {
+		http.Error(w, fmt.Sprintf("Container %q of pod %s/%s does not keep stdin open, attaching needs stdin: true in its spec", target.Container, target.Namespace, target.Pod), http.StatusBadRequest)
+		return
+	}
+	status, _ := containerStatus(pod, target.Container)
+
+	s.serveTerminal(w, r, target, func(ctx context.Context, session *TerminalSession) error {
+		log.Printf("Attaching terminal to container %s in pod %s/%s", target.Container, target.Namespace, target.Pod)
+		opts := execOptions{
+			Namespace: target.Namespace,
+			Pod:       target.Pod,
+			Container: target.Container,
+			Stdin:     session,
+			Stdout:    session,
+			TTY:       tty,
+		}
+		if tty {
+			opts.SizeQueue = session
+		} else {
+			opts.Stderr = session
+		}
+		return s.attachProcess(ctx, opts, status.RestartCount)
+	})
+}
+
+// attachProcess attaches to the main process of a container and blocks until the stream
+// ends. The attach stream does not carry the exit status of the process, so the container
+// status tells whether the process exited, with its exit code as a CodeExitError, or the
+// stream was lost while it still runs, as a streamLostError.
+func (s *Server) attachProcess(ctx context.Context, opts execOptions, restarts int32) error {
+	streamErr := s.streamAttach(ctx, opts)
+	if ctx.Err() != nil {
+		return streamErr
+	}
+
+	var code int
+	var exited bool
+	pods := s.kubeClient.CoreV1().Pods(opts.Namespace)
+	err := wait.PollUntilContextTimeout(ctx, attachPollInterval, attachExitTimeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := pods.Get(ctx, opts.Pod, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil {
+			// Keep trying until the timeout, the API server may be unavailable too
+			return false, nil
+		}
+		if status, ok := containerStatus(pod, opts.Container); ok {
+			code, exited = processExit(status, restarts)
+		}
+		return exited, nil
+	})
+	switch {
+	case exited && code == 0:
+		return nil
+	case exited:
+		return exec.CodeExitError{Err: fmt.Errorf("process exited with code %d", code), Code: code}
+	case ctx.Err() != nil:
+		return streamErr
+	case wait.Interrupted(err):
+		return &streamLostError{err: streamErr}
+	default:
+		return errors.Join(streamErr, fmt.Errorf("failed to check the attached container: %w", err))
+	}
+}
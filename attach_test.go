@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProcessExit(t *testing.T) {
+	terminated := &corev1.ContainerStateTerminated{ExitCode: 3}
+	tests := []struct {
+		name     string
+		status   corev1.ContainerStatus
+		restarts int32
+		code     int
+		exited   bool
+	}{
+		{name: "running", status: corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}},
+		{name: "terminated", status: corev1.ContainerStatus{State: corev1.ContainerState{Terminated: terminated}}, code: 3, exited: true},
+		{name: "restarted", status: corev1.ContainerStatus{RestartCount: 2, LastTerminationState: corev1.ContainerState{Terminated: terminated}}, restarts: 1, code: 3, exited: true},
+		{name: "restarted before", status: corev1.ContainerStatus{RestartCount: 2, LastTerminationState: corev1.ContainerState{Terminated: terminated}}, restarts: 2},
+	}
+	for _, test := range tests {
+		if code, exited := processExit(test.status, test.restarts); code != test.code || exited != test.exited {
+			t.Errorf("%s: expected %d %v, got %d %v", test.name, test.code, test.exited, code, exited)
+		}
+	}
+}
+
+// replPod runs an interactive process as PID 1 next to a sidecar without stdin
+func replPod() *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "repl-0", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "repl", Image: "python:3", Stdin: true, TTY: true},
+			{Name: "proxy", Image: "envoy:1"},
+		}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "repl", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			{Name: "proxy", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}},
+	}
+}
+
+func TestAttachTerminal(t *testing.T) {
+	interval, timeout := attachPollInterval, attachExitTimeout
+	attachPollInterval, attachExitTimeout = 10*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { attachPollInterval, attachExitTimeout = interval, timeout })
+
+	var execServer *fakeExecServer
+	execServer = newFakeExecServer(t, func(req fakeExecRequest) int {
+		// The REPL evaluates lines until it exits; "drop" ends the stream with it running
+		fmt.Fprint(req.Stdout, ">>> ")
+		scanner := bufio.NewScanner(req.Stdin)
+		for scanner.Scan() {
+			switch line := scanner.Text(); line {
+			case "drop":
+				return 0
+			case "exit(3)":
+				execServer.updatePod("default", "repl-0", func(pod *corev1.Pod) {
+					pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 3}}
+				})
+				return 0
+			default:
+				fmt.Fprintf(req.Stdout, "%s\r\n>>> ", line)
+			}
+		}
+		return 0
+	})
+	execServer.addPod(replPod())
+	server := newTestServer(t, execServer)
+	authorizer := newFakeAuthorizer("alice create pods/exec default")
+	server.authorizer = authorizer
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	dial := func(query string) (*websocket.Conn, *http.Response, error) {
+		wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?" + query
+		return websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	}
+
+	// Attaching needs its own permission and a container that keeps stdin open
+	if _, resp, err := dial("pod=repl-0&mode=attach"); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 without permission to attach, got %v", err)
+	}
+	authorizer.mu.Lock()
+	authorizer.allowed["alice create pods/attach default"] = true
+	authorizer.mu.Unlock()
+	for query, want := range map[string]int{
+		"pod=repl-0&mode=debug":                  http.StatusBadRequest,
+		"pod=repl-0&mode=attach&container=proxy": http.StatusBadRequest,
+	} {
+		if _, resp, err := dial(query); err == nil || resp == nil || resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %v", query, want, err)
+		}
+	}
+
+	// A broken stream is told apart from the process exiting
+	term := dialTerminalAs(t, httpServer.URL, "pod=repl-0&mode=attach&container=repl", "alice-token")
+	term.expectOutput(">>> ")
+	term.stdin("1+1\n")
+	term.expectOutput("1+1\r\n>>> ")
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/repl-0/attach" || req.Container != "repl" || !req.TTY || len(req.Command) != 0 {
+		t.Errorf("Unexpected attach request %s %v", req.Path, req.Command)
+	}
+	term.stdin("drop\n")
+	if closeErr := term.expectClose(); closeErr.Code != CloseStreamLost {
+		t.Errorf("Expected the stream lost close code, got %v", closeErr)
+	}
+
+	// The exit code of the process comes from the container status
+	term = dialTerminalAs(t, httpServer.URL, "pod=repl-0&mode=attach", "alice-token")
+	term.expectOutput(">>> ")
+	term.stdin("exit(3)\n")
+	if code := term.expectExit(); code != 3 {
+		t.Errorf("Expected exit code 3, got %d", code)
+	}
+}
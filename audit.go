@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+)
+
+// auditCapacity is the number of recent audit events served by /api/audit
+const auditCapacity = 1000
+
+// maxAuditError limits how much of an error response is copied into an audit event
+const maxAuditError = 256
+
+// newAuditLogger configures the audit sinks from the environment. Events go to stdout
+// unless AUDIT_STDOUT=false, to a rotating file if AUDIT_LOG_FILE is set and to a webhook
+// if AUDIT_WEBHOOK_URL is set.
+func newAuditLogger() (*audit.Logger, error) {
+	var sinks []audit.Sink
+	if os.Getenv("AUDIT_STDOUT") != "false" {
+		sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+	}
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		maxSize, err := envInt("AUDIT_LOG_MAX_SIZE_MB", 100)
+		if err != nil {
+			return nil, err
+		}
+		maxBackups, err := envInt("AUDIT_LOG_MAX_BACKUPS", 5)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := audit.NewFileSink(path, int64(maxSize)<<20, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if url := os.Getenv("AUDIT_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, audit.NewWebhookSink(url, nil))
+	}
+	return audit.NewLogger(auditCapacity, sinks...), nil
+}
+
+// newRedactor masks the default secret patterns and those in AUDIT_REDACT_PATTERNS, one
+// regular expression per line, in audited commands
+func newRedactor() (*audit.Redactor, error) {
+	patterns := append([]string(nil), audit.DefaultRedactPatterns...)
+	for _, pattern := range strings.Split(os.Getenv("AUDIT_REDACT_PATTERNS"), "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	redactor, err := audit.NewRedactor(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUDIT_REDACT_PATTERNS: %v", err)
+	}
+	return redactor, nil
+}
+
+// commandAuditor returns a line assembler that logs the commands typed in a terminal
+// session as terminal.command events, or nil if commands are not audited. record is the
+// event of the session.
+func (s *Server) commandAuditor(record *audit.Record, sessionID string) *audit.LineAssembler {
+	if s.audit == nil || !s.auditCommands {
+		return nil
+	}
+	return audit.NewLineAssembler(func(command string) {
+		session := record.Event()
+		s.audit.Log(audit.Event{
+			User:      session.User,
+			SourceIP:  session.SourceIP,
+			Action:    "terminal.command",
+			Namespace: session.Namespace,
+			Pod:       session.Pod,
+			Container: session.Container,
+			Config:    session.Config,
+			Session:   sessionID,
+			Command:   s.redactor.Redact(command),
+			Outcome:   audit.OutcomeSuccess,
+		})
+	})
+}
+
+// envInt parses a non-negative integer environment variable
+func envInt(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// auditRequests logs an audit event for every request to a named route when it finishes.
+// The route name is the action. It runs before authentication, so requests refused with
+// 401 are logged too; auditUser adds the user once they are authenticated.
+func (s *Server) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || route.GetName() == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		vars := mux.Vars(r)
+		name := vars["name"]
+		if name == "" {
+			name = vars["id"]
+		}
+		record := audit.NewRecord(audit.Event{
+			Time:     started,
+			SourceIP: sourceIP(r),
+			Action:   route.GetName(),
+			Name:     name,
+		})
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rw := &auditResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r.WithContext(audit.WithRecord(r.Context(), record)))
+
+		record.AddBytes(body.n, rw.bytes)
+		event := record.Event()
+		event.Status = rw.status
+		if event.Status == 0 {
+			event.Status = http.StatusOK
+		}
+		event.DurationMs = time.Since(started).Milliseconds()
+		switch {
+		case event.Outcome != "":
+		case event.Status == http.StatusUnauthorized || event.Status == http.StatusForbidden:
+			event.Outcome = audit.OutcomeDenied
+		case event.Status >= 400:
+			event.Outcome = audit.OutcomeFailure
+		default:
+			event.Outcome = audit.OutcomeSuccess
+		}
+		if event.Error == "" && event.Status >= 400 {
+			event.Error = string(rw.errorBody)
+		}
+		s.audit.Log(event)
+	})
+}
+
+// auditUser records the authenticated user of the request in its audit record. It runs
+// after authentication.
+func auditUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit.RecordFrom(r.Context()).SetUser(requestUser(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sourceIP is the address of the client. Forwarding headers are not trusted.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// auditResponseWriter captures the status, size and error message of a response. It
+// passes through Flush for event streams and Hijack for WebSockets.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	errorBody []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.errorBody) < maxAuditError {
+		w.errorBody = append(w.errorBody, p[:min(len(p), maxAuditError-len(w.errorBody))]...)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *auditResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// auditAttributes is the permission needed to read the audit log. There is no such
+// Kubernetes resource; RBAC rules can still grant it.
+func auditAttributes() auth.Attributes {
+	return auth.Attributes{Verb: "list", Group: terminal.GroupName, Resource: "auditevents"}
+}
+
+// listAuditEventsHandler returns the last limit audit events, newest first
+func (s *Server) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > auditCapacity {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", auditCapacity), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	if err := s.authorize(r.Context(), auditAttributes()); err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.audit.Recent(limit))
+}
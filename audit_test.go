@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// waitForAuditEvent waits until an event with the given action and user is logged
+func waitForAuditEvent(t *testing.T, logger *audit.Logger, action, user string) audit.Event {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, event := range logger.Recent(auditCapacity) {
+			if event.Action == action && event.User == user {
+				return event
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No %s event by %s in %+v", action, user, logger.Recent(auditCapacity))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		if req.TTY {
+			buf := make([]byte, 16)
+			n, _ := req.Stdin.Read(buf)
+			fmt.Fprintf(req.Stdout, "%s\r\n", buf[:n])
+			return 3
+		}
+		fmt.Fprintln(req.Stdout, "done")
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	server.authorizer = newFakeAuthorizer(
+		"alice create pods/exec default",
+		"alice list auditevents.terminal.kubernetes-web-terminal.io ",
+	)
+	server.audit = audit.NewLogger(100)
+	server.executions.audit = server.audit
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// A terminal session is logged when it ends, with the bytes typed and shown
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?config=dev"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err != nil {
+		t.Fatalf("Failed to open terminal: %v", err)
+	}
+	term := &testTerminal{t: t, conn: conn}
+	term.stdin("whoami")
+	term.expectOutput("whoami")
+	term.expectExit()
+	conn.Close()
+
+	event := waitForAuditEvent(t, server.audit, "terminal.open", "alice")
+	if event.Namespace != "default" || event.Pod != "dev-terminal" || event.Config != "dev" || event.SourceIP != "127.0.0.1" {
+		t.Errorf("Unexpected target in %+v", event)
+	}
+	if event.Outcome != audit.OutcomeSuccess || event.Status != http.StatusSwitchingProtocols || event.ExitCode == nil || *event.ExitCode != 3 {
+		t.Errorf("Unexpected outcome in %+v", event)
+	}
+	if event.BytesIn != int64(len("whoami")) || event.BytesOut != int64(len("whoami\r\n")) {
+		t.Errorf("Unexpected byte counts in %+v", event)
+	}
+
+	// Refusals are logged as denied, with the reason
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/terminal?config=dev", "bob-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	event = waitForAuditEvent(t, server.audit, "terminal.open", "bob")
+	if event.Outcome != audit.OutcomeDenied || event.Status != http.StatusForbidden || !strings.Contains(event.Error, "cannot create pods/exec") {
+		t.Errorf("Expected a denied event, got %+v", event)
+	}
+
+	// Scripts are logged when they start and when they finish
+	resp, err = http.DefaultClient.Do(authorizedRequest(t, "POST", httpServer.URL+"/api/execute-script", "alice-token",
+		`{"type":"bash","script":"echo done","podName":"tools-0"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var started ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	event = waitForAuditEvent(t, server.audit, "script.execute", "alice")
+	if event.Name != started.ID || event.Pod != "tools-0" || event.Status != http.StatusAccepted || event.BytesIn == 0 {
+		t.Errorf("Unexpected start event %+v", event)
+	}
+	event = waitForAuditEvent(t, server.audit, "script.finish", "alice")
+	if event.Name != started.ID || event.Outcome != audit.OutcomeSuccess || event.ExitCode == nil || *event.ExitCode != 0 || event.SourceIP != "127.0.0.1" {
+		t.Errorf("Unexpected finish event %+v", event)
+	}
+
+	// The query endpoint returns the most recent events first
+	resp, err = http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/audit?limit=2", "alice-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var events []audit.Event
+	json.NewDecoder(resp.Body).Decode(&events)
+	resp.Body.Close()
+	if len(events) != 2 || !events[0].Time.After(events[1].Time) && !events[0].Time.Equal(events[1].Time) {
+		t.Errorf("Expected the two most recent events, got %+v", events)
+	}
+
+	resp, err = http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/audit", "bob-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	decodeForbidden(t, resp.StatusCode, string(body))
+}
+
+func TestCommandAudit(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		// Echo until the command is submitted
+		buf := make([]byte, 64)
+		for {
+			n, err := req.Stdin.Read(buf)
+			if err != nil {
+				return 1
+			}
+			req.Stdout.Write(buf[:n])
+			if strings.Contains(string(buf[:n]), "\r") {
+				return 0
+			}
+		}
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	server.audit = audit.NewLogger(100)
+	server.auditCommands = true
+	redactor, err := audit.NewRedactor(audit.DefaultRedactPatterns)
+	if err != nil {
+		t.Fatalf("NewRedactor failed: %v", err)
+	}
+	server.redactor = redactor
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?config=dev"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err != nil {
+		t.Fatalf("Failed to open terminal: %v", err)
+	}
+	term := &testTerminal{t: t, conn: conn}
+	term.stdin("deploy --tken")
+	term.stdin("\x1b[D\x1b[D\x1b[Do")
+	term.stdin("\x1b[F hunter2\r")
+	term.expectExit()
+	conn.Close()
+
+	session := waitForAuditEvent(t, server.audit, "terminal.open", "alice")
+	command := waitForAuditEvent(t, server.audit, "terminal.command", "alice")
+	if command.Command != "deploy --token [REDACTED]" {
+		t.Errorf("Expected the edited command with the secret redacted, got %q", command.Command)
+	}
+	if command.Session == "" || command.Session != session.Session || command.Pod != "dev-terminal" || command.Config != "dev" {
+		t.Errorf("Expected the command to be tagged with the session, got %+v and %+v", command, session)
+	}
+}
+
+func TestAuditLogsUnauthenticatedRequests(t *testing.T) {
+	server := newTestServer(t, newFakeExecServer(t, func(req fakeExecRequest) int { return 0 }))
+	server.audit = audit.NewLogger(100)
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/pods", "wrong-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %s", resp.Status)
+	}
+
+	event := waitForAuditEvent(t, server.audit, "pods.list", "")
+	if event.Outcome != audit.OutcomeDenied || event.Status != http.StatusUnauthorized || event.SourceIP != "127.0.0.1" {
+		t.Errorf("Expected a denied event for the refused request, got %+v", event)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	"k8s.io/client-go/kubernetes"
+)
+
+// allowedOrigins lists the origins, besides the server's own, that may open terminal
+// WebSockets. It is read from the comma-separated ALLOWED_ORIGINS variable.
+var allowedOrigins = splitList(os.Getenv("ALLOWED_ORIGINS"))
+
+// checkOrigin only accepts WebSocket upgrades from pages served by this server or an
+// allowed origin, so other sites cannot open terminals with the user's session cookie.
+// Clients that send no Origin header are not browsers and must present a bearer token.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAuthenticator configures authentication from the environment. Bearer tokens are
+// always accepted and validated with TokenReview; OIDC login is enabled by OIDC_ISSUER_URL.
+// AUTH_DISABLED=true lets every request through as an anonymous user.
+func newAuthenticator(ctx context.Context, kubeClient kubernetes.Interface) (auth.Authenticator, *auth.OIDCAuthenticator, error) {
+	if os.Getenv("AUTH_DISABLED") == "true" {
+		log.Printf("Warning: authentication is disabled, every request is served as %q", "anonymous")
+		return auth.Anonymous{Name: "anonymous"}, nil, nil
+	}
+
+	authenticators := auth.Union{auth.NewTokenReviewAuthenticator(kubeClient, splitList(os.Getenv("TOKEN_REVIEW_AUDIENCES")))}
+
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return authenticators, nil, nil
+	}
+	sessionTTL := 8 * time.Hour
+	if value := os.Getenv("OIDC_SESSION_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid OIDC_SESSION_TTL: %v", err)
+		}
+		sessionTTL = parsed
+	}
+	oidc, err := auth.NewOIDCAuthenticator(ctx, auth.OIDCConfig{
+		IssuerURL:      issuer,
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:         splitList(os.Getenv("OIDC_SCOPES")),
+		UsernameClaim:  os.Getenv("OIDC_USERNAME_CLAIM"),
+		UsernamePrefix: os.Getenv("OIDC_USERNAME_PREFIX"),
+		GroupsClaim:    os.Getenv("OIDC_GROUPS_CLAIM"),
+		GroupsPrefix:   os.Getenv("OIDC_GROUPS_PREFIX"),
+		SessionTTL:     sessionTTL,
+		SecureCookies:  os.Getenv("OIDC_INSECURE_COOKIES") != "true",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(authenticators, oidc), oidc, nil
+}
+
+// splitList splits a comma-separated environment variable, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	defer func(origins []string) { allowedOrigins = origins }(allowedOrigins)
+	allowedOrigins = []string{"https://console.example.com/"}
+
+	testCases := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://terminal.example.com", true},
+		{"https://console.example.com", true},
+		{"https://evil.example.com", false},
+		{"https://terminal.example.com.evil.example.com", false},
+		{"null", false},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "https://terminal.example.com/api/terminal", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if got := checkOrigin(req); got != tc.want {
+			t.Errorf("checkOrigin(%q) = %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+}
+
+func TestAPIRoutesRequireAuthentication(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	authenticator := tokenAuthenticator{"secret": {Name: "alice"}}
+	httpServer := httptest.NewServer(server.routes(authenticator, nil))
+	defer httpServer.Close()
+
+	for _, path := range []string{"/api/pods", "/api/scripts", "/api/terminalconfigs", "/api/executions/abc"} {
+		resp, err := http.Get(httpServer.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s without credentials: expected 401, got %s", path, resp.Status)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", httpServer.URL+"/api/scripts", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected authenticated request to succeed, got %s", resp.Status)
+	}
+
+	// The WebSocket upgrade is authenticated before the connection is accepted
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?config=dev"
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected unauthenticated WebSocket to be rejected with 401, got %v", resp)
+	}
+
+	// Cross-site pages cannot open terminals even with valid credentials
+	header := http.Header{"Authorization": {"Bearer secret"}, "Origin": {"https://evil.example.com"}}
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected cross-origin WebSocket to be rejected with 403, got %v", resp)
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	router := server.routes(tokenAuthenticator{"secret": {Name: "alice"}}, nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"alice"`) {
+		t.Errorf("Unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// tokenAuthenticator accepts a fixed set of bearer tokens
+type tokenAuthenticator map[string]auth.User
+
+func (a tokenAuthenticator) AuthenticateRequest(r *http.Request) (*auth.User, bool, error) {
+	user, ok := a[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		return nil, false, nil
+	}
+	return &user, true, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/policy"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// podAttributes is the permission needed to use a pod subresource such as exec or attach
+func podAttributes(namespace, pod, subresource string) auth.Attributes {
+	return auth.Attributes{Namespace: namespace, Verb: "create", Resource: "pods", Subresource: subresource, Name: pod}
+}
+
+// ephemeralContainerAttributes is the permission needed to add a debug container to a pod
+func ephemeralContainerAttributes(namespace, pod string) auth.Attributes {
+	return auth.Attributes{Namespace: namespace, Verb: "update", Resource: "pods", Subresource: "ephemeralcontainers", Name: pod}
+}
+
+// jobAttributes is the permission needed to run a script as a Job
+func jobAttributes(namespace string) auth.Attributes {
+	return auth.Attributes{Namespace: namespace, Verb: "create", Group: "batch", Resource: "jobs"}
+}
+
+// scriptAttributes is the permission needed to run a script: exec into its target pod, or
+// create a Job when it has none
+func scriptAttributes(req ScriptRequest) auth.Attributes {
+	if req.PodName != "" {
+		return podAttributes(req.Namespace, req.PodName, "exec")
+	}
+	return jobAttributes(req.Namespace)
+}
+
+// fileMountAttributes are the permissions needed to mount a TerminalConfig's files: get
+// on each Secret and ConfigMap and use on each PersistentVolumeClaim. The controller creates
+// the terminal pod with its own permissions, so without them anyone who can create a config
+// could read any Secret or claim in its namespace.
+func fileMountAttributes(namespace string, mounts []terminalv1.FileMount) []auth.Attributes {
+	var attrs []auth.Attributes
+	for _, mount := range mounts {
+		switch {
+		case mount.ConfigMapRef != nil:
+			attrs = append(attrs, auth.Attributes{Namespace: namespace, Verb: "get", Resource: "configmaps", Name: mount.ConfigMapRef.Name})
+		case mount.SecretRef != nil:
+			attrs = append(attrs, auth.Attributes{Namespace: namespace, Verb: "get", Resource: "secrets", Name: mount.SecretRef.SecretName})
+		case mount.VolumeRef != nil:
+			attrs = append(attrs, auth.Attributes{Namespace: namespace, Verb: "use", Resource: "persistentvolumeclaims", Name: mount.VolumeRef.Name})
+		}
+	}
+	return attrs
+}
+
+// authorize checks that the request's user may perform every action in attrs. It guards
+// streamExec and Job creation, so every endpoint that reaches into a pod is covered;
+// handlers call it up front as well to fail before they upgrade or respond.
+func (s *Server) authorize(ctx context.Context, attrs ...auth.Attributes) error {
+	return auth.Authorize(ctx, s.authorizer, attrs...)
+}
+
+// ForbiddenResponse is the body of 403 responses
+type ForbiddenResponse struct {
+	Error     string `json:"error"`
+	Verb      string `json:"verb,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// writeForbidden writes a 403 JSON response if err is an authorization failure, either
+// from the gate or from the API server, or a command blocked by policy, and reports
+// whether it did
+func writeForbidden(w http.ResponseWriter, err error) bool {
+	var response ForbiddenResponse
+	var forbidden *auth.ForbiddenError
+	var denied *policy.DeniedError
+	switch {
+	case errors.As(err, &forbidden):
+		attrs := forbidden.Attributes
+		response = ForbiddenResponse{
+			Error:     forbidden.Error(),
+			Verb:      attrs.Verb,
+			Resource:  attrs.Resource,
+			Namespace: attrs.Namespace,
+			Name:      attrs.Name,
+			Reason:    forbidden.Reason,
+		}
+		if attrs.Subresource != "" {
+			response.Resource += "/" + attrs.Subresource
+		}
+	case errors.As(err, &denied):
+		response = ForbiddenResponse{Error: denied.Error(), Reason: denied.Message}
+	case apierrors.IsForbidden(err):
+		response = ForbiddenResponse{Error: err.Error()}
+	default:
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(response)
+	return true
+}
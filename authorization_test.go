@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeAuthorizer allows the actions listed as "user verb resource namespace"
+type fakeAuthorizer struct {
+	mu      sync.Mutex
+	allowed map[string]bool
+}
+
+func newFakeAuthorizer(allowed ...string) *fakeAuthorizer {
+	f := &fakeAuthorizer{allowed: make(map[string]bool)}
+	for _, rule := range allowed {
+		f.allowed[rule] = true
+	}
+	return f
+}
+
+func (f *fakeAuthorizer) Authorize(ctx context.Context, user *auth.User, attrs auth.Attributes) (bool, string, error) {
+	key := user.Name + " " + attrs.String() + " " + attrs.Namespace
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.allowed[key] {
+		return true, "", nil
+	}
+	return false, "denied by test policy", nil
+}
+
+// decodeForbidden checks for a 403 JSON response and returns its body
+func decodeForbidden(t *testing.T, code int, body string) ForbiddenResponse {
+	t.Helper()
+	if code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d: %s", code, body)
+	}
+	var response ForbiddenResponse
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("Expected a JSON body, got %q", body)
+	}
+	return response
+}
+
+func TestTerminalRequiresExecPermission(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	server.authorizer = newFakeAuthorizer("alice create pods/exec default")
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// The check happens before the upgrade, so a plain request sees the 403
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/terminal?config=dev", "bob-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	response := decodeForbidden(t, resp.StatusCode, string(body))
+	if response.Resource != "pods/exec" || response.Namespace != "default" || response.Name != "dev-terminal" {
+		t.Errorf("Unexpected 403 response %+v", response)
+	}
+	if !strings.Contains(response.Error, `user "bob" cannot create pods/exec`) {
+		t.Errorf("Unexpected error %q", response.Error)
+	}
+
+	// A namespace override is checked against the requested namespace
+	resp, err = http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/terminal?config=dev&namespace=kube-system", "alice-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for another namespace, got %s", resp.Status)
+	}
+}
+
+func TestMountRequiresExecPermission(t *testing.T) {
+	uploadDir = t.TempDir()
+	writeUpload(t, "bob", "abc123_settings.yaml", "x")
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		t.Errorf("File was copied without permission")
+		return 0
+	})
+	server := newTestServer(t, execServer)
+	server.authorizer = newFakeAuthorizer()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/mount", strings.NewReader(`{"fileId":"abc123","podName":"web-0","namespace":"team-a","targetPath":"/tmp/"}`))
+	req = req.WithContext(auth.WithUser(req.Context(), &auth.User{Name: "bob"}))
+	server.mountHandler(rec, req)
+	if response := decodeForbidden(t, rec.Code, rec.Body.String()); response.Namespace != "team-a" || response.Name != "web-0" {
+		t.Errorf("Unexpected 403 response %+v", response)
+	}
+}
+
+func TestScriptsRequirePermission(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.authorizer = newFakeAuthorizer("alice create pods/exec default")
+
+	post := func(user, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/execute-script", strings.NewReader(body))
+		server.executeScriptHandler(rec, req.WithContext(auth.WithUser(req.Context(), &auth.User{Name: user})))
+		return rec
+	}
+
+	if rec := post("alice", `{"type":"bash","script":"echo hi","podName":"tools-0"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected alice to run scripts in pods, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := post("alice", `{"type":"bash","script":"echo hi"}`)
+	if response := decodeForbidden(t, rec.Code, rec.Body.String()); response.Resource != "jobs" || response.Verb != "create" {
+		t.Errorf("Expected Job creation to be refused, got %+v", response)
+	}
+	rec = post("bob", `{"type":"bash","script":"echo hi","podName":"tools-0"}`)
+	decodeForbidden(t, rec.Code, rec.Body.String())
+}
+
+func TestStreamExecIsGated(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		t.Errorf("Command ran without permission")
+		return 0
+	})
+	server := newTestServer(t, execServer)
+	server.authorizer = newFakeAuthorizer()
+
+	ctx := auth.WithUser(context.Background(), &auth.User{Name: "alice"})
+	err := server.streamExec(ctx, execOptions{Namespace: "default", Pod: "web-0", Command: []string{"id"}})
+	var forbidden *auth.ForbiddenError
+	if !errors.As(err, &forbidden) {
+		t.Fatalf("Expected a ForbiddenError, got %v", err)
+	}
+
+	// Without a user nothing is allowed
+	if err := server.streamExec(context.Background(), execOptions{Namespace: "default", Pod: "web-0"}); !errors.As(err, &forbidden) {
+		t.Errorf("Expected a ForbiddenError without a user, got %v", err)
+	}
+}
+
+func TestTerminalConfigFileMountsRequirePermission(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.authorizer = newFakeAuthorizer("alice get configmaps default", "alice use persistentvolumeclaims default")
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	create := func(name, mount string) (int, string) {
+		body := `{"metadata":{"name":"` + name + `"},"spec":{"fileMounts":[` + mount + `]}}`
+		resp, err := http.DefaultClient.Do(authorizedRequest(t, "POST", httpServer.URL+"/api/terminalconfigs", "alice-token", body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if code, body := create("settings", `{"name":"settings","mountPath":"/etc/app","configMapRef":{"name":"app"}}`); code != http.StatusCreated {
+		t.Errorf("Expected a readable ConfigMap to be mounted, got %d: %s", code, body)
+	}
+	if code, body := create("data", `{"name":"data","mountPath":"/data","volumeRef":{"name":"data"}}`); code != http.StatusCreated {
+		t.Errorf("Expected a usable claim to be mounted, got %d: %s", code, body)
+	}
+	code, body := create("creds", `{"name":"creds","mountPath":"/creds","secretRef":{"secretName":"db-password"}}`)
+	if response := decodeForbidden(t, code, body); response.Resource != "secrets" || response.Verb != "get" || response.Name != "db-password" {
+		t.Errorf("Unexpected 403 response %+v", response)
+	}
+}
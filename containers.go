@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultContainerAnnotation names the container kubectl picks when none is given
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// Container types
+const (
+	ContainerTypeRegular   = "container"
+	ContainerTypeInit      = "init"
+	ContainerTypeEphemeral = "ephemeral"
+)
+
+// Container states, empty while the kubelet has not reported the container yet
+const (
+	ContainerWaiting    = "waiting"
+	ContainerRunning    = "running"
+	ContainerTerminated = "terminated"
+)
+
+// errUnknownContainer is returned for a container the pod does not have
+var errUnknownContainer = errors.New("container not found")
+
+// ContainerInfo describes a container of a pod
+type ContainerInfo struct {
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	Type         string `json:"type"`
+	State        string `json:"state,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Ready        bool   `json:"ready"`
+	RestartCount int32  `json:"restartCount"`
+}
+
+// podContainers lists the containers, init containers and ephemeral containers of a pod
+// with their state
+func podContainers(pod *corev1.Pod) []ContainerInfo {
+	statuses := make(map[string]corev1.ContainerStatus)
+	for _, list := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range list {
+			statuses[status.Name] = status
+		}
+	}
+	info := func(name, image, containerType string) ContainerInfo {
+		container := ContainerInfo{Name: name, Image: image, Type: containerType}
+		status, ok := statuses[name]
+		if !ok {
+			return container
+		}
+		container.Ready = status.Ready
+		container.RestartCount = status.RestartCount
+		switch state := status.State; {
+		case state.Running != nil:
+			container.State = ContainerRunning
+		case state.Waiting != nil:
+			container.State = ContainerWaiting
+			container.Reason = state.Waiting.Reason
+		case state.Terminated != nil:
+			container.State = ContainerTerminated
+			container.Reason = state.Terminated.Reason
+		}
+		return container
+	}
+
+	var containers []ContainerInfo
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, info(c.Name, c.Image, ContainerTypeInit))
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, info(c.Name, c.Image, ContainerTypeRegular))
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, info(c.Name, c.Image, ContainerTypeEphemeral))
+	}
+	return containers
+}
+
+// defaultContainer returns the container named by the default-container annotation, or
+// the first container of the pod if the annotation is missing or names no container
+func defaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		for _, c := range pod.Spec.Containers {
+			if c.Name == name {
+				return name
+			}
+		}
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// selectContainer returns name if the pod has such a container, or the default container
+// if name is empty
+func selectContainer(pod *corev1.Pod, name string) (string, error) {
+	if name == "" {
+		return defaultContainer(pod), nil
+	}
+	var names []string
+	for _, c := range podContainers(pod) {
+		if c.Name == name {
+			return name, nil
+		}
+		names = append(names, c.Name)
+	}
+	return "", fmt.Errorf("%w: pod %s/%s has no container %q, choose one of: %s",
+		errUnknownContainer, pod.Namespace, pod.Name, name, strings.Join(names, ", "))
+}
+
+// resolveContainer returns the container of the pod to exec into. A pod that cannot be
+// found or read is left to the exec request to report.
+func (s *Server) resolveContainer(ctx context.Context, namespace, podName, container string) (string, error) {
+	pod, err := s.kubeClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsForbidden(err) {
+		return container, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+	}
+	return selectContainer(pod, container)
+}
+
+// containerErrorStatus is the HTTP status for an error of resolveContainer
+func containerErrorStatus(err error) int {
+	if errors.Is(err, errUnknownContainer) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
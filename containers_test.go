@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// multiContainerPod has an init container, an application with a sidecar and an ephemeral
+// debug container
+func multiContainerPod() *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-0",
+			Namespace:   "default",
+			Annotations: map[string]string{defaultContainerAnnotation: "app"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate:1"}},
+			Containers: []corev1.Container{
+				{Name: "proxy", Image: "envoy:1"},
+				{Name: "app", Image: "web:2"},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "migrate",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "proxy", Ready: true, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				{Name: "app", RestartCount: 3, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			},
+		},
+	}
+}
+
+func TestPodContainers(t *testing.T) {
+	pod := multiContainerPod()
+	want := []ContainerInfo{
+		{Name: "migrate", Image: "migrate:1", Type: ContainerTypeInit, State: ContainerTerminated, Reason: "Completed"},
+		{Name: "proxy", Image: "envoy:1", Type: ContainerTypeRegular, State: ContainerRunning, Ready: true},
+		{Name: "app", Image: "web:2", Type: ContainerTypeRegular, State: ContainerWaiting, Reason: "CrashLoopBackOff", RestartCount: 3},
+		{Name: "debugger", Image: "busybox", Type: ContainerTypeEphemeral},
+	}
+	if got := podContainers(pod); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	tests := []struct {
+		name       string
+		annotation string
+		container  string
+		want       string
+		wantErr    bool
+	}{
+		{name: "annotation", annotation: "app", want: "app"},
+		{name: "first container", want: "proxy"},
+		{name: "annotation names no container", annotation: "gone", want: "proxy"},
+		{name: "explicit", annotation: "app", container: "proxy", want: "proxy"},
+		{name: "ephemeral", container: "debugger", want: "debugger"},
+		{name: "unknown", container: "nope", wantErr: true},
+	}
+	for _, test := range tests {
+		pod.Annotations = map[string]string{defaultContainerAnnotation: test.annotation}
+		got, err := selectContainer(pod, test.container)
+		if test.wantErr {
+			if !errors.Is(err, errUnknownContainer) || !strings.Contains(err.Error(), "migrate, proxy, app, debugger") {
+				t.Errorf("%s: expected an unknown container error listing the containers, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: expected %s, got %q (%v)", test.name, test.want, got, err)
+		}
+	}
+}
+
+func TestPodsListContainers(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(corev1.PodList{
+			TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+			Items:    []corev1.Pod{*multiContainerPod()},
+		})
+	}))
+	defer apiServer.Close()
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.clients.config = &rest.Config{Host: apiServer.URL}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/pods", "alice-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Pods []Pod `json:"pods"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode pods: %v", err)
+	}
+	if len(body.Pods) != 1 || body.Pods[0].DefaultContainer != "app" || len(body.Pods[0].Containers) != 4 {
+		t.Fatalf("Expected the pod with its containers, got %+v", body.Pods)
+	}
+	if app := body.Pods[0].Containers[2]; app.Name != "app" || app.State != ContainerWaiting || app.Reason != "CrashLoopBackOff" {
+		t.Errorf("Expected the state of the app container, got %+v", app)
+	}
+}
+
+func TestContainerSelection(t *testing.T) {
+	containers := make(chan string, 10)
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		containers <- req.Container
+		io.WriteString(req.Stdout, "in "+req.Container)
+		return 0
+	})
+	execServer.addPod(multiContainerPod())
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// Terminals default to the annotated container unless one is picked
+	term := dialTerminalAs(t, httpServer.URL, "config=dev&pod=web-0", "alice-token")
+	term.expectOutput("in app")
+	term = dialTerminalAs(t, httpServer.URL, "config=dev&pod=web-0&container=proxy", "alice-token")
+	term.expectOutput("in proxy")
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?config=dev&pod=web-0&container=nope"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown container, got %v", err)
+	}
+
+	// Scripts pick their container the same way
+	body := `{"script":"echo hi","type":"bash","podName":"web-0","container":"nope"}`
+	rec := httptest.NewRecorder()
+	server.executeScriptHandler(rec, httptest.NewRequest(http.MethodPost, "/api/execute-script", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "choose one of") {
+		t.Errorf("Expected 400 for an unknown container, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body = `{"script":"echo hi","type":"bash","podName":"web-0"}`
+	rec = httptest.NewRecorder()
+	server.executeScriptHandler(rec, httptest.NewRequest(http.MethodPost, "/api/execute-script", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	<-containers
+	<-containers
+	select {
+	case container := <-containers:
+		if container != "app" {
+			t.Errorf("Expected the script to run in the default container, got %q", container)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Script did not run")
+	}
+}
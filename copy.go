@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/util/exec"
+)
+
+// uploadDir is where uploadHandler stores files until they are copied into a pod. Each
+// user's uploads live in their own subdirectory, see uploadOwnerDir.
+var uploadDir = "./uploads"
+
+// defaultUploadTTL is how long an upload that was never mounted is kept, overridden by UPLOAD_TTL
+const defaultUploadTTL = time.Hour
+
+// fileIDPattern restricts file IDs to characters that are safe in file names and globs
+var fileIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// extractScript creates the destination directory and unpacks a tar stream from stdin into it,
+// like `kubectl cp` does. The directory is passed as $1 so it never needs shell quoting.
+const extractScript = `mkdir -p "$1" && tar -xmf - -C "$1"`
+
+// copyResult describes a file copied into a container
+type copyResult struct {
+	BytesWritten int64
+	Checksum     string
+}
+
+// uploadOwnerDir is the directory holding the uploads of user. The name is hashed so any
+// user name is safe as a path element.
+func uploadOwnerDir(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return filepath.Join(uploadDir, hex.EncodeToString(sum[:8]))
+}
+
+// findUpload returns the path of the file with the given ID uploaded by user. Other users'
+// uploads are never found.
+func findUpload(user, fileID string) (string, error) {
+	if !fileIDPattern.MatchString(fileID) {
+		return "", fmt.Errorf("invalid file ID %q", fileID)
+	}
+	matches, err := filepath.Glob(filepath.Join(uploadOwnerDir(user), fileID+"_*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", os.ErrNotExist
+	}
+	return matches[0], nil
+}
+
+// removeExpiredUploads deletes uploads last modified more than ttl before now
+func removeExpiredUploads(ttl time.Duration, now time.Time) {
+	filepath.WalkDir(uploadDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < ttl {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove expired upload %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// expireUploads removes uploads older than ttl until ctx is done
+func expireUploads(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removeExpiredUploads(ttl, now)
+		}
+	}
+}
+
+// uploadedFileName strips the file ID prefix added by uploadHandler
+func uploadedFileName(uploadPath, fileID string) string {
+	return strings.TrimPrefix(filepath.Base(uploadPath), fileID+"_")
+}
+
+// resolveTargetPath returns the absolute destination file path in the container. A target
+// ending in "/" is treated as a directory and receives the uploaded file's name.
+func resolveTargetPath(targetPath, fileName string) (string, error) {
+	if !path.IsAbs(targetPath) {
+		return "", fmt.Errorf("target path %q must be absolute", targetPath)
+	}
+	if strings.HasSuffix(targetPath, "/") {
+		targetPath = path.Join(targetPath, fileName)
+	}
+	targetPath = path.Clean(targetPath)
+	if targetPath == "/" {
+		return "", fmt.Errorf("target path must name a file")
+	}
+	return targetPath, nil
+}
+
+// copyFileToPod streams localPath into the container at targetPath using a tar archive
+// piped into `tar -x` over exec. Parent directories are created as needed.
+func (s *Server) copyFileToPod(ctx context.Context, target execOptions, localPath, targetPath string) (*copyResult, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	reader, writer := io.Pipe()
+	written := make(chan int64, 1)
+	go func() {
+		n, err := writeTar(writer, io.TeeReader(file, hasher), path.Base(targetPath), info)
+		written <- n
+		writer.CloseWithError(err)
+	}()
+
+	// stdout and stderr are copied concurrently, so they need separate buffers
+	var stdout, stderr bytes.Buffer
+	target.Command = []string{"sh", "-c", extractScript, "sh", path.Dir(targetPath)}
+	target.Stdin = reader
+	target.Stdout = &stdout
+	target.Stderr = &stderr
+	err = s.streamExec(ctx, target)
+	reader.CloseWithError(io.ErrClosedPipe)
+	n := <-written
+
+	var exitErr exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("extracting into %s failed with exit code %d: %s",
+			path.Dir(targetPath), exitErr.ExitStatus(), strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n != info.Size() {
+		return nil, fmt.Errorf("copied %d of %d bytes", n, info.Size())
+	}
+
+	return &copyResult{
+		BytesWritten: n,
+		Checksum:     "sha256:" + hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// writeTar writes a single-file tar archive to w and returns the number of content bytes written
+func writeTar(w io.Writer, content io.Reader, name string, info os.FileInfo) (int64, error) {
+	tw := tar.NewWriter(w)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tw, content)
+	if err != nil {
+		return n, err
+	}
+	return n, tw.Close()
+}
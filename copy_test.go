@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+)
+
+// tarFiles extracts a tar stream into a map of file name to content
+func tarFiles(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Errorf("Invalid tar stream: %v", err)
+			return files
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+}
+
+// writeUpload stores a file as uploadHandler would for user and returns its path
+func writeUpload(t *testing.T, user, name, content string) string {
+	t.Helper()
+	dir := uploadOwnerDir(user)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create upload directory: %v", err)
+	}
+	uploadPath := filepath.Join(dir, name)
+	if err := os.WriteFile(uploadPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	return uploadPath
+}
+
+func TestMountHandlerCopiesFileIntoPod(t *testing.T) {
+	uploadDir = t.TempDir()
+	content := "key: value\n"
+	uploadPath := writeUpload(t, "", "abc123_settings.yaml", content)
+
+	extracted := make(chan map[string]string, 1)
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		extracted <- tarFiles(t, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer)
+
+	body := `{"fileId":"abc123","podName":"web-0","namespace":"team-a","container":"app","targetPath":"/etc/app/"}`
+	rec := httptest.NewRecorder()
+	server.mountHandler(rec, httptest.NewRequest(http.MethodPost, "/api/mount", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response MountResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if response.TargetPath != "/etc/app/settings.yaml" {
+		t.Errorf("Unexpected target path %s", response.TargetPath)
+	}
+	if response.BytesWritten != int64(len(content)) {
+		t.Errorf("Expected %d bytes written, got %d", len(content), response.BytesWritten)
+	}
+	if response.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected checksum %s", response.Checksum)
+	}
+
+	files := <-extracted
+	if files["settings.yaml"] != content {
+		t.Errorf("Pod received unexpected archive contents: %v", files)
+	}
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/team-a/pods/web-0/exec" {
+		t.Errorf("Unexpected exec path %s", req.Path)
+	}
+	if len(req.Command) != 5 || req.Command[2] != extractScript || req.Command[4] != "/etc/app" {
+		t.Errorf("Unexpected extract command %v", req.Command)
+	}
+	if _, err := os.Stat(uploadPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the upload to be removed after mounting, got %v", err)
+	}
+}
+
+func TestMountHandlerReportsExtractFailure(t *testing.T) {
+	uploadDir = t.TempDir()
+	writeUpload(t, "", "f1_data.bin", string(bytes.Repeat([]byte{1}, 4096)))
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(io.Discard, req.Stdin)
+		fmt.Fprint(req.Stderr, "mkdir: can't create directory '/readonly': Read-only file system")
+		return 1
+	})
+	server := newTestServer(t, execServer)
+
+	body := `{"fileId":"f1","podName":"web-0","targetPath":"/readonly/data.bin"}`
+	rec := httptest.NewRecorder()
+	server.mountHandler(rec, httptest.NewRequest(http.MethodPost, "/api/mount", strings.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Read-only file system") {
+		t.Errorf("Expected the container error in the response, got %q", rec.Body.String())
+	}
+}
+
+func TestMountHandlerValidation(t *testing.T) {
+	uploadDir = t.TempDir()
+	writeUpload(t, "", "ok_file.txt", "x")
+	server := &Server{namespace: "default"}
+
+	testCases := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing pod", `{"fileId":"ok","targetPath":"/tmp/"}`, http.StatusBadRequest},
+		{"unknown file", `{"fileId":"missing","podName":"p","targetPath":"/tmp/"}`, http.StatusNotFound},
+		{"path traversal in file ID", `{"fileId":"../ok","podName":"p","targetPath":"/tmp/"}`, http.StatusNotFound},
+		{"relative target", `{"fileId":"ok","podName":"p","targetPath":"tmp/file"}`, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.mountHandler(rec, httptest.NewRequest(http.MethodPost, "/api/mount", strings.NewReader(tc.body)))
+			if rec.Code != tc.code {
+				t.Errorf("Expected %d, got %d: %s", tc.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUploadHandlerChoosesFileID(t *testing.T) {
+	uploadDir = t.TempDir()
+
+	upload := func(user string) UploadResponse {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("fileId", "chosen")
+		part, _ := form.CreateFormFile("file", "../../settings.yaml")
+		part.Write([]byte("key: value\n"))
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req = req.WithContext(auth.WithUser(req.Context(), &auth.User{Name: user}))
+		rec := httptest.NewRecorder()
+		uploadHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response UploadResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	first, second := upload("alice"), upload("alice")
+	if first.FileID == "chosen" || first.FileID == second.FileID {
+		t.Errorf("Expected unique server generated IDs, got %q and %q", first.FileID, second.FileID)
+	}
+	if want := filepath.Join(uploadOwnerDir("alice"), first.FileID+"_settings.yaml"); first.Path != want {
+		t.Errorf("Expected the upload at %s, got %s", want, first.Path)
+	}
+	if _, err := findUpload("alice", first.FileID); err != nil {
+		t.Errorf("Expected alice to find her upload: %v", err)
+	}
+	if _, err := findUpload("bob", first.FileID); !os.IsNotExist(err) {
+		t.Errorf("Expected bob not to find alice's upload, got %v", err)
+	}
+}
+
+func TestMountHandlerRejectsOtherUsersUploads(t *testing.T) {
+	uploadDir = t.TempDir()
+	writeUpload(t, "alice", "abc123_settings.yaml", "x")
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		t.Errorf("Another user's upload was copied")
+		return 0
+	})
+	server := newTestServer(t, execServer)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/mount", strings.NewReader(`{"fileId":"abc123","podName":"web-0","targetPath":"/tmp/"}`))
+	server.mountHandler(rec, req.WithContext(auth.WithUser(req.Context(), &auth.User{Name: "bob"})))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	uploadDir = t.TempDir()
+	old := writeUpload(t, "alice", "a_old.txt", "x")
+	fresh := writeUpload(t, "bob", "b_fresh.txt", "x")
+	now := time.Now()
+	os.Chtimes(old, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+
+	removeExpiredUploads(time.Hour, now)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired upload to be removed, got %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("Expected the fresh upload to be kept: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// defaultDebugImage is the image of debug containers unless DEBUG_IMAGE is set
+const defaultDebugImage = "busybox:1.36"
+
+// debugPollInterval is how often the state of a new debug container is checked and
+// debugStartTimeout how long it may take to start, pulling its image included
+var (
+	debugPollInterval = time.Second
+	debugStartTimeout = 2 * time.Minute
+)
+
+// debugFailureReasons are the waiting reasons of containers that will not start
+var debugFailureReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CreateContainerError":       true,
+	"CreateContainerConfigError": true,
+}
+
+// newDebugImages returns the images debug containers may use, the default first.
+// DEBUG_IMAGE replaces the default and DEBUG_IMAGES lists more images users may pick.
+func newDebugImages() []string {
+	images := []string{defaultDebugImage}
+	if image := strings.TrimSpace(os.Getenv("DEBUG_IMAGE")); image != "" {
+		images[0] = image
+	}
+	for _, image := range strings.Split(os.Getenv("DEBUG_IMAGES"), ",") {
+		if image = strings.TrimSpace(image); image != "" && image != images[0] {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// debugImage returns the requested image if users may pick it, or the default image
+func (s *Server) debugImage(requested string) (string, error) {
+	images := s.debugImages
+	if len(images) == 0 {
+		images = []string{defaultDebugImage}
+	}
+	if requested == "" {
+		return images[0], nil
+	}
+	for _, image := range images {
+		if image == requested {
+			return image, nil
+		}
+	}
+	return "", fmt.Errorf("image %q is not allowed for debug containers, choose one of: %s", requested, strings.Join(images, ", "))
+}
+
+// ephemeralContainerState reports whether the named ephemeral container runs, or why it
+// is still waiting. It fails once the container exited or cannot start.
+func ephemeralContainerState(pod *corev1.Pod, name string) (bool, string, error) {
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name != name {
+			continue
+		}
+		switch state := status.State; {
+		case state.Running != nil:
+			return true, "", nil
+		case state.Terminated != nil:
+			return false, "", fmt.Errorf("debug container %s exited: %s %s", name, state.Terminated.Reason, state.Terminated.Message)
+		case state.Waiting != nil && debugFailureReasons[state.Waiting.Reason]:
+			return false, "", fmt.Errorf("debug container %s cannot start: %s: %s", name, state.Waiting.Reason, state.Waiting.Message)
+		case state.Waiting != nil:
+			return false, state.Waiting.Reason, nil
+		}
+	}
+	return false, "", nil
+}
+
+// debugHandler adds an ephemeral debug container to a pod, such as a distroless pod
+// without a shell, and attaches a terminal to it once it runs. With target, the debug
+// container shares the process namespace of that container.
+func (s *Server) debugHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		namespace = s.namespace
+	}
+	podName := query.Get("pod")
+	if podName == "" {
+		http.Error(w, "Missing 'pod' query parameter", http.StatusBadRequest)
+		return
+	}
+	targetName := query.Get("target")
+	image, err := s.debugImage(query.Get("image"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Ephemeral containers cannot be removed, so their names must be unique in the pod
+	containerName := "debugger-" + newID()[:5]
+	sessionID := newID()
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(namespace, podName, containerName)
+	record.SetSession(sessionID)
+
+	err = s.authorize(r.Context(), ephemeralContainerAttributes(namespace, podName), podAttributes(namespace, podName, "attach"))
+	if err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pod, err := s.kubeClient.CoreV1().Pods(namespace).Get(r.Context(), podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("Pod %s/%s not found", namespace, podName), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, fmt.Sprintf("Failed to get pod %s/%s: %v", namespace, podName, err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if targetName != "" && !hasContainer(pod.Spec.Containers, targetName) {
+		http.Error(w, fmt.Sprintf("Pod %s/%s has no container %q to target", namespace, podName, targetName), http.StatusBadRequest)
+		return
+	}
+
+	// Debugging a pod provisioned for a TerminalConfig follows its command policy,
+	// recording and session limits
+	config, err := s.ownerTerminalConfig(r.Context(), pod)
+	if err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if config == nil {
+		config = &terminalv1.TerminalConfig{}
+	}
+	record.SetConfig(config.Name)
+
+	container := corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:  containerName,
+			Image: image,
+			// The container's stdin closes with the first attached terminal, ending
+			// the debug shell with the session
+			Stdin:     true,
+			StdinOnce: true,
+			TTY:       true,
+		},
+		TargetContainerName: targetName,
+	}
+	target := terminalTarget{
+		SessionID: sessionID,
+		Namespace: namespace,
+		Pod:       podName,
+		Container: containerName,
+		Config:    config,
+		Mode:      TerminalModeAttach,
+	}
+	s.serveTerminal(w, r, target, func(ctx context.Context, session *TerminalSession) error {
+		if err := s.startDebugContainer(ctx, session, pod, container); err != nil {
+			return err
+		}
+		session.writeNotice("If you don't see a command prompt, try pressing enter.")
+		return s.attachProcess(ctx, execOptions{
+			Namespace: namespace,
+			Pod:       podName,
+			Container: containerName,
+			Stdin:     session,
+			Stdout:    session,
+			TTY:       true,
+			SizeQueue: session,
+		}, 0)
+	})
+}
+
+// startDebugContainer adds the ephemeral container to the pod and waits until it runs,
+// telling the user in the terminal what it is waiting for
+func (s *Server) startDebugContainer(ctx context.Context, session *TerminalSession, pod *corev1.Pod, container corev1.EphemeralContainer) error {
+	log.Printf("Adding debug container %s with image %s to pod %s/%s", container.Name, container.Image, pod.Namespace, pod.Name)
+	session.writeNotice(fmt.Sprintf("Starting debug container %s with image %s...", container.Name, container.Image))
+	pod = pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, container)
+	pods := s.kubeClient.CoreV1().Pods(pod.Namespace)
+	if _, err := pods.UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to add debug container: %w", err)
+	}
+
+	var reason string
+	err := wait.PollUntilContextTimeout(ctx, debugPollInterval, debugStartTimeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		running, waiting, err := ephemeralContainerState(pod, container.Name)
+		if waiting != "" && waiting != reason {
+			reason = waiting
+			session.writeNotice(fmt.Sprintf("Debug container is waiting: %s", reason))
+		}
+		return running, err
+	})
+	if wait.Interrupted(err) && ctx.Err() == nil {
+		return fmt.Errorf("debug container %s did not start within %s", container.Name, debugStartTimeout)
+	}
+	return err
+}
+
+// hasContainer reports whether containers has one with the name
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, c := range containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEphemeralContainerState(t *testing.T) {
+	status := func(state corev1.ContainerState) *corev1.Pod {
+		return &corev1.Pod{Status: corev1.PodStatus{EphemeralContainerStatuses: []corev1.ContainerStatus{{Name: "debugger-1", State: state}}}}
+	}
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		running bool
+		waiting string
+		wantErr bool
+	}{
+		{name: "not reported", pod: &corev1.Pod{}},
+		{name: "creating", pod: status(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}), waiting: "ContainerCreating"},
+		{name: "running", pod: status(corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}), running: true},
+		{name: "bad image", pod: status(corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}), wantErr: true},
+		{name: "exited", pod: status(corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error"}}), wantErr: true},
+	}
+	for _, test := range tests {
+		running, waiting, err := ephemeralContainerState(test.pod, "debugger-1")
+		if running != test.running || waiting != test.waiting || (err != nil) != test.wantErr {
+			t.Errorf("%s: expected %v %q error %v, got %v %q %v", test.name, test.running, test.waiting, test.wantErr, running, waiting, err)
+		}
+	}
+}
+
+func TestDebugContainer(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.WriteString(req.Stdout, "/ # ")
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	execServer.addPod(multiContainerPod())
+	server := newTestServer(t, execServer)
+	server.debugImages = []string{"busybox:1.36", "nicolaka/netshoot"}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	dial := func(query string) (*websocket.Conn, *http.Response, error) {
+		wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/debug?" + query
+		return websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	}
+	for query, want := range map[string]int{
+		"pod=web-0&image=evil:latest": http.StatusBadRequest,
+		"pod=web-0&target=migrate":    http.StatusBadRequest,
+		"pod=gone":                    http.StatusNotFound,
+	} {
+		if _, resp, err := dial(query); err == nil || resp == nil || resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %v", query, want, err)
+		}
+	}
+
+	// The debug container is added to the pod and the terminal attaches to it once it runs
+	conn, _, err := dial("pod=web-0&target=app&image=nicolaka/netshoot")
+	if err != nil {
+		t.Fatalf("Failed to dial debug terminal: %v", err)
+	}
+	term := &testTerminal{t: t, conn: conn}
+	defer conn.Close()
+	term.expectOutput("/ # ")
+	term.stdin("ps")
+	term.expectOutput("ps")
+
+	pod := execServer.pod("default", "web-0")
+	debugger := pod.Spec.EphemeralContainers[len(pod.Spec.EphemeralContainers)-1]
+	if !strings.HasPrefix(debugger.Name, "debugger-") || debugger.Image != "nicolaka/netshoot" || debugger.TargetContainerName != "app" {
+		t.Errorf("Unexpected debug container %+v", debugger)
+	}
+	if !debugger.Stdin || !debugger.StdinOnce || !debugger.TTY {
+		t.Errorf("Expected an interactive debug container, got %+v", debugger)
+	}
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/web-0/attach" || req.Container != debugger.Name || !req.TTY {
+		t.Errorf("Unexpected attach request %s to %q", req.Path, req.Container)
+	}
+	if !strings.Contains(term.output.String(), "Starting debug container "+debugger.Name) {
+		t.Errorf("Expected a notice about the debug container, got %q", term.output.String())
+	}
+}
+
+func TestDebugFollowsOwnerConfig(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	pod := multiContainerPod()
+	pod.Labels = map[string]string{controller.ConfigLabel: "prod"}
+	execServer.addPod(pod)
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			CommandPolicy: &terminalv1.CommandPolicy{Deny: []terminalv1.CommandRule{{Pattern: `reboot`}}},
+		},
+	}))
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/debug?pod=web-0"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err != nil {
+		t.Fatalf("Failed to dial debug terminal: %v", err)
+	}
+	defer conn.Close()
+	term := &testTerminal{t: t, conn: conn}
+	term.expectOutput("try pressing enter")
+	term.stdin("reboot\r")
+	term.expectOutput("command blocked by policy")
+}
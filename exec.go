@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// defaultTerminalCommand is used when a TerminalConfig does not specify a command
+var defaultTerminalCommand = []string{"/bin/sh"}
+
+// defaultShells are tried in order by terminals opened without a TerminalConfig
+var defaultShells = []string{"bash", "sh"}
+
+// shellProbe execs the first of its arguments found in the container's PATH
+const shellProbe = `for shell in "$@"; do command -v "$shell" >/dev/null 2>&1 && exec "$shell"; done; echo "No shell found: $*" >&2; exit 127`
+
+// execOptions describes a command to run inside a container via the pods/exec subresource,
+// or without Command, the streams to attach to a container via pods/attach
+type execOptions struct {
+	Namespace string
+	Pod       string
+	Container string
+	Command   []string
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	TTY       bool
+	SizeQueue remotecommand.TerminalSizeQueue
+}
+
+// streamExec runs the command described by opts and blocks until the remote process exits.
+// A non-zero exit status is reported as a k8s.io/client-go/util/exec.CodeExitError.
+func (s *Server) streamExec(ctx context.Context, opts execOptions) error {
+	return s.stream(ctx, opts, "exec", &corev1.PodExecOptions{
+		Container: opts.Container,
+		Command:   opts.Command,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    opts.Stdout != nil,
+		Stderr:    opts.Stderr != nil && !opts.TTY,
+		TTY:       opts.TTY,
+	})
+}
+
+// streamAttach connects the streams of opts to the main process of a container and blocks
+// until the process exits or ctx is cancelled. Cancelling leaves the process running.
+func (s *Server) streamAttach(ctx context.Context, opts execOptions) error {
+	return s.stream(ctx, opts, "attach", &corev1.PodAttachOptions{
+		Container: opts.Container,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    opts.Stdout != nil,
+		Stderr:    opts.Stderr != nil && !opts.TTY,
+		TTY:       opts.TTY,
+	})
+}
+
+// stream runs a remote command stream on the exec or attach subresource of a pod
+func (s *Server) stream(ctx context.Context, opts execOptions, subresource string, params runtime.Object) error {
+	if err := s.authorize(ctx, podAttributes(opts.Namespace, opts.Pod, subresource)); err != nil {
+		return err
+	}
+
+	req := s.kubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(opts.Namespace).
+		Name(opts.Pod).
+		SubResource(subresource).
+		VersionedParams(params, scheme.ParameterCodec)
+
+	executor, err := newExecutor(s.restConfig, req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %v", err)
+	}
+
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:             opts.Stdin,
+		Stdout:            opts.Stdout,
+		Tty:               opts.TTY,
+		TerminalSizeQueue: opts.SizeQueue,
+	}
+	if !opts.TTY {
+		streamOptions.Stderr = opts.Stderr
+	}
+
+	return executor.StreamWithContext(ctx, streamOptions)
+}
+
+// newExecutor returns an executor that speaks the WebSocket (v5) remote command protocol
+// and falls back to SPDY for API servers that do not support it
+func newExecutor(config *rest.Config, u *url.URL) (remotecommand.Executor, error) {
+	websocketExec, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	spdyExec, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewFallbackExecutor(websocketExec, spdyExec, httpstream.IsUpgradeFailure)
+}
+
+// terminalCommand returns the command line configured for a terminal, falling back to a plain shell
+func terminalCommand(command, args []string) []string {
+	if len(command) == 0 && len(args) == 0 {
+		return defaultTerminalCommand
+	}
+	result := make([]string, 0, len(command)+len(args))
+	result = append(result, command...)
+	return append(result, args...)
+}
+
+// shellCommand returns the command line that starts the first of shells available in the
+// container. The shells are probed with /bin/sh; a single shell is run directly, so
+// containers without /bin/sh can still be entered.
+func shellCommand(shells []string) []string {
+	if len(shells) == 1 {
+		return shells
+	}
+	return append([]string{"/bin/sh", "-c", shellProbe, "sh"}, shells...)
+}
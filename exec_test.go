@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientremotecommand "k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// fakeExecRequest is what the stand-in exec server hands to a test's process function
+type fakeExecRequest struct {
+	Path      string
+	Header    http.Header
+	Container string
+	Command   []string
+	TTY       bool
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	Resize    <-chan clientremotecommand.TerminalSize
+}
+
+// fakeExecServer is a local stand-in for the API server's pods/exec endpoint speaking
+// the v5 WebSocket remote command protocol. run returns the exit code of the fake process.
+// Pods added with addPod can be read and given ephemeral containers, which start running
+// at once; any other request is answered with 404 Not Found.
+type fakeExecServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []fakeExecRequest
+	pods     map[string]*corev1.Pod
+}
+
+// addPod lets clients get the pod
+func (f *fakeExecServer) addPod(pod *corev1.Pod) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pods["/api/v1/namespaces/"+pod.Namespace+"/pods/"+pod.Name] = pod
+}
+
+// updateEphemeralContainers replaces the ephemeral containers of a pod with those of the
+// pod in body, reporting the new ones as running
+func (f *fakeExecServer) updateEphemeralContainers(path string, body io.Reader) (*corev1.Pod, bool) {
+	var update corev1.Pod
+	if err := json.NewDecoder(body).Decode(&update); err != nil {
+		return nil, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.pods[path]
+	if !ok {
+		return nil, false
+	}
+	pod := stored.DeepCopy()
+	pod.Spec.EphemeralContainers = update.Spec.EphemeralContainers
+	for _, c := range pod.Spec.EphemeralContainers[len(stored.Spec.EphemeralContainers):] {
+		pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+			Name:  c.Name,
+			Image: c.Image,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		})
+	}
+	f.pods[path] = pod
+	return pod, true
+}
+
+// pod returns the current state of a pod added with addPod
+func (f *fakeExecServer) pod(namespace, name string) *corev1.Pod {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pods["/api/v1/namespaces/"+namespace+"/pods/"+name]
+}
+
+// updatePod changes a pod added with addPod, such as the state of its containers
+func (f *fakeExecServer) updatePod(namespace, name string, update func(pod *corev1.Pod)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	update(f.pods["/api/v1/namespaces/"+namespace+"/pods/"+name])
+}
+
+func newFakeExecServer(t *testing.T, run func(req fakeExecRequest) int) *fakeExecServer {
+	t.Helper()
+	f := &fakeExecServer{pods: make(map[string]*corev1.Pod)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wsstream.IsWebSocketRequest(r) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/ephemeralcontainers") {
+				if pod, ok := f.updateEphemeralContainers(strings.TrimSuffix(r.URL.Path, "/ephemeralcontainers"), r.Body); ok {
+					json.NewEncoder(w).Encode(pod)
+					return
+				}
+			}
+			f.mu.Lock()
+			pod, ok := f.pods[r.URL.Path]
+			f.mu.Unlock()
+			if ok && r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(pod)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+			return
+		}
+		query := r.URL.Query()
+		tty := query.Get("tty") == "true"
+		channels := make([]wsstream.ChannelType, 5)
+		channels[remotecommand.StreamStdIn] = wsstream.ReadChannel
+		channels[remotecommand.StreamStdOut] = wsstream.WriteChannel
+		channels[remotecommand.StreamStdErr] = wsstream.WriteChannel
+		channels[remotecommand.StreamErr] = wsstream.WriteChannel
+		channels[remotecommand.StreamResize] = wsstream.ReadChannel
+
+		conn := wsstream.NewConn(map[string]wsstream.ChannelProtocolConfig{
+			remotecommand.StreamProtocolV5Name: {Binary: true, Channels: channels},
+		})
+		_, streams, err := conn.Open(w, r)
+		if err != nil {
+			t.Errorf("stand-in exec server failed to open streams: %v", err)
+			return
+		}
+		defer conn.Close()
+		// Tell the client the connection is established
+		streams[remotecommand.StreamStdOut].Write([]byte{})
+
+		resize := make(chan clientremotecommand.TerminalSize, 16)
+		go func() {
+			decoder := json.NewDecoder(streams[remotecommand.StreamResize])
+			for {
+				var size clientremotecommand.TerminalSize
+				if err := decoder.Decode(&size); err != nil {
+					return
+				}
+				select {
+				case resize <- size:
+				default:
+				}
+			}
+		}()
+
+		req := fakeExecRequest{
+			Path:      r.URL.Path,
+			Header:    r.Header,
+			Container: query.Get("container"),
+			Command:   query["command"],
+			TTY:       tty,
+			Stdin:     streams[remotecommand.StreamStdIn],
+			Stdout:    streams[remotecommand.StreamStdOut],
+			Stderr:    streams[remotecommand.StreamStdErr],
+			Resize:    resize,
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		f.mu.Unlock()
+
+		status := metav1.Status{Status: metav1.StatusSuccess}
+		if code := run(req); code != 0 {
+			status = metav1.Status{
+				Status: metav1.StatusFailure,
+				Reason: remotecommand.NonZeroExitCodeReason,
+				Details: &metav1.StatusDetails{
+					Causes: []metav1.StatusCause{{
+						Type:    remotecommand.ExitCodeCauseType,
+						Message: strconv.Itoa(code),
+					}},
+				},
+			}
+		}
+		data, _ := json.Marshal(status)
+		streams[remotecommand.StreamErr].Write(data)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// lastRequest returns the most recent exec request received by the stand-in server
+func (f *fakeExecServer) lastRequest(t *testing.T) fakeExecRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		t.Fatalf("stand-in exec server received no requests")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+// newTestServer returns a Server whose Kubernetes clients talk to the stand-in exec server
+func newTestServer(t *testing.T, execServer *fakeExecServer, objects ...runtime.Object) *Server {
+	t.Helper()
+	config := &rest.Config{Host: execServer.URL}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create kube client: %v", err)
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		terminalv1.SchemeGroupVersion.WithResource("terminalconfigs"): "TerminalConfigList",
+		terminalv1.SchemeGroupVersion.WithResource("terminalscripts"): "TerminalScriptList",
+	}, objects...)
+	return &Server{
+		kubeClient:     kubeClient,
+		terminalClient: client.NewTerminalConfigClientFromDynamic(dynamicClient, "default"),
+		scriptClient:   client.NewTerminalScriptClientFromDynamic(dynamicClient, "default"),
+		restConfig:     config,
+		namespace:      "default",
+		executions:     newExecutionManager(),
+		sessions:       newSessionManager(),
+		clients: &clientFactory{
+			config:    config,
+			namespace: "default",
+			newDynamic: func(*rest.Config) (dynamic.Interface, error) {
+				return dynamicClient, nil
+			},
+		},
+	}
+}
+
+// terminalConfigObject converts a TerminalConfig into the unstructured form used by the fake dynamic client
+func terminalConfigObject(t *testing.T, tc *terminalv1.TerminalConfig) runtime.Object {
+	t.Helper()
+	tc.APIVersion = terminalv1.SchemeGroupVersion.String()
+	tc.Kind = "TerminalConfig"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc)
+	if err != nil {
+		t.Fatalf("Failed to convert TerminalConfig: %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestStreamExec(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		data, _ := io.ReadAll(req.Stdin)
+		req.Stdout.Write(bytes.ToUpper(data))
+		req.Stderr.Write([]byte("warning"))
+		return 3
+	})
+	server := newTestServer(t, execServer)
+
+	var stdout, stderr bytes.Buffer
+	err := server.streamExec(context.Background(), execOptions{
+		Namespace: "team-a",
+		Pod:       "worker-0",
+		Container: "app",
+		Command:   []string{"tr", "a-z", "A-Z"},
+		Stdin:     strings.NewReader("hello"),
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+	})
+
+	var exitErr exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("Expected exit code 3, got %v", err)
+	}
+	if stdout.String() != "HELLO" {
+		t.Errorf("stdout mismatch: got %q, want %q", stdout.String(), "HELLO")
+	}
+	if stderr.String() != "warning" {
+		t.Errorf("stderr mismatch: got %q, want %q", stderr.String(), "warning")
+	}
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/team-a/pods/worker-0/exec" {
+		t.Errorf("Unexpected exec path: %s", req.Path)
+	}
+	if strings.Join(req.Command, " ") != "tr a-z A-Z" {
+		t.Errorf("Unexpected command: %v", req.Command)
+	}
+}
+
+func TestTerminalHandlerExecsConfiguredCommand(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		req.Stdout.Write([]byte("$ "))
+		buf := make([]byte, 1024)
+		for {
+			n, err := req.Stdin.Read(buf)
+			if err != nil {
+				return 1
+			}
+			line := string(buf[:n])
+			if line == "exit\r" {
+				return 0
+			}
+			req.Stdout.Write([]byte("echo: " + line))
+		}
+	})
+	tc := terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev-shell", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			Command: []string{"/bin/bash"},
+			Args:    []string{"-l"},
+		},
+	})
+	server := newTestServer(t, execServer, tc)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(server.terminalHandler))
+	defer httpServer.Close()
+
+	term := dialTerminal(t, httpServer.URL, "config=dev-shell&pod=dev-shell-pod")
+	term.expectOutput("$ ")
+	term.stdin("ls")
+	term.expectOutput("echo: ls")
+	term.stdin("exit\r")
+	if code := term.expectExit(); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/dev-shell-pod/exec" {
+		t.Errorf("Unexpected exec path: %s", req.Path)
+	}
+	if strings.Join(req.Command, " ") != "/bin/bash -l" {
+		t.Errorf("Unexpected command: %v", req.Command)
+	}
+	if !req.TTY {
+		t.Errorf("Expected terminal exec to request a TTY")
+	}
+}
+
+func TestTerminalCommandDefaults(t *testing.T) {
+	if got := terminalCommand(nil, nil); strings.Join(got, " ") != "/bin/sh" {
+		t.Errorf("Expected default shell, got %v", got)
+	}
+	if got := terminalCommand([]string{"python3"}, []string{"-i"}); strings.Join(got, " ") != "python3 -i" {
+		t.Errorf("Unexpected command: %v", got)
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	if got := shellCommand([]string{"/busybox/sh"}); strings.Join(got, " ") != "/busybox/sh" {
+		t.Errorf("Expected a single shell to run directly, got %v", got)
+	}
+
+	// The probe runs the first program found, here one that exits at once, and reports
+	// when there is none
+	command := shellCommand([]string{"no-such-shell", "true"})
+	if out, err := osexec.Command(command[0], command[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("Probe failed: %v: %s", err, out)
+	}
+	command = shellCommand([]string{"no-such-shell", "also-missing"})
+	out, err := osexec.Command(command[0], command[1:]...).CombinedOutput()
+	var exitErr *osexec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 127 || !strings.Contains(string(out), "No shell found: no-such-shell also-missing") {
+		t.Errorf("Expected exit code 127 and a message, got %v: %s", err, out)
+	}
+}
+
+func TestTerminalHandlerExecsShellInPod(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		req.Stdout.Write([]byte(strings.Join(req.Command[len(req.Command)-2:], " ")))
+		return 0
+	})
+	server := newTestServer(t, execServer)
+	httpServer := httptest.NewServer(http.HandlerFunc(server.terminalHandler))
+	defer httpServer.Close()
+
+	// Without a TerminalConfig bash is tried before sh
+	term := dialTerminal(t, httpServer.URL, "namespace=team-a&pod=web-0")
+	term.expectOutput("bash sh")
+	term.expectExit()
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/team-a/pods/web-0/exec" || req.Command[0] != "/bin/sh" || !req.TTY {
+		t.Errorf("Unexpected exec request %s %v", req.Path, req.Command)
+	}
+
+	term = dialTerminal(t, httpServer.URL, "pod=web-0&shell=zsh,fish&shell=ash")
+	term.expectOutput("fish ash")
+	term.expectExit()
+	if req := execServer.lastRequest(t); req.Path != "/api/v1/namespaces/default/pods/web-0/exec" || len(req.Command) != 7 || req.Command[4] != "zsh" {
+		t.Errorf("Unexpected exec request %s %v", req.Path, req.Command)
+	}
+
+	rec := httptest.NewRecorder()
+	server.terminalHandler(rec, httptest.NewRequest(http.MethodGet, "/api/terminal?namespace=team-a", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a config or pod, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+)
+
+const (
+	// executionRetention is how long a finished execution can still be streamed
+	executionRetention = 10 * time.Minute
+	// maxExecutionEvents bounds the events kept for replay. Older events are dropped
+	// first, so late subscribers only see the most recent output.
+	maxExecutionEvents = 10000
+)
+
+// ExecutionResponse is returned when a script execution is started
+type ExecutionResponse struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	EventsURL string `json:"eventsUrl"`
+}
+
+// ExecutionStatus describes a script execution
+type ExecutionStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Namespace  string     `json:"namespace"`
+	PodName    string     `json:"podName,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Status     string     `json:"status"`
+	ExitCode   *int       `json:"exitCode,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// scriptExecution records the events of a running or finished script. Subscribers read
+// events by sequence number and wait on changed, which is closed and replaced whenever
+// an event is added.
+type scriptExecution struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	status  ExecutionStatus
+	events  []ScriptEvent
+	first   int // sequence number of events[0]
+	changed chan struct{}
+	done    bool
+}
+
+// add records an event and wakes up subscribers
+func (e *scriptExecution) add(event ScriptEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	e.events = append(e.events, event)
+	if len(e.events) > maxExecutionEvents {
+		e.events = e.events[1:]
+		e.first++
+	}
+	if event.Status != "" {
+		e.done = true
+		finishedAt := event.Time
+		e.status.Status, e.status.ExitCode, e.status.Error, e.status.FinishedAt = event.Status, event.ExitCode, event.Error, &finishedAt
+	}
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// eventsSince returns the events with sequence numbers from next on, the sequence number
+// of the first returned event, a channel that is closed when more events arrive and whether
+// the execution has finished
+func (e *scriptExecution) eventsSince(next int) ([]ScriptEvent, int, <-chan struct{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if next < e.first {
+		next = e.first
+	}
+	var events []ScriptEvent
+	if offset := next - e.first; offset < len(e.events) {
+		events = append(events, e.events[offset:]...)
+	}
+	return events, next, e.changed, e.done
+}
+
+func (e *scriptExecution) snapshot() ExecutionStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// lineWriter turns output written in arbitrary chunks into one event per line
+type lineWriter struct {
+	execution *scriptExecution
+	stream    string
+	mu        sync.Mutex
+	partial   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.execution.add(ScriptEvent{Time: time.Now(), Stream: w.stream, Data: string(w.partial[:i])})
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits a final line that did not end in a newline
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.execution.add(ScriptEvent{Time: time.Now(), Stream: w.stream, Data: string(w.partial)})
+		w.partial = nil
+	}
+}
+
+// executionManager tracks script executions so they can be streamed and cancelled
+type executionManager struct {
+	mu         sync.Mutex
+	executions map[string]*scriptExecution
+	// audit receives an event when a script finishes
+	audit *audit.Logger
+}
+
+func newExecutionManager() *executionManager {
+	return &executionManager{executions: make(map[string]*scriptExecution)}
+}
+
+// scriptRunner runs a script to completion and returns its exit code
+type scriptRunner func(ctx context.Context, stdout, stderr *lineWriter) (int, error)
+
+// start runs a script in the background with the given timeout
+func (m *executionManager) start(parent context.Context, req ScriptRequest, timeout time.Duration, run scriptRunner) *scriptExecution {
+	// The script outlives the request but keeps its values, such as the user
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), timeout)
+	user := ""
+	if u, ok := auth.UserFrom(parent); ok {
+		user = u.Name
+	}
+	execution := &scriptExecution{
+		cancel: cancel,
+		status: ExecutionStatus{
+			ID:        newID(),
+			Type:      req.Type,
+			Namespace: req.Namespace,
+			PodName:   req.PodName,
+			User:      user,
+			Status:    ScriptRunning,
+			StartedAt: time.Now(),
+		},
+		changed: make(chan struct{}),
+	}
+	id := execution.status.ID
+
+	m.mu.Lock()
+	m.executions[id] = execution
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		stdout := &lineWriter{execution: execution, stream: "stdout"}
+		stderr := &lineWriter{execution: execution, stream: "stderr"}
+		code, err := run(ctx, stdout, stderr)
+		stdout.flush()
+		stderr.flush()
+
+		result := scriptResult(ctx, code, err)
+		result.Time = time.Now()
+		execution.add(result)
+		log.Printf("Script execution %s finished: %s", id, result.Status)
+		m.auditFinished(parent, req, execution.snapshot())
+
+		time.AfterFunc(executionRetention, func() {
+			m.mu.Lock()
+			delete(m.executions, id)
+			m.mu.Unlock()
+		})
+	}()
+
+	return execution
+}
+
+// auditFinished logs the outcome of a script execution started by the request in ctx
+func (m *executionManager) auditFinished(ctx context.Context, req ScriptRequest, status ExecutionStatus) {
+	outcome := audit.OutcomeFailure
+	if status.Status == ScriptSucceeded {
+		outcome = audit.OutcomeSuccess
+	}
+	m.audit.Log(audit.Event{
+		User:       status.User,
+		SourceIP:   audit.RecordFrom(ctx).Event().SourceIP,
+		Action:     "script.finish",
+		Namespace:  req.Namespace,
+		Pod:        req.PodName,
+		Container:  req.Container,
+		Name:       status.ID,
+		Outcome:    outcome,
+		ExitCode:   status.ExitCode,
+		Error:      status.Error,
+		DurationMs: time.Since(status.StartedAt).Milliseconds(),
+	})
+}
+
+func (m *executionManager) get(id string) (*scriptExecution, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	execution, ok := m.executions[id]
+	return execution, ok
+}
+
+// lookupExecution returns the execution named in the request path or writes a 404
+func (s *Server) lookupExecution(w http.ResponseWriter, r *http.Request) (*scriptExecution, bool) {
+	execution, ok := s.executions.get(mux.Vars(r)["id"])
+	// Executions are private to the user who started them
+	if ok && execution.snapshot().User != requestUser(r) {
+		ok = false
+	}
+	if !ok {
+		http.Error(w, "Execution not found", http.StatusNotFound)
+		return nil, false
+	}
+	status := execution.snapshot()
+	audit.RecordFrom(r.Context()).SetTarget(status.Namespace, status.PodName, "")
+	return execution, true
+}
+
+// getExecutionHandler returns the status of a script execution
+func (s *Server) getExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	execution, ok := s.lookupExecution(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(execution.snapshot())
+}
+
+// cancelExecutionHandler stops a running script execution. Cancelling a finished
+// execution has no effect.
+func (s *Server) cancelExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	execution, ok := s.lookupExecution(w, r)
+	if !ok {
+		return
+	}
+	execution.cancel()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// executionEventsHandler streams the events of a script execution as Server-Sent Events.
+// Earlier events are replayed first. Each event's id is its sequence number, so a client
+// that reconnects with Last-Event-ID only receives events it has not seen.
+func (s *Server) executionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	execution, ok := s.lookupExecution(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	next := 0
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = lastID + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, seq, changed, done := execution.eventsSince(next)
+		for _, event := range events {
+			if err := writeSSE(w, seq, event); err != nil {
+				return
+			}
+			seq++
+		}
+		next = seq
+		flusher.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes a script event as a Server-Sent Event. Output events are sent as
+// "output" events and the final event as a "status" event.
+func writeSSE(w http.ResponseWriter, id int, event ScriptEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	name := "output"
+	if event.Status != "" {
+		name = "status"
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, name, data)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/client"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// clientFactory builds the Kubernetes clients used on behalf of a user. Requests made with
+// them impersonate the user, so the API server applies the user's RBAC permissions.
+type clientFactory struct {
+	config     *rest.Config
+	namespace  string
+	newDynamic func(*rest.Config) (dynamic.Interface, error)
+}
+
+func newClientFactory(config *rest.Config, namespace string) *clientFactory {
+	return &clientFactory{
+		config:    config,
+		namespace: namespace,
+		newDynamic: func(config *rest.Config) (dynamic.Interface, error) {
+			return dynamic.NewForConfig(config)
+		},
+	}
+}
+
+// impersonationConfig returns a copy of config that acts as user
+func impersonationConfig(config *rest.Config, user *auth.User) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: user.Name,
+		UID:      user.UID,
+		Groups:   user.Groups,
+		Extra:    user.Extra,
+	}
+	return config
+}
+
+// forUser returns a copy of the server whose clients impersonate the authenticated user
+// of ctx. Without a client factory, when authentication is disabled, the server's own
+// identity is used.
+func (s *Server) forUser(ctx context.Context) (*Server, error) {
+	if s.clients == nil {
+		return s, nil
+	}
+	user, ok := auth.UserFrom(ctx)
+	if !ok || user.Name == "" {
+		return nil, fmt.Errorf("no authenticated user")
+	}
+
+	config := impersonationConfig(s.clients.config, user)
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := s.clients.newDynamic(config)
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := *s
+	scoped.kubeClient = kubeClient
+	scoped.restConfig = config
+	scoped.terminalClient = client.NewTerminalConfigClientFromDynamic(dynamicClient, s.clients.namespace)
+	scoped.scriptClient = client.NewTerminalScriptClientFromDynamic(dynamicClient, s.clients.namespace)
+	return &scoped, nil
+}
+
+// requestUser returns the name of the request's authenticated user, if any
+func requestUser(r *http.Request) string {
+	if user, ok := auth.UserFrom(r.Context()); ok {
+		return user.Name
+	}
+	return ""
+}
+
+// asUser adapts a handler to run with the clients of the request's user
+func (s *Server) asUser(handler func(*Server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scoped, err := s.forUser(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create clients for user: %v", err), http.StatusInternalServerError)
+			return
+		}
+		handler(scoped, w, r)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+var testUsers = tokenAuthenticator{
+	"alice-token": {Name: "alice", UID: "42", Groups: []string{"dev", "system:authenticated"}, Extra: map[string][]string{"scopes": {"pods"}}},
+	"bob-token":   {Name: "bob"},
+}
+
+// authorizedRequest builds a request carrying the bearer token of a test user
+func authorizedRequest(t *testing.T, method, url, token, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestPodsAreListedAsUser(t *testing.T) {
+	var mu sync.Mutex
+	var header http.Header
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		header = r.Header.Clone()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+	}))
+	defer apiServer.Close()
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.clients.config = &rest.Config{Host: apiServer.URL}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/pods", "alice-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %s", resp.Status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := header.Get("Impersonate-User"); got != "alice" {
+		t.Errorf("Expected to impersonate alice, got %q", got)
+	}
+	if got := header.Get("Impersonate-Uid"); got != "42" {
+		t.Errorf("Expected UID 42, got %q", got)
+	}
+	if got := strings.Join(header.Values("Impersonate-Group"), ","); got != "dev,system:authenticated" {
+		t.Errorf("Unexpected groups %q", got)
+	}
+	if got := header.Get("Impersonate-Extra-Scopes"); got != "pods" {
+		t.Errorf("Unexpected extra %q", got)
+	}
+	if server.restConfig.Impersonate.UserName != "" {
+		t.Error("Impersonation must not change the server's own config")
+	}
+}
+
+func TestPodsReportsListErrors(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403,` +
+			`"message":"pods is forbidden: User cannot list resource pods"}`))
+	}))
+	defer apiServer.Close()
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.clients.config = &rest.Config{Host: apiServer.URL}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/pods", "alice-token", ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	response := decodeForbidden(t, resp.StatusCode, string(body))
+	if !strings.Contains(response.Error, "cannot list resource") {
+		t.Errorf("Expected the API server's error, got %+v", response)
+	}
+}
+
+func TestTerminalConfigsReportForbidden(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403,` +
+			`"message":"terminalconfigs is forbidden: User cannot access terminalconfigs"}`))
+	}))
+	defer apiServer.Close()
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	server.clients.config = &rest.Config{Host: apiServer.URL}
+	server.clients.newDynamic = func(config *rest.Config) (dynamic.Interface, error) {
+		return dynamic.NewForConfig(config)
+	}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	for _, tc := range []struct{ method, path, body string }{
+		{"GET", "/api/terminalconfigs", ""},
+		{"GET", "/api/terminalconfigs/dev", ""},
+		{"POST", "/api/terminalconfigs", `{"metadata":{"name":"dev"}}`},
+		{"GET", "/api/terminal?config=dev", ""},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			resp, err := http.DefaultClient.Do(authorizedRequest(t, tc.method, httpServer.URL+tc.path, "alice-token", tc.body))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if response := decodeForbidden(t, resp.StatusCode, string(body)); !strings.Contains(response.Error, "forbidden") {
+				t.Errorf("Expected the API server's error, got %+v", response)
+			}
+		})
+	}
+}
+
+func TestTerminalExecsAsUser(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/terminal?config=dev"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer bob-token"}})
+	if err != nil {
+		t.Fatalf("Failed to open terminal: %v", err)
+	}
+	defer conn.Close()
+	// The server closes the connection once the process has exited
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	if got := execServer.lastRequest(t).Header.Get("Impersonate-User"); got != "bob" {
+		t.Errorf("Expected exec to impersonate bob, got %q", got)
+	}
+}
+
+func TestForUserRequiresUser(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	if _, err := server.forUser(context.Background()); err == nil {
+		t.Error("Expected an error without an authenticated user")
+	}
+
+	// Without a client factory the server's own identity is used
+	server.clients = nil
+	scoped, err := server.forUser(auth.WithUser(context.Background(), &auth.User{Name: "alice"}))
+	if err != nil || scoped != server {
+		t.Errorf("Expected the server itself, got %v, %v", scoped, err)
+	}
+}
+
+func TestExecutionsArePrivate(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	body := `{"type":"bash","script":"echo hi","podName":"tools-0"}`
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "POST", httpServer.URL+"/api/execute-script", "alice-token", body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var execution ExecutionResponse
+	json.NewDecoder(resp.Body).Decode(&execution)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %s", resp.Status)
+	}
+
+	for token, want := range map[string]int{"alice-token": http.StatusOK, "bob-token": http.StatusNotFound} {
+		resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+"/api/executions/"+execution.ID, token, ""))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET execution with %s: expected %d, got %s", token, want, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+)
+
+// maxLimitWarning is how long before a limit is reached the user is warned
+const maxLimitWarning = time.Minute
+
+// sessionLimits bounds how long a terminal session stays open. Zero means no limit.
+type sessionLimits struct {
+	Idle time.Duration
+	Max  time.Duration
+}
+
+// envDuration parses a non-negative duration environment variable such as "15m"
+func envDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// newSessionLimits reads the server-wide limits from TERMINAL_IDLE_TIMEOUT and
+// TERMINAL_MAX_DURATION
+func newSessionLimits() (sessionLimits, error) {
+	idle, err := envDuration("TERMINAL_IDLE_TIMEOUT", 0)
+	if err != nil {
+		return sessionLimits{}, err
+	}
+	maxDuration, err := envDuration("TERMINAL_MAX_DURATION", 0)
+	if err != nil {
+		return sessionLimits{}, err
+	}
+	return sessionLimits{Idle: idle, Max: maxDuration}, nil
+}
+
+// forConfig applies the limits of a TerminalConfig. The shorter of each pair wins, so a
+// TerminalConfig can tighten but not lift the server-wide limits.
+func (l sessionLimits) forConfig(tc *terminalv1.TerminalConfig) sessionLimits {
+	if spec := tc.Spec.SessionLimits; spec != nil {
+		if spec.IdleTimeout != nil {
+			l.Idle = shorterLimit(l.Idle, spec.IdleTimeout.Duration)
+		}
+		if spec.MaxDuration != nil {
+			l.Max = shorterLimit(l.Max, spec.MaxDuration.Duration)
+		}
+	}
+	return l
+}
+
+// shorterLimit returns the shorter of two limits, where zero means no limit
+func shorterLimit(a, b time.Duration) time.Duration {
+	if a <= 0 || b > 0 && b < a {
+		return b
+	}
+	return a
+}
+
+// limitWarning is how long before a limit the user is warned: a minute, or half of
+// shorter limits
+func limitWarning(limit time.Duration) time.Duration {
+	return min(maxLimitWarning, limit/2)
+}
+
+// enforceLimits closes the session when it has been idle or open for too long, writing a
+// warning into the terminal shortly before. Only keystrokes and output count as activity.
+// It returns when the session is closed.
+func (t *TerminalSession) enforceLimits(limits sessionLimits) {
+	if limits.Idle <= 0 && limits.Max <= 0 {
+		return
+	}
+	started := time.Now()
+	var idleWarned, maxWarned time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-timer.C:
+		}
+
+		// Act on the deadlines that passed and wake up for the next one
+		now := time.Now()
+		var next time.Duration
+		schedule := func(at time.Time) {
+			if wait := at.Sub(now); wait > 0 && (next == 0 || wait < next) {
+				next = wait
+			}
+		}
+
+		if limits.Max > 0 {
+			deadline := started.Add(limits.Max)
+			warnAt := deadline.Add(-limitWarning(limits.Max))
+			if !now.Before(deadline) {
+				t.terminate(CloseMaxDuration, "maximum session duration reached",
+					fmt.Sprintf("Session reached its maximum duration of %s, disconnecting.", limits.Max))
+				return
+			}
+			if !now.Before(warnAt) && maxWarned.IsZero() {
+				maxWarned = now
+				t.writeNotice(fmt.Sprintf("Session reaches its maximum duration of %s, disconnecting in %s.",
+					limits.Max, deadline.Sub(now).Round(time.Second)))
+			}
+			schedule(warnAt)
+			schedule(deadline)
+		}
+
+		if limits.Idle > 0 {
+			active := time.Unix(0, t.lastActivity.Load())
+			deadline := active.Add(limits.Idle)
+			warnAt := deadline.Add(-limitWarning(limits.Idle))
+			if !now.Before(deadline) {
+				t.terminate(CloseIdleTimeout, "idle timeout",
+					fmt.Sprintf("Session was idle for %s, disconnecting.", limits.Idle))
+				return
+			}
+			// Warn again if there was activity since the last warning
+			if !now.Before(warnAt) && !idleWarned.After(active) {
+				idleWarned = now
+				t.writeNotice(fmt.Sprintf("Session is idle, disconnecting in %s unless there is input or output.",
+					deadline.Sub(now).Round(time.Second)))
+			}
+			schedule(warnAt)
+			schedule(deadline)
+		}
+		timer.Reset(next)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSessionLimitsForConfig(t *testing.T) {
+	withLimits := func(idle, max time.Duration) *terminalv1.TerminalConfig {
+		limits := &terminalv1.SessionLimits{}
+		if idle > 0 {
+			limits.IdleTimeout = &metav1.Duration{Duration: idle}
+		}
+		if max > 0 {
+			limits.MaxDuration = &metav1.Duration{Duration: max}
+		}
+		return &terminalv1.TerminalConfig{Spec: terminalv1.TerminalConfigSpec{SessionLimits: limits}}
+	}
+	server := sessionLimits{Idle: 30 * time.Minute, Max: 8 * time.Hour}
+
+	tests := []struct {
+		name   string
+		global sessionLimits
+		config *terminalv1.TerminalConfig
+		want   sessionLimits
+	}{
+		{name: "no limits", config: &terminalv1.TerminalConfig{}, want: sessionLimits{}},
+		{name: "server wide", global: server, config: &terminalv1.TerminalConfig{}, want: server},
+		{name: "config only", config: withLimits(time.Minute, time.Hour), want: sessionLimits{Idle: time.Minute, Max: time.Hour}},
+		{name: "config tightens", global: server, config: withLimits(10*time.Minute, 0), want: sessionLimits{Idle: 10 * time.Minute, Max: 8 * time.Hour}},
+		{name: "config cannot lift", global: server, config: withLimits(time.Hour, 24*time.Hour), want: server},
+	}
+	for _, test := range tests {
+		if got := test.global.forConfig(test.config); got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+// expectClose reads until the server closes the connection and returns the close error
+func (tt *testTerminal) expectClose() *websocket.CloseError {
+	tt.t.Helper()
+	// The server may already be gone when the close frame is answered
+	tt.conn.SetCloseHandler(func(code int, text string) error {
+		tt.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		return nil
+	})
+	for {
+		tt.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		messageType, data, err := tt.conn.ReadMessage()
+		if err != nil {
+			closeErr, ok := err.(*websocket.CloseError)
+			if !ok {
+				tt.t.Fatalf("Expected a close frame (output so far %q), got %v", tt.output.String(), err)
+			}
+			return closeErr
+		}
+		if messageType == websocket.BinaryMessage {
+			tt.output.Write(data)
+		}
+	}
+}
+
+func TestTerminalSessionLimits(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		// Echo until the stream is closed
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer,
+		terminalConfigObject(t, &terminalv1.TerminalConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "idle", Namespace: "default"},
+		}),
+		terminalConfigObject(t, &terminalv1.TerminalConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "short", Namespace: "default"},
+			Spec: terminalv1.TerminalConfigSpec{SessionLimits: &terminalv1.SessionLimits{
+				MaxDuration: &metav1.Duration{Duration: 800 * time.Millisecond},
+			}},
+		}),
+	)
+	server.sessionLimits = sessionLimits{Idle: 600 * time.Millisecond}
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// Activity postpones the idle timeout, and the user is warned before it
+	term := dialTerminalAs(t, httpServer.URL, "config=idle", "alice-token")
+	term.stdin("a")
+	term.expectOutput("a")
+	time.Sleep(400 * time.Millisecond)
+	term.stdin("b")
+	term.expectOutput("b")
+	closeErr := term.expectClose()
+	if closeErr.Code != CloseIdleTimeout || closeErr.Text != "idle timeout" {
+		t.Errorf("Expected the idle timeout close code, got %v", closeErr)
+	}
+	output := term.output.String()
+	if !strings.Contains(output, "Session is idle, disconnecting in") || !strings.Contains(output, "Session was idle for 600ms") {
+		t.Errorf("Expected an idle warning and notice, got %q", output)
+	}
+
+	// The maximum duration ends the session despite activity
+	term = dialTerminalAs(t, httpServer.URL, "config=short", "alice-token")
+	started := time.Now()
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				term.conn.WriteMessage(websocket.BinaryMessage, []byte("."))
+			}
+		}
+	}()
+	closeErr = term.expectClose()
+	close(stop)
+	if closeErr.Code != CloseMaxDuration || closeErr.Text != "maximum session duration reached" {
+		t.Errorf("Expected the maximum duration close code, got %v", closeErr)
+	}
+	if elapsed := time.Since(started); elapsed < 700*time.Millisecond {
+		t.Errorf("Session closed after %s, before its maximum duration", elapsed)
+	}
+	output = term.output.String()
+	if !strings.Contains(output, "Session reaches its maximum duration of 800ms") || !strings.Contains(output, "Session reached its maximum duration") {
+		t.Errorf("Expected a warning and notice, got %q", output)
+	}
+}
@@ -2,35 +2,38 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
 	"github.com/jraymond/kubernetes-web-terminal/pkg/client"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/controller"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/policy"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from any origin
-		return true
-	},
-}
-
-type TerminalSession struct {
-	wsConn   *websocket.Conn
-	sizeChan chan remotecommand.TerminalSize
+	CheckOrigin: checkOrigin,
 }
 
 // Pod and script related types
@@ -38,11 +41,22 @@ type Pod struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
 	Status    string `json:"status"`
+	// DefaultContainer is used when a request names no container
+	DefaultContainer string          `json:"defaultContainer,omitempty"`
+	Containers       []ContainerInfo `json:"containers,omitempty"`
 }
 
+// ScriptRequest runs a script in the named pod, or in a new Job when PodName is empty.
+// Parameters holds values for the parameters declared in ParameterDefinitions.
 type ScriptRequest struct {
-	Script string `json:"script"`
-	Type   string `json:"type"`
+	Script               string                       `json:"script"`
+	Type                 string                       `json:"type"`
+	PodName              string                       `json:"podName,omitempty"`
+	Namespace            string                       `json:"namespace,omitempty"`
+	Container            string                       `json:"container,omitempty"`
+	TimeoutSeconds       int                          `json:"timeoutSeconds,omitempty"`
+	ParameterDefinitions []terminalv1.ScriptParameter `json:"parameterDefinitions,omitempty"`
+	Parameters           map[string]interface{}       `json:"parameters,omitempty"`
 }
 
 // File upload related types
@@ -55,22 +69,50 @@ type MountRequest struct {
 	FileID     string `json:"fileId"`
 	PodName    string `json:"podName"`
 	Namespace  string `json:"namespace"`
+	Container  string `json:"container,omitempty"`
 	TargetPath string `json:"targetPath"`
 }
 
 type MountResponse struct {
-	TargetPath string `json:"targetPath"`
+	TargetPath   string `json:"targetPath"`
+	BytesWritten int64  `json:"bytesWritten"`
+	Checksum     string `json:"checksum"`
 }
 
 type Server struct {
-	kubeClient       *kubernetes.Clientset
-	terminalClient   *client.TerminalConfigClient
-	namespace        string
+	kubeClient     kubernetes.Interface
+	terminalClient *client.TerminalConfigClient
+	scriptClient   *client.TerminalScriptClient
+	restConfig     *rest.Config
+	namespace      string
+	executions     *executionManager
+	sessions       *sessionManager
+	// clients builds the clients that act as the caller and authorizer gates exec and
+	// script Jobs; both are nil when authentication is disabled
+	clients    *clientFactory
+	authorizer auth.Authorizer
+	// recordings stores terminal session recordings
+	recordings recording.Storage
+	// audit receives an event for every API action and, if auditCommands is set, for
+	// every command typed in a terminal after redacting secrets
+	audit         *audit.Logger
+	auditCommands bool
+	redactor      *audit.Redactor
+	// commandPolicy holds the cluster-wide command deny rules
+	commandPolicy *policy.Policy
+	// sessionLimits are the server-wide idle and duration limits of terminals
+	sessionLimits sessionLimits
+	// detachGrace is how long terminals wait for a disconnected browser to reattach,
+	// replaying up to scrollback bytes of output; zero ends them on disconnect
+	detachGrace time.Duration
+	scrollback  int
+	// debugImages are the images of debug containers users may pick, the default first
+	debugImages []string
 }
 
 func main() {
 	// Create uploads directory if it doesn't exist
-	if err := os.MkdirAll("./uploads", 0755); err != nil {
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		log.Printf("Warning: Could not create uploads directory: %v", err)
 	}
 
@@ -94,39 +136,161 @@ func main() {
 		log.Fatal(err)
 	}
 
+	scriptClient, err := client.NewTerminalScriptClient(config, namespace)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Provision a terminal pod for every TerminalConfig unless another replica does it
+	if os.Getenv("DISABLE_CONTROLLER") != "true" {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		terminalController := controller.New(kubeClient, dynamicClient, namespace)
+		go func() {
+			if err := terminalController.Run(context.Background(), 2); err != nil {
+				log.Printf("TerminalConfig controller stopped: %v", err)
+			}
+		}()
+	}
+
+	if dir := os.Getenv("RECORDINGS_DIR"); dir != "" {
+		recordingsDir = dir
+	}
+	recordings, err := recording.NewLocalStorage(recordingsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditLog, err := newAuditLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+	redactor, err := newRedactor()
+	if err != nil {
+		log.Fatal(err)
+	}
+	commandPolicy, err := newCommandPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits, err := newSessionLimits()
+	if err != nil {
+		log.Fatal(err)
+	}
+	detachGrace, err := envDuration("TERMINAL_DETACH_GRACE", defaultDetachGrace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scrollback, err := envInt("TERMINAL_SCROLLBACK_BYTES", defaultScrollback)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploadTTL, err := envDuration("UPLOAD_TTL", defaultUploadTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uploadTTL > 0 {
+		go expireUploads(context.Background(), uploadTTL)
+	}
+	executions := newExecutionManager()
+	executions.audit = auditLog
+
 	server := &Server{
 		kubeClient:     kubeClient,
 		terminalClient: terminalClient,
+		scriptClient:   scriptClient,
+		restConfig:     config,
 		namespace:      namespace,
+		executions:     executions,
+		sessions:       newSessionManager(),
+		recordings:     recordings,
+		audit:          auditLog,
+		auditCommands:  os.Getenv("AUDIT_COMMANDS") != "false",
+		redactor:       redactor,
+		commandPolicy:  commandPolicy,
+		sessionLimits:  limits,
+		detachGrace:    detachGrace,
+		scrollback:     scrollback,
+		debugImages:    newDebugImages(),
+	}
+
+	authenticator, oidc, err := newAuthenticator(context.Background(), kubeClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, disabled := authenticator.(auth.Anonymous); !disabled {
+		server.clients = newClientFactory(config, namespace)
+		server.authorizer = auth.NewSubjectAccessReviewAuthorizer(kubeClient)
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
 	}
 
+	fmt.Printf("Server starting on port %s...\n", port)
+	log.Fatal(http.ListenAndServe(":"+port, server.routes(authenticator, oidc)))
+}
+
+// routes registers the handlers. Every /api route, including the terminal WebSocket,
+// requires an authenticated user and talks to Kubernetes as that user; oidc adds the
+// login endpoints when it is configured. API route names are the actions in the audit log.
+func (s *Server) routes(authenticator auth.Authenticator, oidc *auth.OIDCAuthenticator) *mux.Router {
 	router := mux.NewRouter()
 
 	// Serve static files
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
+	loginURL := ""
+	if oidc != nil {
+		loginURL = "/auth/login"
+		router.HandleFunc("/auth/login", oidc.LoginHandler).Methods("GET")
+		router.HandleFunc("/auth/callback", oidc.CallbackHandler).Methods("GET")
+		router.HandleFunc("/auth/logout", oidc.LogoutHandler).Methods("POST")
+	}
+	router.HandleFunc("/auth/me", auth.WhoAmIHandler(authenticator, loginURL)).Methods("GET")
+
 	// API endpoints - combine both file upload and TerminalConfig APIs
-	router.HandleFunc("/api/pods", server.getPodsHandler).Methods("GET")
-	router.HandleFunc("/api/upload", uploadHandler).Methods("POST")
-	router.HandleFunc("/api/mount", mountHandler).Methods("POST")
-	router.HandleFunc("/api/terminalconfigs", server.getTerminalConfigsHandler).Methods("GET")
-	router.HandleFunc("/api/terminalconfigs/{name}", server.getTerminalConfigHandler).Methods("GET")
-	router.HandleFunc("/api/terminalconfigs", server.createTerminalConfigHandler).Methods("POST")
-	router.HandleFunc("/api/terminal", server.terminalHandler).Methods("GET")
-	router.HandleFunc("/api/execute-script", executeScriptHandler).Methods("POST")
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(s.auditRequests, auth.Middleware(authenticator, loginURL), auditUser)
+	api.HandleFunc("/pods", s.asUser((*Server).getPodsHandler)).Methods("GET").Name("pods.list")
+	api.HandleFunc("/upload", uploadHandler).Methods("POST").Name("file.upload")
+	api.HandleFunc("/mount", s.asUser((*Server).mountHandler)).Methods("POST").Name("file.mount")
+	api.HandleFunc("/terminalconfigs", s.asUser((*Server).getTerminalConfigsHandler)).Methods("GET").Name("terminalconfig.list")
+	api.HandleFunc("/terminalconfigs/{name}", s.asUser((*Server).getTerminalConfigHandler)).Methods("GET").Name("terminalconfig.get")
+	api.HandleFunc("/terminalconfigs", s.asUser((*Server).createTerminalConfigHandler)).Methods("POST").Name("terminalconfig.create")
+	api.HandleFunc("/terminal", s.asUser((*Server).terminalHandler)).Methods("GET").Name("terminal.open")
+	api.HandleFunc("/debug", s.asUser((*Server).debugHandler)).Methods("GET").Name("terminal.debug")
+	api.HandleFunc("/sessions", s.listSessionsHandler).Methods("GET").Name("session.list")
+	api.HandleFunc("/sessions/{id}", s.deleteSessionHandler).Methods("DELETE").Name("session.terminate")
+	api.HandleFunc("/sessions/{id}/attach", s.attachSessionHandler).Methods("GET").Name("session.attach")
+	api.HandleFunc("/sessions/{id}/invites", s.createInviteHandler).Methods("POST").Name("session.invite")
+	api.HandleFunc("/sessions/{id}/invites/{invite}", s.revokeInviteHandler).Methods("DELETE").Name("session.revoke")
+	api.HandleFunc("/sessions/{id}/viewers/{viewer}", s.removeViewerHandler).Methods("DELETE").Name("session.kick")
+	api.HandleFunc("/sessions/{id}/join", s.joinSessionHandler).Methods("GET").Name("session.join")
+	api.HandleFunc("/execute-script", s.asUser((*Server).executeScriptHandler)).Methods("POST").Name("script.execute")
+	api.HandleFunc("/scripts", s.asUser((*Server).listTerminalScriptsHandler)).Methods("GET").Name("script.list")
+	api.HandleFunc("/scripts", s.asUser((*Server).createTerminalScriptHandler)).Methods("POST").Name("script.create")
+	api.HandleFunc("/scripts/{name}", s.asUser((*Server).getTerminalScriptHandler)).Methods("GET").Name("script.get")
+	api.HandleFunc("/scripts/{name}", s.asUser((*Server).updateTerminalScriptHandler)).Methods("PUT").Name("script.update")
+	api.HandleFunc("/scripts/{name}", s.asUser((*Server).deleteTerminalScriptHandler)).Methods("DELETE").Name("script.delete")
+	api.HandleFunc("/scripts/{name}/run", s.asUser((*Server).runTerminalScriptHandler)).Methods("POST").Name("script.run")
+	api.HandleFunc("/executions/{id}", s.getExecutionHandler).Methods("GET").Name("execution.get")
+	api.HandleFunc("/executions/{id}", s.cancelExecutionHandler).Methods("DELETE").Name("execution.cancel")
+	api.HandleFunc("/executions/{id}/events", s.executionEventsHandler).Methods("GET").Name("execution.events")
+	api.HandleFunc("/recordings", s.listRecordingsHandler).Methods("GET").Name("recording.list")
+	api.HandleFunc("/recordings/{id}", s.getRecordingHandler).Methods("GET").Name("recording.get")
+	api.HandleFunc("/recordings/{id}/download", s.downloadRecordingHandler).Methods("GET").Name("recording.download")
+	api.HandleFunc("/recordings/{id}/play", s.playRecordingHandler).Methods("GET").Name("recording.play")
+	api.HandleFunc("/audit", s.listAuditEventsHandler).Methods("GET").Name("audit.list")
 
 	// Serve index.html for root path
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./static/index.html")
 	})
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	fmt.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	return router
 }
 
 func getKubeConfig() (*rest.Config, error) {
@@ -152,37 +316,32 @@ func getKubeClient() (*kubernetes.Clientset, error) {
 }
 
 func (s *Server) getPodsHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	
+	ctx := r.Context()
+
 	// Allow namespace to be specified via query parameter, default to server namespace
 	namespace := r.URL.Query().Get("namespace")
 	if namespace == "" {
 		namespace = s.namespace
 	}
-	
+	audit.RecordFrom(r.Context()).SetTarget(namespace, "", "")
+
 	pods, err := s.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
-		// If no Kubernetes client available or listing fails, return mock pods for testing
-		log.Printf("Failed to list pods, returning mock pods: %v", err)
-		mockPods := []Pod{
-			{Name: "nginx-deployment-123", Namespace: "default", Status: "Running"},
-			{Name: "redis-server-456", Namespace: "default", Status: "Running"},
-			{Name: "web-app-789", Namespace: "production", Status: "Running"},
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"pods": mockPods,
-		})
+		http.Error(w, fmt.Sprintf("Failed to list pods: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	var podList []Pod
 	for _, pod := range pods.Items {
 		podList = append(podList, Pod{
-			Name:      pod.Name,
-			Namespace: pod.Namespace,
-			Status:    string(pod.Status.Phase),
+			Name:             pod.Name,
+			Namespace:        pod.Namespace,
+			Status:           string(pod.Status.Phase),
+			DefaultContainer: defaultContainer(&pod),
+			Containers:       podContainers(&pod),
 		})
 	}
 
@@ -207,14 +366,18 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	fileID := r.FormValue("fileId")
-	if fileID == "" {
-		http.Error(w, "Missing fileId", http.StatusBadRequest)
+	// The ID is always chosen here so clients cannot collide with or guess each other's uploads
+	fileID := newID()
+	audit.RecordFrom(r.Context()).SetName(fileID)
+
+	// Store the file with the uploader's other files, dropping any directories from the
+	// client supplied name
+	ownerDir := uploadOwnerDir(requestUser(r))
+	if err := os.MkdirAll(ownerDir, 0755); err != nil {
+		http.Error(w, "Failed to create file", http.StatusInternalServerError)
 		return
 	}
-
-	// Create file path
-	uploadPath := filepath.Join("./uploads", fileID+"_"+handler.Filename)
+	uploadPath := filepath.Join(ownerDir, fileID+"_"+filepath.Base(handler.Filename))
 
 	// Create the uploads file
 	dst, err := os.Create(uploadPath)
@@ -240,19 +403,69 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func mountHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) mountHandler(w http.ResponseWriter, r *http.Request) {
 	var req MountRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// For now, this is a simplified implementation
-	// In a real scenario, you would copy the file to the pod using kubectl cp or similar
-	log.Printf("Mount request: file %s to pod %s/%s at %s", req.FileID, req.Namespace, req.PodName, req.TargetPath)
+	if req.PodName == "" {
+		http.Error(w, "Missing podName", http.StatusBadRequest)
+		return
+	}
+	if req.Namespace == "" {
+		req.Namespace = s.namespace
+	}
+
+	uploadPath, err := findUpload(requestUser(r), req.FileID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Uploaded file %s not found", req.FileID), http.StatusNotFound)
+		return
+	}
+
+	targetPath, err := resolveTargetPath(req.TargetPath, uploadedFileName(uploadPath, req.FileID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	container, err := s.resolveContainer(r.Context(), req.Namespace, req.PodName, req.Container)
+	if err != nil {
+		http.Error(w, err.Error(), containerErrorStatus(err))
+		return
+	}
+	req.Container = container
+
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(req.Namespace, req.PodName, req.Container)
+	record.SetName(targetPath)
+
+	log.Printf("Copying file %s to pod %s/%s at %s", req.FileID, req.Namespace, req.PodName, targetPath)
+	result, err := s.copyFileToPod(r.Context(), execOptions{
+		Namespace: req.Namespace,
+		Pod:       req.PodName,
+		Container: req.Container,
+	}, uploadPath, targetPath)
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to copy file to pod: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	record.AddBytes(0, result.BytesWritten)
+
+	// The upload has served its purpose; anything left behind is removed by expireUploads
+	if err := os.Remove(uploadPath); err != nil {
+		log.Printf("Failed to remove upload %s: %v", uploadPath, err)
+	}
 
 	response := MountResponse{
-		TargetPath: req.TargetPath,
+		TargetPath:   targetPath,
+		BytesWritten: result.BytesWritten,
+		Checksum:     result.Checksum,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -260,8 +473,11 @@ func mountHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getTerminalConfigsHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	terminalConfigs, err := s.terminalClient.List(ctx)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to list TerminalConfigs: %v", err), http.StatusInternalServerError)
 		return
@@ -275,8 +491,11 @@ func (s *Server) getTerminalConfigHandler(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	ctx := context.Background()
+	ctx := r.Context()
 	terminalConfig, err := s.terminalClient.Get(ctx, name)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get TerminalConfig: %v", err), http.StatusNotFound)
 		return
@@ -300,6 +519,14 @@ func (s *Server) createTerminalConfigHandler(w http.ResponseWriter, r *http.Requ
 	if len(terminalConfig.Spec.Command) == 0 {
 		terminalConfig.Spec.Command = []string{"/bin/bash"}
 	}
+	if _, err := policy.ForConfig(&terminalConfig); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid command policy: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := controller.ValidateSecurityContext(terminalConfig.Spec.SecurityContext); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Set metadata
 	terminalConfig.APIVersion = terminalv1.SchemeGroupVersion.String()
@@ -307,9 +534,23 @@ func (s *Server) createTerminalConfigHandler(w http.ResponseWriter, r *http.Requ
 	if terminalConfig.Namespace == "" {
 		terminalConfig.Namespace = s.namespace
 	}
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(terminalConfig.Namespace, "", "")
+	record.SetConfig(terminalConfig.Name)
 
-	ctx := context.Background()
+	ctx := r.Context()
+	if mounts := fileMountAttributes(terminalConfig.Namespace, terminalConfig.Spec.FileMounts); len(mounts) > 0 {
+		if err := s.authorize(ctx, mounts...); err != nil {
+			if !writeForbidden(w, err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
 	created, err := s.terminalClient.Create(ctx, &terminalConfig)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create TerminalConfig: %v", err), http.StatusInternalServerError)
 		return
@@ -320,125 +561,269 @@ func (s *Server) createTerminalConfigHandler(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(created)
 }
 
+// terminalHandler opens a terminal for a TerminalConfig, or with only a pod given, a
+// shell in that pod
 func (s *Server) terminalHandler(w http.ResponseWriter, r *http.Request) {
 	// Get terminal config name from query parameter
 	terminalConfigName := r.URL.Query().Get("config")
-	if terminalConfigName == "" {
-		http.Error(w, "Missing 'config' query parameter", http.StatusBadRequest)
+	if terminalConfigName == "" && r.URL.Query().Get("pod") == "" {
+		http.Error(w, "Missing 'config' or 'pod' query parameter", http.StatusBadRequest)
 		return
 	}
-
-	// Retrieve the TerminalConfig
-	ctx := context.Background()
-	terminalConfig, err := s.terminalClient.Get(ctx, terminalConfigName)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get TerminalConfig: %v", err), http.StatusNotFound)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = TerminalModeExec
+	}
+	if mode != TerminalModeExec && mode != TerminalModeAttach {
+		http.Error(w, fmt.Sprintf("Unknown terminal mode %q, use %s or %s", mode, TerminalModeExec, TerminalModeAttach), http.StatusBadRequest)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
-		return
+	// Retrieve the TerminalConfig. The request context also carries the user the exec
+	// stream is authorized for. Shells opened directly in a pod have an empty config
+	// unless the pod belongs to a TerminalConfig, so only the server-wide command policy
+	// and limits apply and they are not recorded.
+	ctx := r.Context()
+	terminalConfig := &terminalv1.TerminalConfig{}
+	var err error
+	if terminalConfigName != "" {
+		terminalConfig, err = s.terminalClient.Get(ctx, terminalConfigName)
+		if writeForbidden(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get TerminalConfig: %v", err), http.StatusNotFound)
+			return
+		}
 	}
-	defer conn.Close()
 
-	// Create terminal session with file mount support
-	session := &TerminalSession{
-		wsConn:   conn,
-		sizeChan: make(chan remotecommand.TerminalSize),
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = terminalConfig.Namespace
+	}
+	if namespace == "" {
+		namespace = s.namespace
 	}
 
-	// Log the file mounts that would be applied
-	if len(terminalConfig.Spec.FileMounts) > 0 {
-		log.Printf("TerminalConfig %s has %d file mounts:", terminalConfigName, len(terminalConfig.Spec.FileMounts))
-		for i, mount := range terminalConfig.Spec.FileMounts {
-			log.Printf("  Mount %d: %s -> %s", i+1, mount.Name, mount.MountPath)
-			if mount.ConfigMapRef != nil {
-				log.Printf("    ConfigMap: %s", mount.ConfigMapRef.Name)
-			}
-			if mount.SecretRef != nil {
-				log.Printf("    Secret: %s", mount.SecretRef.SecretName)
-			}
-			if mount.VolumeRef != nil {
-				log.Printf("    Volume: %s", mount.VolumeRef.Name)
-			}
+	// Default to the pod provisioned for the TerminalConfig by the controller
+	podName := r.URL.Query().Get("pod")
+	containerName := r.URL.Query().Get("container")
+	if podName == "" {
+		podName = controller.PodName(terminalConfig.Name)
+		containerName = controller.ContainerName
+	}
+
+	sessionID := newID()
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(namespace, podName, containerName)
+	record.SetConfig(terminalConfig.Name)
+	record.SetSession(sessionID)
+
+	if err := s.authorize(r.Context(), podAttributes(namespace, podName, mode)); err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
 	}
 
-	// Send a welcome message showing the file mounts
-	welcomeMsg := fmt.Sprintf("Terminal session started for config: %s\n", terminalConfigName)
-	if len(terminalConfig.Spec.FileMounts) > 0 {
-		welcomeMsg += fmt.Sprintf("File mounts configured:\n")
-		for _, mount := range terminalConfig.Spec.FileMounts {
-			welcomeMsg += fmt.Sprintf("  - %s mounted at %s\n", mount.Name, mount.MountPath)
+	// Pods given by the browser may have several containers to pick from
+	if r.URL.Query().Get("pod") != "" {
+		containerName, err = s.resolveContainer(r.Context(), namespace, podName, containerName)
+		if err != nil {
+			http.Error(w, err.Error(), containerErrorStatus(err))
+			return
 		}
+		record.SetTarget(namespace, podName, containerName)
 	}
-	welcomeMsg += "$ "
-	
-	session.Write([]byte(welcomeMsg))
 
-	// Simple echo server for demonstration
-	for {
-		_, message, err := conn.ReadMessage()
+	// A pod provisioned for a TerminalConfig keeps its command policy, recording and
+	// session limits however it is opened
+	sessionConfig := terminalConfig
+	if r.URL.Query().Get("pod") != "" {
+		owner, err := s.podTerminalConfig(ctx, namespace, podName)
 		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
+			if !writeForbidden(w, err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
 		}
-		
-		// Echo the message back
-		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("WebSocket write error: %v", err)
-			break
+		if owner != nil {
+			sessionConfig = owner
+			record.SetConfig(owner.Name)
 		}
 	}
+
+	target := terminalTarget{
+		SessionID: sessionID,
+		Namespace: namespace,
+		Pod:       podName,
+		Container: containerName,
+		Config:    sessionConfig,
+		Mode:      mode,
+	}
+	if mode == TerminalModeAttach {
+		s.attachTerminal(w, r, target)
+		return
+	}
+
+	command := terminalCommand(terminalConfig.Spec.Command, terminalConfig.Spec.Args)
+	if terminalConfigName == "" {
+		command = shellCommand(terminalShells(r))
+	}
+	s.serveTerminal(w, r, target, func(ctx context.Context, session *TerminalSession) error {
+		log.Printf("Starting terminal for config %q in pod %s/%s: %v", terminalConfigName, namespace, podName, command)
+		return s.streamExec(ctx, execOptions{
+			Namespace: namespace,
+			Pod:       podName,
+			Container: containerName,
+			Command:   command,
+			Stdin:     session,
+			Stdout:    session,
+			TTY:       true,
+			SizeQueue: session,
+		})
+	})
 }
 
-// Next implements remotecommand.TerminalSizeQueue
-func (t *TerminalSession) Next() *remotecommand.TerminalSize {
-	size := <-t.sizeChan
-	return &size
+// terminalTarget is the container a terminal session runs in and the TerminalConfig whose
+// command policy, recording and limits apply to it
+type terminalTarget struct {
+	SessionID string
+	Namespace string
+	Pod       string
+	Container string
+	// Config supplies the command policy, recording and session limits
+	Config *terminalv1.TerminalConfig
+	// Mode is the subresource the terminal streams through, exec unless set
+	Mode string
 }
 
-// Read implements io.Reader
-func (t *TerminalSession) Read(p []byte) (int, error) {
-	_, message, err := t.wsConn.ReadMessage()
-	if err != nil {
-		return 0, err
+// serveTerminal upgrades the request to a terminal WebSocket and runs start with the
+// session as its streams. The session is guarded, recorded, limited and listed in the
+// session registry until start returns; its result is then sent to the browser.
+func (s *Server) serveTerminal(w http.ResponseWriter, r *http.Request, target terminalTarget, start func(ctx context.Context, session *TerminalSession) error) {
+	namespace, podName, containerName := target.Namespace, target.Pod, target.Container
+	mode := target.Mode
+	if mode == "" {
+		mode = TerminalModeExec
 	}
-	copy(p, message)
-	return len(message), nil
-}
+	terminalConfig, sessionID := target.Config, target.SessionID
+	record := audit.RecordFrom(r.Context())
 
-func executeScriptHandler(w http.ResponseWriter, r *http.Request) {
-	var req ScriptRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	input, err := s.newTerminalInput(terminalConfig, record, sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid command policy: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// For demonstration purposes, we'll simulate script execution
-	// In a real implementation, you would execute the script in a secure environment
+	recorder, err := s.startRecording(r.Context(), terminalConfig, namespace, podName, containerName)
+	if err != nil {
+		if terminalConfig.Spec.Recording.Required {
+			http.Error(w, fmt.Sprintf("Session recording is required but could not be started: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Continuing without recording the session in pod %s/%s: %v", namespace, podName, err)
+	}
+	defer func() {
+		if err := recorder.Close(); err != nil {
+			log.Printf("Recording of the session in pod %s/%s is incomplete: %v", namespace, podName, err)
+		}
+	}()
 
-	w.Header().Set("Content-Type", "text/plain")
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer conn.Close()
 
-	switch req.Type {
-	case "bash":
-		fmt.Fprintf(w, "Executing bash script:\n%s\n\n--- Simulated Output ---\nScript executed successfully!\nNote: In production, this would run in a controlled Kubernetes environment.", req.Script)
-	case "python":
-		fmt.Fprintf(w, "Executing python script:\n%s\n\n--- Simulated Output ---\nPython script executed successfully!\nNote: In production, this would run in a controlled Kubernetes environment.", req.Script)
-	case "kubectl":
-		fmt.Fprintf(w, "Executing kubectl commands:\n%s\n\n--- Simulated Output ---\nkubectl commands executed successfully!\nNote: In production, this would run actual kubectl commands against the cluster.", req.Script)
+	// Read-write viewers type under their own identity
+	newViewerInput := func(record *audit.Record) (terminalInput, error) {
+		return s.newTerminalInput(terminalConfig, record, sessionID)
+	}
+	session := newTerminalSession(conn, sessionOptions{
+		User:        requestUser(r),
+		Recorder:    recorder,
+		Input:       input,
+		NewInput:    newViewerInput,
+		DetachGrace: s.detachGrace,
+		Scrollback:  s.scrollback,
+	})
+	defer session.Close()
+	go session.enforceLimits(s.sessionLimits.forConfig(terminalConfig))
+	token := s.sessions.add(SessionInfo{
+		ID:        sessionID,
+		User:      requestUser(r),
+		Namespace: namespace,
+		Pod:       podName,
+		Container: containerName,
+		Config:    terminalConfig.Name,
+		Mode:      mode,
+		StartedAt: time.Now(),
+	}, session)
+	defer s.sessions.remove(sessionID)
+	if s.detachGrace > 0 {
+		session.sendMessage(TerminalMessage{Op: OpSession, Session: sessionID, Token: token})
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// Stop the exec stream as soon as the browser goes away
+		<-session.done
+		cancel()
+	}()
+
+	err = start(ctx, session)
+
+	record.AddBytes(session.bytesIn.Load(), session.bytesOut.Load())
+
+	var exitErr exec.ExitError
+	var lostErr *streamLostError
+	switch {
+	case err == nil:
+		record.SetExitCode(0)
+		session.Exit(0)
+	case errors.As(err, &exitErr):
+		record.SetExitCode(exitErr.ExitStatus())
+		session.Exit(exitErr.ExitStatus())
+	case errors.As(err, &lostErr):
+		log.Printf("Attach stream to pod %s/%s lost: %v", namespace, podName, err)
+		record.SetError(err)
+		session.Lost(err)
+	case session.closeReason.Load() != nil:
+		log.Printf("Terminal session for pod %s/%s closed: %s", namespace, podName, *session.closeReason.Load())
+	case ctx.Err() != nil:
+		log.Printf("Terminal session for pod %s/%s closed by client", namespace, podName)
 	default:
-		fmt.Fprintf(w, "Executing %s script:\n%s\n\n--- Simulated Output ---\nScript executed successfully!", req.Type, req.Script)
+		log.Printf("Exec in pod %s/%s failed: %v", namespace, podName, err)
+		record.SetError(err)
+		session.Fail(fmt.Errorf("failed to start terminal: %v", err))
 	}
 }
 
-// Write implements io.Writer
-func (t *TerminalSession) Write(p []byte) (int, error) {
-	err := t.wsConn.WriteMessage(websocket.TextMessage, p)
-	if err != nil {
-		return 0, err
+// terminalShells returns the shells to try from the repeated or comma separated shell
+// query parameter, bash and then sh by default
+func terminalShells(r *http.Request) []string {
+	var shells []string
+	for _, value := range r.URL.Query()["shell"] {
+		for _, shell := range strings.Split(value, ",") {
+			if shell = strings.TrimSpace(shell); shell != "" {
+				shells = append(shells, shell)
+			}
+		}
 	}
-	return len(p), nil
-}
\ No newline at end of file
+	if len(shells) == 0 {
+		return defaultShells
+	}
+	return shells
+}
+
+// newID returns a random identifier for uploads and other server side objects
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+)
+
+// parameterNamePattern restricts parameter names to identifiers, so they are valid
+// environment variable names and placeholders
+var parameterNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedParameterNames would change how the interpreter itself behaves if they were
+// set from a parameter, such as BASH_ENV naming a file bash runs first
+var reservedParameterNames = map[string]bool{
+	"BASH_ENV": true, "ENV": true, "HOME": true, "IFS": true, "LD_LIBRARY_PATH": true,
+	"LD_PRELOAD": true, "PATH": true, "PS4": true, "PYTHONPATH": true, "PYTHONSTARTUP": true,
+	"SHELL": true, "SHELLOPTS": true, "BASHOPTS": true, "KUBECONFIG": true,
+}
+
+// placeholderPattern matches {{name}} placeholders in script bodies. Only names of
+// declared parameters are placeholders, so go-templates, Jinja and Helm snippets in
+// scripts are left alone.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// validateParameterDefinitions checks the parameters declared by a script
+func validateParameterDefinitions(defs []terminalv1.ScriptParameter) error {
+	var errs []error
+	names := make(map[string]bool)
+	for _, def := range defs {
+		if !parameterNamePattern.MatchString(def.Name) {
+			errs = append(errs, fmt.Errorf("parameter name %q must be a letter or underscore followed by letters, digits or underscores", def.Name))
+			continue
+		}
+		if reservedParameterNames[def.Name] {
+			errs = append(errs, fmt.Errorf("parameter name %q is reserved", def.Name))
+			continue
+		}
+		if names[def.Name] {
+			errs = append(errs, fmt.Errorf("duplicate parameter %q", def.Name))
+		}
+		names[def.Name] = true
+
+		switch def.Type {
+		case "", terminalv1.ScriptParameterString, terminalv1.ScriptParameterInt, terminalv1.ScriptParameterBool:
+		case terminalv1.ScriptParameterEnum:
+			if len(def.Enum) == 0 {
+				errs = append(errs, fmt.Errorf("enum parameter %q must list its allowed values", def.Name))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("parameter %q has unsupported type %q", def.Name, def.Type))
+			continue
+		}
+		if def.Default != "" {
+			if _, err := parseParameter(def, def.Default); err != nil {
+				errs = append(errs, fmt.Errorf("default of %v", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolveParameters validates the caller's values against the declared parameters and
+// applies defaults. Values may be JSON strings, numbers or booleans. All problems are
+// reported together.
+func resolveParameters(defs []terminalv1.ScriptParameter, values map[string]interface{}) (map[string]string, error) {
+	if err := validateParameterDefinitions(defs); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	declared := make(map[string]bool, len(defs))
+	resolved := make(map[string]string, len(defs))
+	for _, def := range defs {
+		declared[def.Name] = true
+		value, ok := values[def.Name]
+		if !ok || value == nil {
+			if def.Default == "" && def.Required {
+				errs = append(errs, fmt.Errorf("parameter %q is required", def.Name))
+				continue
+			}
+			resolved[def.Name] = def.Default
+			continue
+		}
+
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case bool:
+			text = strconv.FormatBool(v)
+		case float64:
+			text = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			errs = append(errs, fmt.Errorf("parameter %q must be a string, number or boolean", def.Name))
+			continue
+		}
+		normalized, err := parseParameter(def, text)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resolved[def.Name] = normalized
+	}
+
+	for name := range values {
+		if !declared[name] {
+			errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, errors.Join(errs...)
+	}
+	return resolved, nil
+}
+
+// parseParameter checks value against the parameter's type and returns its canonical form
+func parseParameter(def terminalv1.ScriptParameter, value string) (string, error) {
+	switch def.Type {
+	case terminalv1.ScriptParameterInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %q must be an integer, got %q", def.Name, value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case terminalv1.ScriptParameterBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("parameter %q must be true or false, got %q", def.Name, value)
+		}
+		return strconv.FormatBool(b), nil
+	case terminalv1.ScriptParameterEnum:
+		for _, allowed := range def.Enum {
+			if value == allowed {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("parameter %q must be one of %s, got %q", def.Name, strings.Join(def.Enum, ", "), value)
+	default:
+		return value, nil
+	}
+}
+
+// renderScript replaces {{name}} placeholders of the parameters in values in a bash or
+// kubectl script with a reference to the environment variable the parameter is passed in,
+// quoted for where the placeholder sits, so the value is a single word that the shell
+// never parses. Placeholders in a heredoc whose delimiter is quoted would not be expanded
+// and are rejected. Braces around other names are left as written. Python scripts read
+// parameters from their environment directly, so they must not contain placeholders of
+// their parameters.
+func renderScript(scriptType, body string, values map[string]string) (string, error) {
+	var errs []error
+	var contexts []int
+	if scriptType != "python" {
+		var offsets []int
+		for _, match := range placeholderPattern.FindAllStringSubmatchIndex(body, -1) {
+			if _, ok := values[body[match[2]:match[3]]]; ok {
+				offsets = append(offsets, match[0])
+			}
+		}
+		contexts = shellContexts(body, offsets)
+	}
+
+	rendered := replacePlaceholders(body, values, func(name, placeholder string) string {
+		if scriptType == "python" {
+			errs = append(errs, fmt.Errorf("python scripts read parameters from the environment, replace %s with os.environ[%q]", placeholder, name))
+			return placeholder
+		}
+		context := contexts[0]
+		contexts = contexts[1:]
+		switch context {
+		case shellDoubleQuoted, shellHeredoc:
+			return "${" + name + "}"
+		case shellSingleQuoted:
+			return `'"${` + name + `}"'`
+		case shellQuotedHeredoc:
+			errs = append(errs, fmt.Errorf("%s is in a heredoc with a quoted delimiter, where it would not be replaced", placeholder))
+			return placeholder
+		default:
+			return `"${` + name + `}"`
+		}
+	})
+	return rendered, errors.Join(errs...)
+}
+
+// expandScript replaces the placeholders of the parameters in values with their
+// shell-quoted values, which is how the rendered script behaves when it runs. The command
+// policy checks this form so parameters cannot hide denied commands.
+func expandScript(body string, values map[string]string) string {
+	return replacePlaceholders(body, values, func(name, _ string) string {
+		return shellQuote(values[name])
+	})
+}
+
+// replacePlaceholders replaces the placeholders of names in values with replace's result
+func replacePlaceholders(body string, values map[string]string, replace func(name, placeholder string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if _, ok := values[name]; !ok {
+			return placeholder
+		}
+		return replace(name, placeholder)
+	})
+}
+
+// shellQuote quotes s as a single word for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// parameterEnv returns the parameters as sorted NAME=value pairs
+func parameterEnv(values map[string]string) []string {
+	env := make([]string, 0, len(values))
+	for name, value := range values {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	osexec "os/exec"
+	"strings"
+	"testing"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+)
+
+var testParameters = []terminalv1.ScriptParameter{
+	{Name: "namespace", Default: "default"},
+	{Name: "replicas", Type: terminalv1.ScriptParameterInt, Required: true},
+	{Name: "dry_run", Type: terminalv1.ScriptParameterBool, Default: "true"},
+	{Name: "output", Type: terminalv1.ScriptParameterEnum, Enum: []string{"wide", "yaml"}, Default: "wide"},
+}
+
+func TestResolveParameters(t *testing.T) {
+	testCases := []struct {
+		name   string
+		values map[string]interface{}
+		want   map[string]string
+		errors []string
+	}{
+		{
+			name:   "defaults applied",
+			values: map[string]interface{}{"replicas": float64(3)},
+			want:   map[string]string{"namespace": "default", "replicas": "3", "dry_run": "true", "output": "wide"},
+		},
+		{
+			name:   "values normalized",
+			values: map[string]interface{}{"replicas": " 05", "dry_run": false, "output": "yaml", "namespace": "team-a"},
+			want:   map[string]string{"namespace": "team-a", "replicas": "5", "dry_run": "false", "output": "yaml"},
+		},
+		{
+			name:   "required missing",
+			values: map[string]interface{}{},
+			errors: []string{`parameter "replicas" is required`},
+		},
+		{
+			name:   "all problems reported",
+			values: map[string]interface{}{"replicas": 2.5, "dry_run": "yes", "output": "json", "color": "red"},
+			errors: []string{
+				`parameter "dry_run" must be true or false`,
+				`parameter "output" must be one of wide, yaml`,
+				`parameter "replicas" must be an integer`,
+				`unknown parameter "color"`,
+			},
+		},
+		{
+			name:   "structured value",
+			values: map[string]interface{}{"replicas": float64(1), "namespace": []interface{}{"a"}},
+			errors: []string{`parameter "namespace" must be a string, number or boolean`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveParameters(testParameters, tc.values)
+			if len(tc.errors) > 0 {
+				if err == nil {
+					t.Fatalf("Expected errors, got %v", got)
+				}
+				for _, want := range tc.errors {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("Expected error %q in %q", want, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for name, want := range tc.want {
+				if got[name] != want {
+					t.Errorf("Parameter %s: got %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateParameterDefinitions(t *testing.T) {
+	testCases := []struct {
+		name string
+		def  terminalv1.ScriptParameter
+	}{
+		{"invalid name", terminalv1.ScriptParameter{Name: "pod-name"}},
+		{"reserved name", terminalv1.ScriptParameter{Name: "BASH_ENV"}},
+		{"unknown type", terminalv1.ScriptParameter{Name: "p", Type: "float"}},
+		{"enum without values", terminalv1.ScriptParameter{Name: "p", Type: terminalv1.ScriptParameterEnum}},
+		{"invalid default", terminalv1.ScriptParameter{Name: "p", Type: terminalv1.ScriptParameterInt, Default: "many"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateParameterDefinitions([]terminalv1.ScriptParameter{tc.def}); err == nil {
+				t.Errorf("Expected %+v to be rejected", tc.def)
+			}
+		})
+	}
+}
+
+func TestRenderScriptQuotesValues(t *testing.T) {
+	sh, err := osexec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	hostile := `x'; echo pwned; echo '$(id)` + "`id`\""
+	values := map[string]string{"name": hostile}
+
+	// Values are passed in the environment, so quoting around the placeholder cannot
+	// make the shell run them
+	for body, want := range map[string]string{
+		`printf '%s\n' {{ name }}`:                                 hostile + "\n",
+		`echo "name={{name}}"`:                                     "name=" + hostile + "\n",
+		"cat <<EOF\nname={{name}}\nEOF":                            "name=" + hostile + "\n",
+		`printf '%s|%s\n' {{name}} "a {{name}}"`:                   hostile + "|a " + hostile + "\n",
+		`echo 'name={{name}}'`:                                     "name=" + hostile + "\n",
+		`echo "$(printf '%s' {{name}})"`:                           hostile + "\n",
+		"cat <<-'EOF'\n\tliteral\n\tEOF\necho {{name}} # {{name}}": "literal\n" + hostile + "\n",
+	} {
+		rendered, err := renderScript("bash", body, values)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if strings.Contains(rendered, hostile) {
+			t.Errorf("Expected the value to stay out of the script, got %q", rendered)
+		}
+		cmd := osexec.Command(sh, "-c", rendered)
+		cmd.Env = parameterEnv(values)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Rendered script %q failed: %v", rendered, err)
+		}
+		if string(output) != want {
+			t.Errorf("Script %q: expected %q, got %q", body, want, output)
+		}
+	}
+}
+
+func TestRenderScriptRejectsQuotedHeredocs(t *testing.T) {
+	if _, err := renderScript("bash", "cat <<'EOF'\n{{name}}\nEOF", map[string]string{"name": "x"}); err == nil {
+		t.Error("Placeholders in a heredoc with a quoted delimiter must be rejected")
+	}
+}
+
+func TestExpandScriptForPolicy(t *testing.T) {
+	expanded := expandScript(`echo "{{cmd}}" {{ other }}`, map[string]string{"cmd": "rm -rf /"})
+	if expanded != `echo "'rm -rf /'" {{ other }}` {
+		t.Errorf("Unexpected expanded script %q", expanded)
+	}
+}
+
+func TestRenderScriptRejectsPlaceholders(t *testing.T) {
+	if _, err := renderScript("python", "print('{{name}}')", map[string]string{"name": "x"}); err == nil {
+		t.Error("Placeholders in python scripts must be rejected")
+	}
+	if rendered, err := renderScript("python", "print('{{ other }}')", map[string]string{"name": "x"}); err != nil || rendered != "print('{{ other }}')" {
+		t.Errorf("Expected braces around other names to be left alone, got %q (%v)", rendered, err)
+	}
+}
+
+func TestRenderScriptLeavesTemplates(t *testing.T) {
+	template := `kubectl get pods -o go-template='{{range .items}}{{.metadata.name}}{{end}}'`
+	for _, body := range []string{template, "echo {{end}} {{ missing }}"} {
+		if rendered, err := renderScript("kubectl", body, map[string]string{}); err != nil || rendered != body {
+			t.Errorf("Expected a script without parameters to be left alone, got %q (%v)", rendered, err)
+		}
+	}
+	rendered, err := renderScript("kubectl", template+" -n {{namespace}} # {{end}}", map[string]string{"namespace": "team-a"})
+	want := template + ` -n "${namespace}" # {{end}}`
+	if err != nil || rendered != want {
+		t.Errorf("Expected only declared parameters to be replaced, got %q (%v)", rendered, err)
+	}
+}
+
+func TestExecuteScriptWithParameters(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+
+	body := `{"type":"python","script":"import os; print(os.environ['namespace'])","podName":"tools-0",
+		"parameterDefinitions":[{"name":"namespace"},{"name":"verbose","type":"bool","default":"false"}],
+		"parameters":{"namespace":"team a"}}`
+	_, execution := postScript(t, server, body)
+	streamExecution(t, server, execution.ID, "")
+
+	want := []string{"env", "namespace=team a", "verbose=false", "python3", "-c", "import os; print(os.environ['namespace'])"}
+	if got := execServer.lastRequest(t).Command; strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("Unexpected command %q", got)
+	}
+
+	body = `{"type":"bash","script":"kubectl scale deploy/web --replicas={{replicas}}","podName":"tools-0",
+		"parameterDefinitions":[{"name":"replicas","type":"int"}],"parameters":{"replicas":4}}`
+	_, execution = postScript(t, server, body)
+	streamExecution(t, server, execution.ID, "")
+	want = []string{"env", "replicas=4", "bash", "-c", `kubectl scale deploy/web --replicas="${replicas}"`}
+	if got := execServer.lastRequest(t).Command; strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("Unexpected command %q", got)
+	}
+}
+
+func TestExecuteScriptRejectsInvalidParameters(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		t.Errorf("Script with invalid parameters was executed: %v", req.Command)
+		return 0
+	})
+	server := newTestServer(t, execServer)
+
+	body := `{"type":"bash","script":"echo {{replicas}}","podName":"tools-0",
+		"parameterDefinitions":[{"name":"replicas","type":"int"}],"parameters":{"replicas":"lots"}}`
+	if code, _ := postScript(t, server, body); code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", code)
+	}
+}
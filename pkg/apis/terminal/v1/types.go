@@ -43,6 +43,61 @@ type TerminalConfigSpec struct {
 	// SecurityContext specifies the security context for the terminal container
 	// +optional
 	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// Recording specifies whether terminal sessions are recorded
+	// +optional
+	Recording *RecordingPolicy `json:"recording,omitempty"`
+
+	// CommandPolicy specifies commands that may not be run in the terminal
+	// +optional
+	CommandPolicy *CommandPolicy `json:"commandPolicy,omitempty"`
+
+	// SessionLimits specifies how long terminal sessions may stay open
+	// +optional
+	SessionLimits *SessionLimits `json:"sessionLimits,omitempty"`
+}
+
+// RecordingPolicy controls the recording of terminal sessions in asciicast v2 format
+type RecordingPolicy struct {
+	// Enabled records the output of every terminal session
+	Enabled bool `json:"enabled"`
+
+	// RecordInput also records keystrokes, including anything typed at password prompts
+	// +optional
+	RecordInput bool `json:"recordInput,omitempty"`
+
+	// Required refuses to open terminals when the recording cannot be started
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// CommandPolicy restricts the commands typed into terminals and run as scripts
+type CommandPolicy struct {
+	// Deny lists the rules of commands that are blocked
+	// +optional
+	Deny []CommandRule `json:"deny,omitempty"`
+}
+
+// CommandRule matches commands with a regular expression
+type CommandRule struct {
+	// Pattern is a regular expression searched for in each command line
+	Pattern string `json:"pattern"`
+
+	// Message explains to the user why the command is blocked
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// SessionLimits bounds how long terminal sessions stay open. Server-wide limits also
+// apply; the shorter limit wins.
+type SessionLimits struct {
+	// IdleTimeout closes sessions without input or output for this long
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// MaxDuration closes sessions this long after they were opened
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
 }
 
 // FileMount represents a file mount reference that can be a ConfigMap, Secret, or Volume
@@ -149,6 +204,102 @@ type TerminalConfigList struct {
 	Items           []TerminalConfig `json:"items"`
 }
 
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TerminalScript is a script saved in the Tool Builder's shared library
+type TerminalScript struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TerminalScriptSpec `json:"spec,omitempty"`
+}
+
+// TerminalScriptSpec defines a script and how it is run
+type TerminalScriptSpec struct {
+	// Description explains what the script does
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Type selects the interpreter: bash, python or kubectl
+	Type string `json:"type"`
+
+	// Body is the script source
+	Body string `json:"body"`
+
+	// Parameters declares the inputs the script accepts when it is run
+	// +optional
+	Parameters []ScriptParameter `json:"parameters,omitempty"`
+
+	// DefaultTarget is where the script runs unless the caller picks a target.
+	// Without a target the script runs in a new Job.
+	// +optional
+	DefaultTarget *ScriptTarget `json:"defaultTarget,omitempty"`
+}
+
+// ScriptParameter describes an input of a TerminalScript
+type ScriptParameter struct {
+	// Name of the parameter
+	Name string `json:"name"`
+
+	// Description explains the parameter to users running the script
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Type of the value: string, int, bool or enum. Defaults to string.
+	// +optional
+	Type ScriptParameterType `json:"type,omitempty"`
+
+	// Enum lists the allowed values of an enum parameter
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+
+	// Default is used when the caller does not provide a value
+	// +optional
+	Default string `json:"default,omitempty"`
+
+	// Required parameters must be provided when Default is empty
+	// +optional
+	Required bool `json:"required,omitempty"`
+}
+
+// ScriptParameterType is the type of a script parameter value
+type ScriptParameterType string
+
+const (
+	// ScriptParameterString accepts any text
+	ScriptParameterString ScriptParameterType = "string"
+	// ScriptParameterInt accepts a decimal integer
+	ScriptParameterInt ScriptParameterType = "int"
+	// ScriptParameterBool accepts true or false
+	ScriptParameterBool ScriptParameterType = "bool"
+	// ScriptParameterEnum accepts one of the values listed in Enum
+	ScriptParameterEnum ScriptParameterType = "enum"
+)
+
+// ScriptTarget selects an existing container to exec a script in
+type ScriptTarget struct {
+	// PodName is the pod to run the script in
+	PodName string `json:"podName"`
+
+	// Namespace of the pod, defaults to the script's namespace
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Container in the pod, defaults to the pod's default container
+	// +optional
+	Container string `json:"container,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TerminalScriptList contains a list of TerminalScript
+type TerminalScriptList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerminalScript `json:"items"`
+}
+
 // DeepCopyObject returns a deep copy of the TerminalConfig for runtime.Object interface
 func (tc *TerminalConfig) DeepCopyObject() runtime.Object {
 	if tc == nil {
@@ -218,6 +369,46 @@ func (tcs *TerminalConfigSpec) deepCopyInto(out *TerminalConfigSpec) {
 		*out = new(corev1.SecurityContext)
 		(*in).DeepCopyInto(*out)
 	}
+	if tcs.Recording != nil {
+		in, out := &tcs.Recording, &out.Recording
+		*out = new(RecordingPolicy)
+		**out = **in
+	}
+	if tcs.CommandPolicy != nil {
+		in, out := &tcs.CommandPolicy, &out.CommandPolicy
+		*out = new(CommandPolicy)
+		(*in).deepCopyInto(*out)
+	}
+	if tcs.SessionLimits != nil {
+		in, out := &tcs.SessionLimits, &out.SessionLimits
+		*out = new(SessionLimits)
+		(*in).deepCopyInto(*out)
+	}
+}
+
+// deepCopyInto copies all fields from this SessionLimits into out
+func (sl *SessionLimits) deepCopyInto(out *SessionLimits) {
+	*out = *sl
+	if sl.IdleTimeout != nil {
+		in, out := &sl.IdleTimeout, &out.IdleTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if sl.MaxDuration != nil {
+		in, out := &sl.MaxDuration, &out.MaxDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// deepCopyInto copies all fields from this CommandPolicy into out
+func (cp *CommandPolicy) deepCopyInto(out *CommandPolicy) {
+	*out = *cp
+	if cp.Deny != nil {
+		in, out := &cp.Deny, &out.Deny
+		*out = make([]CommandRule, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // deepCopyInto copies all fields from this FileMount into out
@@ -265,4 +456,73 @@ func (tcs *TerminalConfigStatus) deepCopyInto(out *TerminalConfigStatus) {
 func (tcc *TerminalConfigCondition) deepCopyInto(out *TerminalConfigCondition) {
 	*out = *tcc
 	tcc.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopyObject returns a deep copy of the TerminalScript for runtime.Object interface
+func (ts *TerminalScript) DeepCopyObject() runtime.Object {
+	if ts == nil {
+		return nil
+	}
+	out := new(TerminalScript)
+	ts.deepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a deep copy of the TerminalScriptList for runtime.Object interface
+func (tsl *TerminalScriptList) DeepCopyObject() runtime.Object {
+	if tsl == nil {
+		return nil
+	}
+	out := new(TerminalScriptList)
+	tsl.deepCopyInto(out)
+	return out
+}
+
+// deepCopyInto copies all fields from this TerminalScript into out
+func (ts *TerminalScript) deepCopyInto(out *TerminalScript) {
+	*out = *ts
+	out.TypeMeta = ts.TypeMeta
+	ts.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	ts.Spec.deepCopyInto(&out.Spec)
+}
+
+// deepCopyInto copies all fields from this TerminalScriptList into out
+func (tsl *TerminalScriptList) deepCopyInto(out *TerminalScriptList) {
+	*out = *tsl
+	out.TypeMeta = tsl.TypeMeta
+	tsl.ListMeta.DeepCopyInto(&out.ListMeta)
+	if tsl.Items != nil {
+		in, out := &tsl.Items, &out.Items
+		*out = make([]TerminalScript, len(*in))
+		for i := range *in {
+			(*in)[i].deepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// deepCopyInto copies all fields from this TerminalScriptSpec into out
+func (tss *TerminalScriptSpec) deepCopyInto(out *TerminalScriptSpec) {
+	*out = *tss
+	if tss.Parameters != nil {
+		in, out := &tss.Parameters, &out.Parameters
+		*out = make([]ScriptParameter, len(*in))
+		for i := range *in {
+			(*in)[i].deepCopyInto(&(*out)[i])
+		}
+	}
+	if tss.DefaultTarget != nil {
+		in, out := &tss.DefaultTarget, &out.DefaultTarget
+		*out = new(ScriptTarget)
+		**out = **in
+	}
+}
+
+// deepCopyInto copies all fields from this ScriptParameter into out
+func (sp *ScriptParameter) deepCopyInto(out *ScriptParameter) {
+	*out = *sp
+	if sp.Enum != nil {
+		in, out := &sp.Enum, &out.Enum
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
\ No newline at end of file
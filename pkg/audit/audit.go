@@ -0,0 +1,212 @@
+// Package audit records structured events of the actions users take through the
+// terminal server and delivers them to pluggable sinks.
+package audit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Event is a single audited action
+type Event struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	SourceIP   string    `json:"sourceIP,omitempty"`
+	Action     string    `json:"action"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Pod        string    `json:"pod,omitempty"`
+	Container  string    `json:"container,omitempty"`
+	Config     string    `json:"config,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Session    string    `json:"session,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status,omitempty"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	BytesIn    int64     `json:"bytesIn"`
+	BytesOut   int64     `json:"bytesOut"`
+}
+
+// Sink delivers events. Write must not block for long, it is called while the request
+// that caused the event finishes.
+type Sink interface {
+	Write(event Event) error
+	Close() error
+}
+
+// Logger fans events out to its sinks and keeps the most recent ones in memory
+type Logger struct {
+	sinks []Sink
+
+	mu     sync.Mutex
+	recent []Event
+	next   int
+	full   bool
+}
+
+// NewLogger returns a logger that keeps the last capacity events
+func NewLogger(capacity int, sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks, recent: make([]Event, capacity)}
+}
+
+// Log records an event. It does nothing on a nil logger, so auditing can be left out in
+// tests and tools.
+func (l *Logger) Log(event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	if len(l.recent) > 0 {
+		l.recent[l.next] = event
+		l.next = (l.next + 1) % len(l.recent)
+		l.full = l.full || l.next == 0
+	}
+	l.mu.Unlock()
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(event); err != nil {
+			log.Printf("Failed to write audit event %s by %s: %v", event.Action, event.User, err)
+		}
+	}
+}
+
+// Recent returns up to n of the most recent events, newest first
+func (l *Logger) Recent(n int) []Event {
+	events := []Event{}
+	if l == nil {
+		return events
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.recent)
+	}
+	for i := 0; i < count && i < n; i++ {
+		index := (l.next - 1 - i + len(l.recent)) % len(l.recent)
+		events = append(events, l.recent[index])
+	}
+	return events
+}
+
+// Close flushes and closes all sinks
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	var firstErr error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Record collects the event of a request while it is handled. Handlers add what only
+// they know, such as the target pod, through the record in the request context. All
+// methods are safe for concurrent use and do nothing on a nil record.
+type Record struct {
+	mu    sync.Mutex
+	event Event
+}
+
+// NewRecord starts the event of a request
+func NewRecord(event Event) *Record {
+	return &Record{event: event}
+}
+
+type recordKey struct{}
+
+// WithRecord returns a context carrying the record
+func WithRecord(ctx context.Context, record *Record) context.Context {
+	return context.WithValue(ctx, recordKey{}, record)
+}
+
+// RecordFrom returns the record of the request, or nil outside of audited requests
+func RecordFrom(ctx context.Context) *Record {
+	record, _ := ctx.Value(recordKey{}).(*Record)
+	return record
+}
+
+func (r *Record) update(f func(*Event)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&r.event)
+}
+
+// SetUser records the user who performed the action, once authenticated
+func (r *Record) SetUser(user string) {
+	r.update(func(e *Event) { e.User = user })
+}
+
+// SetTarget records the namespace, pod and container the action applies to
+func (r *Record) SetTarget(namespace, pod, container string) {
+	r.update(func(e *Event) {
+		e.Namespace, e.Pod, e.Container = namespace, pod, container
+	})
+}
+
+// SetConfig records the TerminalConfig used by the action
+func (r *Record) SetConfig(config string) {
+	r.update(func(e *Event) { e.Config = config })
+}
+
+// SetName records the name of the object the action applies to, such as a script
+func (r *Record) SetName(name string) {
+	r.update(func(e *Event) { e.Name = name })
+}
+
+// SetSession records the ID of the terminal session the action belongs to
+func (r *Record) SetSession(id string) {
+	r.update(func(e *Event) { e.Session = id })
+}
+
+// SetExitCode records the exit code of a remote process
+func (r *Record) SetExitCode(code int) {
+	r.update(func(e *Event) { e.ExitCode = &code })
+}
+
+// SetError marks the action as failed
+func (r *Record) SetError(err error) {
+	r.update(func(e *Event) {
+		e.Outcome = OutcomeFailure
+		e.Error = err.Error()
+	})
+}
+
+// AddBytes counts bytes received from and sent to the user
+func (r *Record) AddBytes(in, out int64) {
+	r.update(func(e *Event) {
+		e.BytesIn += in
+		e.BytesOut += out
+	})
+}
+
+// Event returns a copy of the event collected so far
+func (r *Record) Event() Event {
+	if r == nil {
+		return Event{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event
+}
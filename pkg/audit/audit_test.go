@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// memorySink keeps the events written to it
+type memorySink struct {
+	events []Event
+	closed bool
+}
+
+func (s *memorySink) Write(event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{}
+	logger := NewLogger(3, sink)
+	if got := logger.Recent(10); len(got) != 0 {
+		t.Fatalf("Expected no events, got %+v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		logger.Log(Event{Action: fmt.Sprintf("action-%d", i)})
+	}
+
+	if len(sink.events) != 5 || sink.events[0].Time.IsZero() {
+		t.Errorf("Expected all events to reach the sink with a time, got %+v", sink.events)
+	}
+	recent := logger.Recent(10)
+	if len(recent) != 3 || recent[0].Action != "action-5" || recent[2].Action != "action-3" {
+		t.Errorf("Expected the last 3 events newest first, got %+v", recent)
+	}
+	if recent := logger.Recent(1); len(recent) != 1 || recent[0].Action != "action-5" {
+		t.Errorf("Expected the newest event, got %+v", recent)
+	}
+
+	if err := logger.Close(); err != nil || !sink.closed {
+		t.Errorf("Expected sinks to be closed, got %v", err)
+	}
+
+	var disabled *Logger
+	disabled.Log(Event{Action: "ignored"})
+	if len(disabled.Recent(1)) != 0 || disabled.Close() != nil {
+		t.Errorf("A nil logger must do nothing")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	record := NewRecord(Event{User: "alice", Action: "terminal.open"})
+	ctx := WithRecord(context.Background(), record)
+
+	RecordFrom(ctx).SetTarget("dev", "web-0", "app")
+	RecordFrom(ctx).SetConfig("debug")
+	RecordFrom(ctx).AddBytes(3, 10)
+	RecordFrom(ctx).AddBytes(1, 2)
+	RecordFrom(ctx).SetExitCode(2)
+	RecordFrom(ctx).SetError(errors.New("stream closed"))
+
+	event := record.Event()
+	if event.User != "alice" || event.Namespace != "dev" || event.Pod != "web-0" || event.Container != "app" || event.Config != "debug" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if event.BytesIn != 4 || event.BytesOut != 12 || *event.ExitCode != 2 || event.Outcome != OutcomeFailure || event.Error != "stream closed" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// Handlers outside of audited requests see a nil record
+	missing := RecordFrom(context.Background())
+	missing.SetName("ignored")
+	if missing.Event() != (Event{}) {
+		t.Errorf("Expected an empty event from a nil record")
+	}
+}
@@ -0,0 +1,422 @@
+package audit
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Redacted replaces secrets in audited commands
+const Redacted = "[REDACTED]"
+
+// maxOutputTail is how much terminal output is kept to recognize password prompts
+const maxOutputTail = 256
+
+// maxEscapeLength bounds escape sequences; longer ones are dropped as garbage
+const maxEscapeLength = 32
+
+// passwordPrompt matches output that asks for input the terminal does not echo
+var passwordPrompt = regexp.MustCompile(`(?i)(password|passphrase|passcode)[^\n]*:\s*$`)
+
+// Escape sequences with which a line editor turns bracketed paste on and off (DECSET 2004)
+const (
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+)
+
+// LineAssembler reconstructs the command lines typed into a terminal from its input, as
+// a readline-style line editor would see them: it applies backspace, cursor movement,
+// word and line deletion, history recall and bracketed paste, and reports each line when
+// Enter is pressed. It cannot know what the remote shell completes or recalls, so the
+// result is a best effort. Lines typed at a password prompt are reported as Redacted.
+// Paste markers are only honoured while the output has turned bracketed paste on, since
+// shells without it run every pasted line.
+//
+// The methods are safe for concurrent use and do nothing on a nil assembler.
+type LineAssembler struct {
+	submit func(command string)
+
+	mu      sync.Mutex
+	line    []rune
+	cursor  int
+	started bool
+	secret  bool
+	pasting bool
+	// bracketed is set while the shell has bracketed paste turned on
+	bracketed bool
+	escape    []byte
+	partial   []byte
+	output    []byte
+
+	history      []string
+	historyIndex int
+}
+
+// NewLineAssembler returns an assembler that calls submit, if set, with every non-empty line
+func NewLineAssembler(submit func(command string)) *LineAssembler {
+	return &LineAssembler{submit: submit}
+}
+
+// Output observes terminal output, which is used to recognize password prompts and
+// whether the shell expects bracketed paste
+func (a *LineAssembler) Output(p []byte) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.output = append(a.output, p...)
+	// The tail may still hold earlier switches, so the last one wins
+	on := bytes.LastIndex(a.output, []byte(bracketedPasteOn))
+	off := bytes.LastIndex(a.output, []byte(bracketedPasteOff))
+	if on >= 0 || off >= 0 {
+		a.bracketed = on > off
+		if !a.bracketed {
+			a.pasting = false
+		}
+	}
+	if len(a.output) > maxOutputTail {
+		a.output = append([]byte(nil), a.output[len(a.output)-maxOutputTail:]...)
+	}
+}
+
+// Input feeds keystrokes typed into the terminal
+func (a *LineAssembler) Input(p []byte) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.feed(p, nil)
+}
+
+// Filter feeds keystrokes like Input and returns those that may be passed on to the
+// terminal. Every line is passed to allow when Enter is pressed; if allow refuses it,
+// Enter is replaced by Ctrl-C so the shell abandons the line, and the line is neither
+// reported nor kept in the history. A nil assembler returns p.
+func (a *LineAssembler) Filter(p []byte, allow func(line string) bool) []byte {
+	if a == nil {
+		return p
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.feed(p, allow)
+}
+
+// feed applies keystrokes and returns them with refused Enter keys replaced. Bytes of an
+// incomplete UTF-8 character are passed on right away and applied with the next call.
+func (a *LineAssembler) feed(p []byte, allow func(line string) bool) []byte {
+	out := p
+	copied := false
+	held := len(a.partial)
+	data := append(a.partial, p...)
+	a.partial = nil
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf || len(a.escape) > 0 {
+			if !a.inputByte(data[i], allow) {
+				if !copied {
+					out = append([]byte(nil), p...)
+					copied = true
+				}
+				out[i-held] = 0x03
+			}
+			i++
+			continue
+		}
+		if !utf8.FullRune(data[i:]) {
+			a.partial = append([]byte(nil), data[i:]...)
+			break
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		a.insert(r)
+		i += size
+	}
+	return out
+}
+
+// inputByte applies a single byte. It returns false if the byte is Enter and allow
+// refused the line.
+func (a *LineAssembler) inputByte(b byte, allow func(line string) bool) bool {
+	if len(a.escape) > 0 || b == 0x1b {
+		a.escape = append(a.escape, b)
+		if a.escapeComplete() {
+			seq := string(a.escape)
+			a.escape = nil
+			a.handleEscape(seq)
+		} else if len(a.escape) > maxEscapeLength {
+			a.escape = nil
+		}
+		return true
+	}
+
+	if a.pasting {
+		// Pasted line breaks are part of the line until Enter is pressed. The shell may
+		// still run them, so the line so far is checked at every break and stays in place
+		// when refused, to be refused again on Enter.
+		if b == '\r' || b == '\n' {
+			allowed := allow == nil || strings.TrimSpace(string(a.line)) == "" || allow(string(a.line))
+			a.insert('\n')
+			return allowed
+		}
+		a.insert(rune(b))
+		return true
+	}
+
+	switch b {
+	case '\r', '\n':
+		return a.enter(allow)
+	case 0x7f, 0x08: // Backspace
+		if a.cursor > 0 {
+			a.delete(a.cursor-1, a.cursor)
+		}
+	case 0x04: // Ctrl-D deletes the character under the cursor
+		if a.cursor < len(a.line) {
+			a.delete(a.cursor, a.cursor+1)
+		}
+	case 0x03: // Ctrl-C abandons the line
+		a.reset()
+	case 0x15: // Ctrl-U
+		a.delete(0, a.cursor)
+	case 0x0b: // Ctrl-K
+		a.delete(a.cursor, len(a.line))
+	case 0x17: // Ctrl-W
+		a.delete(a.wordLeft(), a.cursor)
+	case 0x01: // Ctrl-A
+		a.cursor = 0
+	case 0x05: // Ctrl-E
+		a.cursor = len(a.line)
+	case 0x02: // Ctrl-B
+		a.move(-1)
+	case 0x06: // Ctrl-F
+		a.move(1)
+	case 0x10: // Ctrl-P
+		a.recall(-1)
+	case 0x0e: // Ctrl-N
+		a.recall(1)
+	case '\t':
+		a.insert('\t')
+	default:
+		if b >= 0x20 {
+			a.insert(rune(b))
+		}
+	}
+	return true
+}
+
+// escapeComplete reports whether a.escape holds a complete escape sequence
+func (a *LineAssembler) escapeComplete() bool {
+	seq := a.escape
+	switch {
+	case len(seq) < 2:
+		return false
+	case seq[1] == '[':
+		// CSI: parameters and intermediates followed by a final byte
+		last := seq[len(seq)-1]
+		return len(seq) > 2 && last >= 0x40 && last <= 0x7e
+	case seq[1] == 'O':
+		return len(seq) == 3
+	default:
+		return true
+	}
+}
+
+// handleEscape applies a complete escape sequence: cursor keys, editing keys, Alt key
+// bindings and bracketed paste markers
+func (a *LineAssembler) handleEscape(seq string) {
+	if len(seq) == 2 {
+		// Alt bindings
+		switch seq[1] {
+		case 'b':
+			a.cursor = a.wordLeft()
+		case 'f':
+			a.cursor = a.wordRight()
+		case 'd':
+			a.delete(a.cursor, a.wordRight())
+		case 0x7f, 0x08:
+			a.delete(a.wordLeft(), a.cursor)
+		}
+		return
+	}
+
+	final := seq[len(seq)-1]
+	params := strings.Split(seq[2:len(seq)-1], ";")
+	first, _ := strconv.Atoi(params[0])
+	// Ctrl and Alt modified arrows, like ESC[1;5D, move by words
+	byWord := len(params) > 1 && params[1] != "1" && params[1] != "2"
+
+	switch {
+	case final == 'D' && byWord:
+		a.cursor = a.wordLeft()
+	case final == 'C' && byWord:
+		a.cursor = a.wordRight()
+	case final == 'D':
+		a.move(-1)
+	case final == 'C':
+		a.move(1)
+	case final == 'A':
+		a.recall(-1)
+	case final == 'B':
+		a.recall(1)
+	case final == 'H', final == '~' && (first == 1 || first == 7):
+		a.cursor = 0
+	case final == 'F', final == '~' && (first == 4 || first == 8):
+		a.cursor = len(a.line)
+	case final == '~' && first == 3:
+		if a.cursor < len(a.line) {
+			a.delete(a.cursor, a.cursor+1)
+		}
+	case final == '~' && first == 200:
+		a.pasting = a.bracketed
+	case final == '~' && first == 201:
+		a.pasting = false
+	}
+}
+
+func (a *LineAssembler) insert(r rune) {
+	if !a.started {
+		// The first keystroke of a line shows whether it answers a password prompt
+		a.started = true
+		a.secret = passwordPrompt.Match(a.output)
+	}
+	a.line = append(a.line, 0)
+	copy(a.line[a.cursor+1:], a.line[a.cursor:])
+	a.line[a.cursor] = r
+	a.cursor++
+}
+
+func (a *LineAssembler) delete(from, to int) {
+	if from >= to {
+		return
+	}
+	a.line = append(a.line[:from], a.line[to:]...)
+	a.cursor = from
+}
+
+func (a *LineAssembler) move(n int) {
+	a.cursor = max(0, min(len(a.line), a.cursor+n))
+}
+
+// wordLeft is the start of the word before the cursor
+func (a *LineAssembler) wordLeft() int {
+	i := a.cursor
+	for i > 0 && unicode.IsSpace(a.line[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(a.line[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordRight is the end of the word after the cursor
+func (a *LineAssembler) wordRight() int {
+	i := a.cursor
+	for i < len(a.line) && unicode.IsSpace(a.line[i]) {
+		i++
+	}
+	for i < len(a.line) && !unicode.IsSpace(a.line[i]) {
+		i++
+	}
+	return i
+}
+
+// recall replaces the line with an earlier (-1) or later (1) submitted line, like the
+// shell's history
+func (a *LineAssembler) recall(direction int) {
+	index := a.historyIndex + direction
+	if index < 0 || index > len(a.history) {
+		return
+	}
+	a.historyIndex = index
+	a.line = nil
+	if index < len(a.history) {
+		a.line = []rune(a.history[index])
+	}
+	a.cursor = len(a.line)
+}
+
+func (a *LineAssembler) enter(allow func(line string) bool) bool {
+	line := string(a.line)
+	secret := a.secret
+	a.reset()
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+	if allow != nil && !allow(line) {
+		return false
+	}
+	if secret {
+		line = Redacted
+	} else {
+		a.history = append(a.history, line)
+		a.historyIndex = len(a.history)
+	}
+	if a.submit != nil {
+		a.submit(line)
+	}
+	return true
+}
+
+func (a *LineAssembler) reset() {
+	a.line = nil
+	a.cursor = 0
+	a.started = false
+	a.secret = false
+	a.pasting = false
+	a.historyIndex = len(a.history)
+}
+
+// DefaultRedactPatterns match common ways secrets appear on a command line. Only the
+// first group is replaced, so the key stays visible.
+var DefaultRedactPatterns = []string{
+	`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)[=:]\s*("[^"]*"|'[^']*'|\S+)`,
+	`(?i)--(?:password|token|client-secret|secret)[= ]("[^"]*"|'[^']*'|\S+)`,
+	`(?i)authorization:\s*(?:bearer|basic)\s+([^\s'"]+)`,
+	`://[^/\s:@]+:([^/\s@]+)@`,
+}
+
+// Redactor masks secrets in audited commands
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the patterns. In patterns with groups only the first group is
+// redacted, otherwise the whole match.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact returns s with every secret replaced by Redacted. A nil redactor returns s.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		var b strings.Builder
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := match[0], match[1]
+			if len(match) > 2 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Redacted)
+			last = end
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
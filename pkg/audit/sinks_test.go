@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	sink.Write(Event{Time: time.Unix(0, 0).UTC(), User: "alice", Action: "file.upload", Outcome: OutcomeSuccess, BytesIn: 12})
+	want := `{"time":"1970-01-01T00:00:00Z","user":"alice","action":"file.upload","outcome":"success","durationMs":0,"bytesIn":12,"bytesOut":0}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Expected %s, got %s", want, buf.String())
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, _ := json.Marshal(Event{Action: "pods.list"})
+	// Room for two events per file
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := sink.Write(Event{Action: "pods.list"}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// 7 events: 1 in the current file, 2 in each backup, the oldest 2 were dropped
+	for file, want := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		if got := strings.Count(string(data), "\n"); got != want {
+			t.Errorf("Expected %d events in %s, got %d", want, file, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups")
+	}
+
+	// Reopening appends to the existing file
+	sink, err = NewFileSink(path, 1<<20, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink failed: %v", err)
+	}
+	sink.Write(Event{Action: "pods.list"})
+	sink.Close()
+	data, _ := os.ReadFile(path)
+	if got := strings.Count(string(data), "\n"); got != 2 {
+		t.Errorf("Expected the reopened file to be appended to, got %d events", got)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&event) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	sink := NewWebhookSink(receiver.URL, nil)
+	sink.Write(Event{User: "alice", Action: "terminal.open"})
+	sink.Write(Event{User: "bob", Action: "file.mount"})
+	// Close waits for queued events
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0].User != "alice" || received[1].Action != "file.mount" {
+		t.Errorf("Unexpected events delivered: %+v", received)
+	}
+	if err := sink.Write(Event{}); err == nil {
+		t.Errorf("Expected writes after Close to fail")
+	}
+}
@@ -0,0 +1,117 @@
+// Package auth authenticates users of the web terminal. Requests are authenticated with a
+// Kubernetes bearer token, validated through the TokenReview API, or with a session cookie
+// obtained by logging in with an OpenID Connect provider.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// User is an authenticated caller
+type User struct {
+	Name   string              `json:"name"`
+	UID    string              `json:"uid,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+// Authenticator identifies the user making a request. It returns false without an error
+// when the request carries no credentials it understands, so other authenticators can be
+// tried, and an error when credentials are present but invalid.
+type Authenticator interface {
+	AuthenticateRequest(r *http.Request) (*User, bool, error)
+}
+
+// ErrInvalidCredentials is returned for credentials that were rejected
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Union tries each authenticator in turn and returns the first user found
+type Union []Authenticator
+
+// AuthenticateRequest implements Authenticator
+func (u Union) AuthenticateRequest(r *http.Request) (*User, bool, error) {
+	var errs []error
+	for _, authenticator := range u {
+		user, ok, err := authenticator.AuthenticateRequest(r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			return user, true, nil
+		}
+	}
+	return nil, false, errors.Join(errs...)
+}
+
+// Anonymous authenticates every request as the given user. It is meant for local
+// development only.
+type Anonymous User
+
+// AuthenticateRequest implements Authenticator
+func (a Anonymous) AuthenticateRequest(*http.Request) (*User, bool, error) {
+	user := User(a)
+	return &user, true, nil
+}
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFrom returns the user stored in ctx by Middleware
+func UserFrom(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*User)
+	return user, ok
+}
+
+// Middleware rejects requests that authenticator cannot identify with 401 and stores the
+// user of all other requests in their context. loginURL, if set, is returned to clients
+// so browsers know where to log in.
+func Middleware(authenticator Authenticator, loginURL string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok, err := authenticator.AuthenticateRequest(r)
+			if err != nil {
+				log.Printf("Authentication failed for %s %s: %v", r.Method, r.URL.Path, err)
+			}
+			if !ok {
+				writeUnauthorized(w, loginURL)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+		})
+	}
+}
+
+// unauthorizedResponse is the body of 401 responses
+type unauthorizedResponse struct {
+	Error    string `json:"error"`
+	LoginURL string `json:"loginUrl,omitempty"`
+}
+
+func writeUnauthorized(w http.ResponseWriter, loginURL string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="kubernetes-web-terminal"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(unauthorizedResponse{Error: "authentication required", LoginURL: loginURL})
+}
+
+// WhoAmIHandler returns the authenticated user, or 401 with the login URL
+func WhoAmIHandler(authenticator Authenticator, loginURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok, _ := authenticator.AuthenticateRequest(r)
+		if !ok {
+			writeUnauthorized(w, loginURL)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTokenReviewClient returns a fake clientset that authenticates tokens found in users
+// and counts the reviews it performs
+func newTokenReviewClient(users map[string]authenticationv1.UserInfo, reviews *int) *kubefake.Clientset {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview).DeepCopy()
+		if user, ok := users[review.Spec.Token]; ok {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: user}
+		} else {
+			review.Status = authenticationv1.TokenReviewStatus{Error: "token not recognized"}
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	reviews := 0
+	client := newTokenReviewClient(map[string]authenticationv1.UserInfo{
+		"good-token": {Username: "alice", UID: "1", Groups: []string{"dev"}, Extra: map[string]authenticationv1.ExtraValue{"scopes": {"a"}}},
+	}, &reviews)
+	authenticator := NewTokenReviewAuthenticator(client, nil)
+
+	request := func(header string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/pods", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return req
+	}
+
+	if _, ok, err := authenticator.AuthenticateRequest(request("")); ok || err != nil {
+		t.Errorf("Requests without a token must be skipped, got ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := authenticator.AuthenticateRequest(request("Basic dXNlcjpwYXNz")); ok || err != nil {
+		t.Errorf("Other schemes must be skipped, got ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := authenticator.AuthenticateRequest(request("Bearer bad-token")); ok || !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected invalid credentials, got ok=%v err=%v", ok, err)
+	}
+
+	user, ok, err := authenticator.AuthenticateRequest(request("Bearer good-token"))
+	if !ok || err != nil {
+		t.Fatalf("Expected token to be accepted, got ok=%v err=%v", ok, err)
+	}
+	if user.Name != "alice" || user.UID != "1" || len(user.Groups) != 1 || user.Extra["scopes"][0] != "a" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	// Successful reviews are cached until they expire
+	reviews = 0
+	authenticator.AuthenticateRequest(request("bearer good-token"))
+	if reviews != 0 {
+		t.Errorf("Expected cached review, got %d reviews", reviews)
+	}
+	authenticator.now = func() time.Time { return time.Now().Add(2 * tokenCacheTTL) }
+	authenticator.AuthenticateRequest(request("Bearer good-token"))
+	if reviews != 1 {
+		t.Errorf("Expected expired entry to be reviewed again, got %d reviews", reviews)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	authenticator := Union{
+		authenticatorFunc(func(r *http.Request) (*User, bool, error) { return nil, false, nil }),
+		authenticatorFunc(func(r *http.Request) (*User, bool, error) {
+			if r.Header.Get("X-Test-User") == "" {
+				return nil, false, nil
+			}
+			return &User{Name: r.Header.Get("X-Test-User")}, true, nil
+		}),
+	}
+	handler := Middleware(authenticator, "/auth/login")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFrom(r.Context())
+		w.Write([]byte(user.Name))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/pods", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"loginUrl":"/auth/login"`) || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Unexpected 401 response %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/pods", nil)
+	req.Header.Set("X-Test-User", "bob")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "bob" {
+		t.Errorf("Expected request as bob, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUserFromEmptyContext(t *testing.T) {
+	if _, ok := UserFrom(context.Background()); ok {
+		t.Error("Expected no user in an empty context")
+	}
+}
+
+type authenticatorFunc func(r *http.Request) (*User, bool, error)
+
+func (f authenticatorFunc) AuthenticateRequest(r *http.Request) (*User, bool, error) {
+	return f(r)
+}
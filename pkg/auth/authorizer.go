@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Attributes describe an action on a Kubernetes resource, as in a SubjectAccessReview
+type Attributes struct {
+	Namespace   string
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Name        string
+}
+
+// String returns the attributes in the form "create pods/exec" used in error messages
+func (a Attributes) String() string {
+	resource := a.Resource
+	if a.Group != "" {
+		resource += "." + a.Group
+	}
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+	return a.Verb + " " + resource
+}
+
+// Authorizer decides whether a user may perform an action. The reason explains denials.
+type Authorizer interface {
+	Authorize(ctx context.Context, user *User, attrs Attributes) (allowed bool, reason string, err error)
+}
+
+// ForbiddenError is returned by Authorize when the user may not perform an action
+type ForbiddenError struct {
+	User       string
+	Attributes Attributes
+	Reason     string
+}
+
+func (e *ForbiddenError) Error() string {
+	msg := fmt.Sprintf("user %q cannot %s", e.User, e.Attributes)
+	if e.Attributes.Name != "" {
+		msg += fmt.Sprintf(" %q", e.Attributes.Name)
+	}
+	if e.Attributes.Namespace != "" {
+		msg += fmt.Sprintf(" in namespace %q", e.Attributes.Namespace)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Authorize checks that the user stored in ctx may perform every action in attrs and
+// returns a *ForbiddenError otherwise. A nil authorizer allows everything; it is only used
+// when authentication is disabled.
+func Authorize(ctx context.Context, authorizer Authorizer, attrs ...Attributes) error {
+	if authorizer == nil {
+		return nil
+	}
+	user, ok := UserFrom(ctx)
+	if !ok {
+		return &ForbiddenError{Attributes: attrs[0], Reason: "no authenticated user"}
+	}
+	for _, a := range attrs {
+		allowed, reason, err := authorizer.Authorize(ctx, user, a)
+		if err != nil {
+			return fmt.Errorf("authorization check failed: %v", err)
+		}
+		if !allowed {
+			return &ForbiddenError{User: user.Name, Attributes: a, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// SubjectAccessReviewAuthorizer asks the API server whether a user is allowed to perform
+// an action, so decisions follow the cluster's RBAC rules
+type SubjectAccessReviewAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// NewSubjectAccessReviewAuthorizer creates an authorizer that issues SubjectAccessReviews
+// with client
+func NewSubjectAccessReviewAuthorizer(client kubernetes.Interface) *SubjectAccessReviewAuthorizer {
+	return &SubjectAccessReviewAuthorizer{client: client}
+}
+
+// Authorize implements Authorizer
+func (a *SubjectAccessReviewAuthorizer) Authorize(ctx context.Context, user *User, attrs Attributes) (bool, string, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Name,
+			UID:    user.UID,
+			Groups: user.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   attrs.Namespace,
+				Verb:        attrs.Verb,
+				Group:       attrs.Group,
+				Resource:    attrs.Resource,
+				Subresource: attrs.Subresource,
+				Name:        attrs.Name,
+			},
+		},
+	}
+	if len(user.Extra) > 0 {
+		review.Spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for key, values := range user.Extra {
+			review.Spec.Extra[key] = values
+		}
+	}
+
+	result, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	reason := result.Status.Reason
+	if reason == "" {
+		reason = result.Status.EvaluationError
+	}
+	return result.Status.Allowed && !result.Status.Denied, reason, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSubjectAccessReviewAuthorizer(t *testing.T) {
+	var reviewed authorizationv1.SubjectAccessReviewSpec
+	client := kubefake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+		reviewed = review.Spec
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attrs.Namespace == "dev"
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+	authorizer := NewSubjectAccessReviewAuthorizer(client)
+
+	alice := &User{Name: "alice", UID: "1", Groups: []string{"dev"}, Extra: map[string][]string{"scopes": {"x"}}}
+	exec := Attributes{Namespace: "dev", Verb: "create", Resource: "pods", Subresource: "exec", Name: "web-0"}
+	allowed, _, err := authorizer.Authorize(context.Background(), alice, exec)
+	if err != nil || !allowed {
+		t.Fatalf("Expected alice to be allowed, got %v, %v", allowed, err)
+	}
+	attrs := reviewed.ResourceAttributes
+	if reviewed.User != "alice" || reviewed.UID != "1" || reviewed.Groups[0] != "dev" || reviewed.Extra["scopes"][0] != "x" {
+		t.Errorf("Unexpected subject %+v", reviewed)
+	}
+	if attrs.Verb != "create" || attrs.Resource != "pods" || attrs.Subresource != "exec" || attrs.Name != "web-0" {
+		t.Errorf("Unexpected resource attributes %+v", attrs)
+	}
+
+	exec.Namespace = "prod"
+	allowed, reason, err := authorizer.Authorize(context.Background(), alice, exec)
+	if err != nil || allowed || reason != "no RBAC policy matched" {
+		t.Errorf("Expected a denial with reason, got %v, %q, %v", allowed, reason, err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	client := kubefake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource == "pods"
+		return true, review, nil
+	})
+	authorizer := NewSubjectAccessReviewAuthorizer(client)
+	exec := Attributes{Namespace: "dev", Verb: "create", Resource: "pods", Subresource: "exec", Name: "web-0"}
+	jobs := Attributes{Namespace: "dev", Verb: "create", Group: "batch", Resource: "jobs"}
+	ctx := WithUser(context.Background(), &User{Name: "alice"})
+
+	if err := Authorize(ctx, authorizer, exec); err != nil {
+		t.Errorf("Expected exec to be allowed, got %v", err)
+	}
+
+	var forbidden *ForbiddenError
+	err := Authorize(ctx, authorizer, exec, jobs)
+	if !errors.As(err, &forbidden) || forbidden.Attributes != jobs {
+		t.Fatalf("Expected the Job check to fail, got %v", err)
+	}
+	if want := `user "alice" cannot create jobs.batch in namespace "dev"`; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Expected message %q, got %q", want, err)
+	}
+
+	if err := Authorize(context.Background(), authorizer, exec); !errors.As(err, &forbidden) {
+		t.Errorf("Expected requests without a user to be refused, got %v", err)
+	}
+	if err := Authorize(context.Background(), nil, exec); err != nil {
+		t.Errorf("A nil authorizer must allow everything, got %v", err)
+	}
+}
@@ -0,0 +1,544 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookie holds the ID of an OIDC login session
+	SessionCookie = "kwt_session"
+	// stateCookie carries the state, nonce and PKCE verifier of a login in progress
+	stateCookie = "kwt_oidc_state"
+	// loginTimeout bounds how long a user may take to log in with the provider
+	loginTimeout = 10 * time.Minute
+	// clockSkew is tolerated when checking token expiry
+	clockSkew = time.Minute
+	// keyRefreshInterval is the least time between fetches of the provider's key set, so
+	// tokens with unknown key IDs cannot make the server hammer the provider
+	keyRefreshInterval = time.Minute
+)
+
+// OIDCConfig configures login with an OpenID Connect provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback handler registered with the provider
+	RedirectURL string
+	Scopes      []string
+	// UsernameClaim and GroupsClaim name the ID token claims that identify the user,
+	// "email" and "groups" by default. The prefixes are prepended to their values, like the
+	// API server's --oidc-username-prefix and --oidc-groups-prefix.
+	UsernameClaim  string
+	UsernamePrefix string
+	GroupsClaim    string
+	GroupsPrefix   string
+	SessionTTL     time.Duration
+	// SecureCookies marks cookies Secure; disable only when serving plain HTTP locally
+	SecureCookies bool
+	HTTPClient    *http.Client
+}
+
+// providerMetadata is the subset of the OIDC discovery document that is used
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCAuthenticator logs users in with the authorization code flow and authenticates
+// requests by their session cookie
+type OIDCAuthenticator struct {
+	config   OIDCConfig
+	provider providerMetadata
+	sessions *SessionStore
+	now      func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	// keysFetched is when the key set was last fetched; refreshing is closed when a fetch
+	// in progress finishes
+	keysFetched time.Time
+	refreshing  chan struct{}
+}
+
+// NewOIDCAuthenticator discovers the provider's endpoints and returns an authenticator
+func NewOIDCAuthenticator(ctx context.Context, config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.IssuerURL == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC issuer URL, client ID and redirect URL are required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.SessionTTL == 0 {
+		config.SessionTTL = 8 * time.Hour
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	a := &OIDCAuthenticator{
+		config:   config,
+		sessions: NewSessionStore(config.SessionTTL),
+		now:      time.Now,
+	}
+	discoveryURL := strings.TrimSuffix(config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	if err := a.getJSON(ctx, discoveryURL, &a.provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if a.provider.Issuer != config.IssuerURL {
+		return nil, fmt.Errorf("OIDC provider reports issuer %q, expected %q", a.provider.Issuer, config.IssuerURL)
+	}
+	if a.provider.AuthorizationEndpoint == "" || a.provider.TokenEndpoint == "" || a.provider.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document of %s is incomplete", config.IssuerURL)
+	}
+	return a, nil
+}
+
+// AuthenticateRequest implements Authenticator for requests carrying a session cookie
+func (a *OIDCAuthenticator) AuthenticateRequest(r *http.Request) (*User, bool, error) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, false, nil
+	}
+	user, ok := a.sessions.Get(cookie.Value)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: unknown or expired session", ErrInvalidCredentials)
+	}
+	return user, true, nil
+}
+
+// LoginHandler redirects the browser to the provider. The optional "redirect" query
+// parameter is a local path to return to after logging in.
+func (a *OIDCAuthenticator) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := randomString(), randomString(), randomString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    strings.Join([]string{state, nonce, verifier, base64.RawURLEncoding.EncodeToString([]byte(localPath(r.URL.Query().Get("redirect"))))}, "."),
+		Path:     "/",
+		MaxAge:   int(loginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   a.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.config.ClientID},
+		"redirect_uri":          {a.config.RedirectURL},
+		"scope":                 {strings.Join(a.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	target := a.provider.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// CallbackHandler completes a login: it exchanges the authorization code for an ID token,
+// verifies it and starts a session
+func (a *OIDCAuthenticator) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: a.config.SecureCookies})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 || r.URL.Query().Get("state") != parts[0] {
+		http.Error(w, "Login state mismatch, please try again", http.StatusBadRequest)
+		return
+	}
+	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s %s", errMsg, r.URL.Query().Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+	redirect := "/"
+	if decoded, err := base64.RawURLEncoding.DecodeString(parts[3]); err == nil {
+		redirect = localPath(string(decoded))
+	}
+
+	rawIDToken, err := a.exchangeCode(r.Context(), r.URL.Query().Get("code"), verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	user, err := a.verifyIDToken(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("User %s logged in", user.Name)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    a.sessions.Create(user),
+		Path:     "/",
+		MaxAge:   int(a.config.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   a.config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// LogoutHandler ends the caller's session
+func (a *OIDCAuthenticator) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		a.sessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: a.config.SecureCookies})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// exchangeCode redeems an authorization code at the token endpoint and returns the ID token
+func (a *OIDCAuthenticator) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("no authorization code in callback")
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	resp, err := a.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the signature and claims of an ID token and returns its user
+func (a *OIDCAuthenticator) verifyIDToken(ctx context.Context, rawToken, nonce string) (*User, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature encoding: %v", err)
+	}
+	key, err := a.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %v", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != a.config.IssuerURL {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !audienceContains(claims["aud"], a.config.ClientID) {
+		return nil, fmt.Errorf("token was not issued for client %q", a.config.ClientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	if a.now().After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	username, _ := claims[a.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("claim %q is missing", a.config.UsernameClaim)
+	}
+	if a.config.UsernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return nil, fmt.Errorf("email %q is not verified", username)
+		}
+	}
+	user := &User{Name: a.config.UsernamePrefix + username}
+	switch groups := claims[a.config.GroupsClaim].(type) {
+	case string:
+		user.Groups = []string{a.config.GroupsPrefix + groups}
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				user.Groups = append(user.Groups, a.config.GroupsPrefix+name)
+			}
+		}
+	}
+	return user, nil
+}
+
+// signingKey returns the provider key with the given ID, fetching the key set again if
+// the provider has rotated its keys. Fetches happen at most once per keyRefreshInterval
+// and concurrent callers wait for the same fetch.
+func (a *OIDCAuthenticator) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	if key, ok := a.lookupKey(kid); ok {
+		a.mu.Unlock()
+		return key, nil
+	}
+	if refreshing := a.refreshing; refreshing != nil {
+		a.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if key, ok := a.lookupKey(kid); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("no signing key %q", kid)
+	}
+	if !a.keysFetched.IsZero() && a.now().Sub(a.keysFetched) < keyRefreshInterval {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("no signing key %q", kid)
+	}
+	refreshing := make(chan struct{})
+	a.refreshing = refreshing
+	a.mu.Unlock()
+
+	keys, err := a.fetchKeys(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keysFetched = a.now()
+	a.refreshing = nil
+	close(refreshing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	a.keys = keys
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key %q", kid)
+}
+
+// fetchKeys downloads the provider's signing keys
+func (a *OIDCAuthenticator) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(ctx, a.provider.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Ignoring OIDC signing key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// lookupKey finds a cached key. Tokens without a key ID are accepted when the provider
+// publishes a single key.
+func (a *OIDCAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := a.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (a *OIDCAuthenticator) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// jsonWebKey is an RSA or EC public key from a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifySignature checks an RS256 or ES256 JWT signature
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audienceContains reports whether an "aud" claim, a string or a list, contains clientID
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, entry := range v {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localPath returns path if it is a path on this server, to avoid open redirects
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, `/\`) {
+		return "/"
+	}
+	return path
+}
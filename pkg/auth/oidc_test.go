@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a minimal OIDC provider that logs every visitor in with fixed claims
+type fakeProvider struct {
+	*httptest.Server
+	t      *testing.T
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu          sync.Mutex
+	grants      map[string]url.Values
+	keyFetches  int
+	keysRelease chan struct{}
+}
+
+func newFakeProvider(t *testing.T, claims map[string]interface{}) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &fakeProvider{t: t, key: key, claims: claims, grants: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.keyFetches++
+		release := p.keysRelease
+		p.mu.Unlock()
+		if release != nil {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := randomString()
+		p.mu.Lock()
+		p.grants[code] = r.URL.Query()
+		p.mu.Unlock()
+		redirect := r.URL.Query().Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {r.URL.Query().Get("state")}}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "web-terminal" || secret != "s3cret" {
+			http.Error(w, "bad client credentials", http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		grant, ok := p.grants[r.PostFormValue("code")]
+		delete(p.grants, r.PostFormValue("code"))
+		p.mu.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || grant.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{"nonce": grant.Get("nonce")}
+		for name, value := range p.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(claims)})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign issues an RS256 ID token for the test client with the given extra claims
+func (p *fakeProvider) sign(extra map[string]interface{}) string {
+	claims := map[string]interface{}{
+		"iss": p.URL,
+		"aud": "web-terminal",
+		"sub": "1234",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestOIDCAuthenticator(t *testing.T, provider *fakeProvider, redirectURL string) *OIDCAuthenticator {
+	t.Helper()
+	authenticator, err := NewOIDCAuthenticator(context.Background(), OIDCConfig{
+		IssuerURL:      provider.URL,
+		ClientID:       "web-terminal",
+		ClientSecret:   "s3cret",
+		RedirectURL:    redirectURL,
+		UsernamePrefix: "oidc:",
+		GroupsPrefix:   "oidc:",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	return authenticator
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	provider := newFakeProvider(t, map[string]interface{}{
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"admins", "dev"},
+	})
+
+	var authenticator *OIDCAuthenticator
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+	authenticator = newTestOIDCAuthenticator(t, provider, app.URL+"/auth/callback")
+	mux.HandleFunc("/auth/login", authenticator.LoginHandler)
+	mux.HandleFunc("/auth/callback", authenticator.CallbackHandler)
+	mux.HandleFunc("/auth/logout", authenticator.LogoutHandler)
+	mux.Handle("/", Middleware(authenticator, "/auth/login")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFrom(r.Context())
+		json.NewEncoder(w).Encode(struct {
+			Path string
+			User *User
+		}{r.URL.Path, user})
+	})))
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	resp, err := client.Get(app.URL + "/auth/login?redirect=/scripts")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected login to end at the app, got %s", resp.Status)
+	}
+	var got struct {
+		Path string
+		User *User
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got.Path != "/scripts" {
+		t.Errorf("Expected redirect back to /scripts, got %s", got.Path)
+	}
+	if got.User.Name != "oidc:alice@example.com" || strings.Join(got.User.Groups, ",") != "oidc:admins,oidc:dev" {
+		t.Errorf("Unexpected user %+v", got.User)
+	}
+
+	// Logging out ends the session
+	resp, err = client.Post(app.URL+"/auth/logout", "", nil)
+	if err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = client.Get(app.URL + "/api/pods")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after logout, got %s", resp.Status)
+	}
+}
+
+func TestOIDCCallbackRejectsForgedState(t *testing.T) {
+	provider := newFakeProvider(t, map[string]interface{}{"email": "alice@example.com"})
+	authenticator := newTestOIDCAuthenticator(t, provider, "http://terminal.example.com/auth/callback")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/auth/callback?code=abc&state=attacker", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: "victim.nonce.verifier.Lw"})
+	authenticator.CallbackHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == SessionCookie {
+			t.Error("Session must not be created for a forged state")
+		}
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider := newFakeProvider(t, nil)
+	authenticator := newTestOIDCAuthenticator(t, provider, "http://terminal.example.com/auth/callback")
+	ctx := context.Background()
+
+	valid := provider.sign(map[string]interface{}{"email": "alice@example.com", "groups": "dev", "nonce": "n"})
+	user, err := authenticator.verifyIDToken(ctx, valid, "n")
+	if err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+	if user.Name != "oidc:alice@example.com" || len(user.Groups) != 1 || user.Groups[0] != "oidc:dev" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forger := &fakeProvider{Server: provider.Server, t: t, key: other}
+
+	testCases := map[string]string{
+		"wrong nonce":      valid,
+		"wrong audience":   provider.sign(map[string]interface{}{"email": "a@example.com", "nonce": "x", "aud": []string{"other"}}),
+		"wrong issuer":     provider.sign(map[string]interface{}{"email": "a@example.com", "nonce": "x", "iss": "https://evil.example.com"}),
+		"expired":          provider.sign(map[string]interface{}{"email": "a@example.com", "nonce": "x", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"unverified email": provider.sign(map[string]interface{}{"email": "a@example.com", "nonce": "x", "email_verified": false}),
+		"missing username": provider.sign(map[string]interface{}{"nonce": "x"}),
+		"bad signature":    forger.sign(map[string]interface{}{"email": "a@example.com", "nonce": "x"}),
+		"unsigned":         strings.Join(strings.Split(valid, ".")[:2], ".") + ".",
+	}
+	for name, token := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := authenticator.verifyIDToken(ctx, token, "x"); err == nil {
+				t.Error("Expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestSigningKeyRefreshIsRateLimited(t *testing.T) {
+	provider := newFakeProvider(t, nil)
+	authenticator := newTestOIDCAuthenticator(t, provider, "http://terminal.example.com/auth/callback")
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+	ctx := context.Background()
+	fetches := func() int {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		return provider.keyFetches
+	}
+
+	// Concurrent lookups share a single fetch
+	release := make(chan struct{})
+	provider.mu.Lock()
+	provider.keysRelease = release
+	provider.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := authenticator.signingKey(ctx, "test"); err != nil {
+				t.Errorf("Expected the signing key, got %v", err)
+			}
+		}()
+	}
+	for fetches() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if got := fetches(); got != 1 {
+		t.Fatalf("Expected one fetch for concurrent lookups, got %d", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := authenticator.signingKey(ctx, "unknown"); err == nil {
+			t.Fatal("Expected an unknown key ID to be rejected")
+		}
+	}
+	if got := fetches(); got != 1 {
+		t.Errorf("Expected unknown key IDs not to refetch within the interval, got %d fetches", got)
+	}
+
+	now = now.Add(keyRefreshInterval)
+	authenticator.signingKey(ctx, "unknown")
+	if got := fetches(); got != 2 {
+		t.Errorf("Expected a refetch after the interval, got %d fetches", got)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/scripts":             "/scripts",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		`/\evil.example`:       "/",
+	} {
+		if got := localPath(path); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
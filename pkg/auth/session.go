@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// SessionStore keeps logged-in users in memory, keyed by the random ID stored in their
+// session cookie. Sessions do not survive a restart.
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+type session struct {
+	user    *User
+	expires time.Time
+}
+
+// NewSessionStore creates a store whose sessions expire after ttl
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{sessions: make(map[string]session), ttl: ttl, now: time.Now}
+}
+
+// Create starts a session for user and returns its ID
+func (s *SessionStore) Create(user *User) string {
+	id := randomString()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, existing := range s.sessions {
+		if now.After(existing.expires) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[id] = session{user: user, expires: now.Add(s.ttl)}
+	return id
+}
+
+// Get returns the user of a session that has not expired
+func (s *SessionStore) Get(id string) (*User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if s.now().After(existing.expires) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	return existing.user, true
+}
+
+// Delete ends a session
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// randomString returns 32 random bytes, base64url-encoded
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// tokenCacheTTL is how long a successful TokenReview is reused
+	tokenCacheTTL = time.Minute
+	// maxCachedTokens bounds the TokenReview cache
+	maxCachedTokens = 1000
+)
+
+// TokenReviewAuthenticator validates bearer tokens with the Kubernetes TokenReview API,
+// so any token the API server accepts (service account, OIDC, webhook) can be used
+type TokenReviewAuthenticator struct {
+	client    kubernetes.Interface
+	audiences []string
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cachedToken
+	now   func() time.Time
+}
+
+type cachedToken struct {
+	user    *User
+	expires time.Time
+}
+
+// NewTokenReviewAuthenticator creates an authenticator that reviews tokens for the given
+// audiences. With no audiences the API server's default audience is used.
+func NewTokenReviewAuthenticator(client kubernetes.Interface, audiences []string) *TokenReviewAuthenticator {
+	return &TokenReviewAuthenticator{
+		client:    client,
+		audiences: audiences,
+		cache:     make(map[[sha256.Size]byte]cachedToken),
+		now:       time.Now,
+	}
+}
+
+// AuthenticateRequest implements Authenticator for "Authorization: Bearer" headers
+func (a *TokenReviewAuthenticator) AuthenticateRequest(r *http.Request) (*User, bool, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, false, nil
+	}
+
+	key := sha256.Sum256([]byte(token))
+	if user, ok := a.cached(key); ok {
+		return user, true, nil
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("token review failed: %v", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, false, fmt.Errorf("%w: %s", ErrInvalidCredentials, review.Status.Error)
+		}
+		return nil, false, ErrInvalidCredentials
+	}
+
+	reviewed := review.Status.User
+	user := &User{Name: reviewed.Username, UID: reviewed.UID, Groups: reviewed.Groups}
+	if len(reviewed.Extra) > 0 {
+		user.Extra = make(map[string][]string, len(reviewed.Extra))
+		for key, values := range reviewed.Extra {
+			user.Extra[key] = values
+		}
+	}
+	a.store(key, user)
+	return user, true, nil
+}
+
+func (a *TokenReviewAuthenticator) cached(key [sha256.Size]byte) (*User, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.cache[key]
+	if !ok || a.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.user, true
+}
+
+func (a *TokenReviewAuthenticator) store(key [sha256.Size]byte, user *User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= maxCachedTokens {
+		a.cache = make(map[[sha256.Size]byte]cachedToken)
+	}
+	a.cache[key] = cachedToken{user: user, expires: a.now().Add(tokenCacheTTL)}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
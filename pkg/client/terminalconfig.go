@@ -23,7 +23,7 @@ type TerminalConfigClient struct {
 func NewTerminalConfigClient(config *rest.Config, namespace string) (*TerminalConfigClient, error) {
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
 	return &TerminalConfigClient{
@@ -32,6 +32,14 @@ func NewTerminalConfigClient(config *rest.Config, namespace string) (*TerminalCo
 	}, nil
 }
 
+// NewTerminalConfigClientFromDynamic creates a TerminalConfig client backed by an existing dynamic client
+func NewTerminalConfigClientFromDynamic(dynamicClient dynamic.Interface, namespace string) *TerminalConfigClient {
+	return &TerminalConfigClient{
+		dynamicClient: dynamicClient,
+		namespace:     namespace,
+	}
+}
+
 // gvr returns the GroupVersionResource for TerminalConfig
 func (c *TerminalConfigClient) gvr() schema.GroupVersionResource {
 	return schema.GroupVersionResource{
@@ -46,13 +54,13 @@ func (c *TerminalConfigClient) Get(ctx context.Context, name string) (*terminalv
 	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
 	unstructured, err := resource.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get TerminalConfig %s: %v", name, err)
+		return nil, fmt.Errorf("failed to get TerminalConfig %s: %w", name, err)
 	}
 
 	var terminalConfig terminalv1.TerminalConfig
 	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructured.UnstructuredContent(), &terminalConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert unstructured to TerminalConfig: %v", err)
+		return nil, fmt.Errorf("failed to convert unstructured to TerminalConfig: %w", err)
 	}
 
 	return &terminalConfig, nil
@@ -63,13 +71,13 @@ func (c *TerminalConfigClient) List(ctx context.Context) (*terminalv1.TerminalCo
 	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
 	unstructuredList, err := resource.List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list TerminalConfigs: %v", err)
+		return nil, fmt.Errorf("failed to list TerminalConfigs: %w", err)
 	}
 
 	var terminalConfigList terminalv1.TerminalConfigList
 	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredList.UnstructuredContent(), &terminalConfigList)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert unstructured list to TerminalConfigList: %v", err)
+		return nil, fmt.Errorf("failed to convert unstructured list to TerminalConfigList: %w", err)
 	}
 
 	return &terminalConfigList, nil
@@ -79,20 +87,20 @@ func (c *TerminalConfigClient) List(ctx context.Context) (*terminalv1.TerminalCo
 func (c *TerminalConfigClient) Create(ctx context.Context, tc *terminalv1.TerminalConfig) (*terminalv1.TerminalConfig, error) {
 	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert TerminalConfig to unstructured: %v", err)
+		return nil, fmt.Errorf("failed to convert TerminalConfig to unstructured: %w", err)
 	}
 
 	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
 	unstructured := &unstructured.Unstructured{Object: unstructuredObj}
 	created, err := resource.Create(ctx, unstructured, metav1.CreateOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create TerminalConfig: %v", err)
+		return nil, fmt.Errorf("failed to create TerminalConfig: %w", err)
 	}
 
 	var result terminalv1.TerminalConfig
 	err = runtime.DefaultUnstructuredConverter.FromUnstructured(created.UnstructuredContent(), &result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert created object to TerminalConfig: %v", err)
+		return nil, fmt.Errorf("failed to convert created object to TerminalConfig: %w", err)
 	}
 
 	return &result, nil
@@ -102,20 +110,43 @@ func (c *TerminalConfigClient) Create(ctx context.Context, tc *terminalv1.Termin
 func (c *TerminalConfigClient) Update(ctx context.Context, tc *terminalv1.TerminalConfig) (*terminalv1.TerminalConfig, error) {
 	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert TerminalConfig to unstructured: %v", err)
+		return nil, fmt.Errorf("failed to convert TerminalConfig to unstructured: %w", err)
 	}
 
 	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
 	unstructured := &unstructured.Unstructured{Object: unstructuredObj}
 	updated, err := resource.Update(ctx, unstructured, metav1.UpdateOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update TerminalConfig: %v", err)
+		return nil, fmt.Errorf("failed to update TerminalConfig: %w", err)
+	}
+
+	var result terminalv1.TerminalConfig
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(updated.UnstructuredContent(), &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert updated object to TerminalConfig: %w", err)
+	}
+
+	return &result, nil
+}
+
+// UpdateStatus updates the status subresource of an existing TerminalConfig
+func (c *TerminalConfigClient) UpdateStatus(ctx context.Context, tc *terminalv1.TerminalConfig) (*terminalv1.TerminalConfig, error) {
+	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert TerminalConfig to unstructured: %w", err)
+	}
+
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	unstructured := &unstructured.Unstructured{Object: unstructuredObj}
+	updated, err := resource.UpdateStatus(ctx, unstructured, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update TerminalConfig status: %w", err)
 	}
 
 	var result terminalv1.TerminalConfig
 	err = runtime.DefaultUnstructuredConverter.FromUnstructured(updated.UnstructuredContent(), &result)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert updated object to TerminalConfig: %v", err)
+		return nil, fmt.Errorf("failed to convert updated object to TerminalConfig: %w", err)
 	}
 
 	return &result, nil
@@ -126,7 +157,7 @@ func (c *TerminalConfigClient) Delete(ctx context.Context, name string) error {
 	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
 	err := resource.Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to delete TerminalConfig %s: %v", name, err)
+		return fmt.Errorf("failed to delete TerminalConfig %s: %w", name, err)
 	}
 	return nil
 }
\ No newline at end of file
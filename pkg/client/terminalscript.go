@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// TerminalScriptClient provides a client for TerminalScript resources. API errors are
+// wrapped, so they can be inspected with k8s.io/apimachinery/pkg/api/errors.
+type TerminalScriptClient struct {
+	dynamicClient dynamic.Interface
+	namespace     string
+}
+
+// NewTerminalScriptClient creates a new TerminalScript client
+func NewTerminalScriptClient(config *rest.Config, namespace string) (*TerminalScriptClient, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+
+	return NewTerminalScriptClientFromDynamic(dynamicClient, namespace), nil
+}
+
+// NewTerminalScriptClientFromDynamic creates a TerminalScript client backed by an existing dynamic client
+func NewTerminalScriptClientFromDynamic(dynamicClient dynamic.Interface, namespace string) *TerminalScriptClient {
+	return &TerminalScriptClient{
+		dynamicClient: dynamicClient,
+		namespace:     namespace,
+	}
+}
+
+// gvr returns the GroupVersionResource for TerminalScript
+func (c *TerminalScriptClient) gvr() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    terminalv1.SchemeGroupVersion.Group,
+		Version:  terminalv1.SchemeGroupVersion.Version,
+		Resource: "terminalscripts",
+	}
+}
+
+// Get retrieves a TerminalScript by name
+func (c *TerminalScriptClient) Get(ctx context.Context, name string) (*terminalv1.TerminalScript, error) {
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TerminalScript %s: %w", name, err)
+	}
+	return fromUnstructuredScript(obj)
+}
+
+// List retrieves all TerminalScripts in the namespace
+func (c *TerminalScriptClient) List(ctx context.Context) (*terminalv1.TerminalScriptList, error) {
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	unstructuredList, err := resource.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list TerminalScripts: %w", err)
+	}
+
+	var terminalScriptList terminalv1.TerminalScriptList
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredList.UnstructuredContent(), &terminalScriptList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert unstructured list to TerminalScriptList: %v", err)
+	}
+
+	return &terminalScriptList, nil
+}
+
+// Create creates a new TerminalScript
+func (c *TerminalScriptClient) Create(ctx context.Context, ts *terminalv1.TerminalScript) (*terminalv1.TerminalScript, error) {
+	obj, err := toUnstructuredScript(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	created, err := resource.Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TerminalScript: %w", err)
+	}
+	return fromUnstructuredScript(created)
+}
+
+// Update updates an existing TerminalScript
+func (c *TerminalScriptClient) Update(ctx context.Context, ts *terminalv1.TerminalScript) (*terminalv1.TerminalScript, error) {
+	obj, err := toUnstructuredScript(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	updated, err := resource.Update(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update TerminalScript: %w", err)
+	}
+	return fromUnstructuredScript(updated)
+}
+
+// Delete deletes a TerminalScript by name
+func (c *TerminalScriptClient) Delete(ctx context.Context, name string) error {
+	resource := c.dynamicClient.Resource(c.gvr()).Namespace(c.namespace)
+	err := resource.Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete TerminalScript %s: %w", name, err)
+	}
+	return nil
+}
+
+// toUnstructuredScript converts a TerminalScript for the dynamic client
+func toUnstructuredScript(ts *terminalv1.TerminalScript) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert TerminalScript to unstructured: %v", err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// fromUnstructuredScript converts an object returned by the dynamic client into a TerminalScript
+func fromUnstructuredScript(obj *unstructured.Unstructured) (*terminalv1.TerminalScript, error) {
+	var ts terminalv1.TerminalScript
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &ts); err != nil {
+		return nil, fmt.Errorf("failed to convert unstructured to TerminalScript: %v", err)
+	}
+	return &ts, nil
+}
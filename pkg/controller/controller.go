@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// resyncPeriod is how often informers replay their cache to the event handlers
+const resyncPeriod = 10 * time.Minute
+
+// TerminalConfigResource is the GroupVersionResource of TerminalConfig objects
+var TerminalConfigResource = terminalv1.SchemeGroupVersion.WithResource("terminalconfigs")
+
+// Controller provisions a terminal pod for every TerminalConfig in a namespace and
+// reports the pod's state in the config's status
+type Controller struct {
+	kubeClient     kubernetes.Interface
+	terminalClient *client.TerminalConfigClient
+
+	configInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	podInformerFactory    informers.SharedInformerFactory
+
+	configLister dynamiclister.Lister
+	configSynced cache.InformerSynced
+	podLister    corelisters.PodLister
+	podSynced    cache.InformerSynced
+
+	queue workqueue.RateLimitingInterface
+}
+
+// New creates a TerminalConfig controller watching the given namespace
+func New(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, namespace string) *Controller {
+	configInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, resyncPeriod, namespace, nil)
+	podInformerFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = ConfigLabel
+		}),
+	)
+
+	configInformer := configInformerFactory.ForResource(TerminalConfigResource)
+	podInformer := podInformerFactory.Core().V1().Pods()
+
+	c := &Controller{
+		kubeClient:            kubeClient,
+		terminalClient:        client.NewTerminalConfigClientFromDynamic(dynamicClient, namespace),
+		configInformerFactory: configInformerFactory,
+		podInformerFactory:    podInformerFactory,
+		configLister:          dynamiclister.New(configInformer.Informer().GetIndexer(), TerminalConfigResource),
+		configSynced:          configInformer.Informer().HasSynced,
+		podLister:             podInformer.Lister(),
+		podSynced:             podInformer.Informer().HasSynced,
+		queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "TerminalConfigs"),
+	}
+
+	configInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueConfig,
+		UpdateFunc: func(_, newObj interface{}) { c.enqueueConfig(newObj) },
+		DeleteFunc: c.enqueueConfig,
+	})
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handlePod,
+		UpdateFunc: func(_, newObj interface{}) { c.handlePod(newObj) },
+		DeleteFunc: c.handlePod,
+	})
+
+	return c
+}
+
+// Run starts the informers and processes TerminalConfigs until ctx is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer c.queue.ShutDown()
+
+	c.configInformerFactory.Start(ctx.Done())
+	c.podInformerFactory.Start(ctx.Done())
+
+	log.Printf("Waiting for TerminalConfig controller caches to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.configSynced, c.podSynced) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	log.Printf("Starting %d TerminalConfig controller workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-ctx.Done()
+	log.Printf("Stopping TerminalConfig controller")
+	return nil
+}
+
+// runWorker processes queue items until the queue is shut down
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem reconciles a single key, requeueing it with backoff on failure
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(string)
+	if err := c.sync(ctx, key); err != nil {
+		log.Printf("Error syncing TerminalConfig %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// enqueueConfig adds the key of a TerminalConfig to the work queue
+func (c *Controller) enqueueConfig(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("Failed to get key for TerminalConfig: %v", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// handlePod enqueues the TerminalConfig that controls a terminal pod
+func (c *Controller) handlePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if pod, ok = tombstone.Obj.(*corev1.Pod); !ok {
+			return
+		}
+	}
+
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "TerminalConfig" || ownerRef.APIVersion != terminalv1.SchemeGroupVersion.String() {
+		return
+	}
+	c.queue.Add(pod.Namespace + "/" + ownerRef.Name)
+}
+
+// sync reconciles the terminal pod of a single TerminalConfig
+func (c *Controller) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+
+	obj, err := c.configLister.Namespace(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// The pod is removed by the garbage collector through its owner reference
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tc, err := fromUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	if tc.DeletionTimestamp != nil {
+		return nil
+	}
+
+	// TerminalConfigs applied with kubectl skip the web API's checks, so privileged ones
+	// get no pod until they are fixed
+	if err := ValidateSecurityContext(tc.Spec.SecurityContext); err != nil {
+		log.Printf("Not provisioning a pod for TerminalConfig %s: %v", key, err)
+		return c.updateStatus(ctx, tc, invalidStatus(tc, err, metav1.Now()))
+	}
+
+	pod, err := c.reconcilePod(ctx, tc)
+	now := metav1.Now()
+	if err != nil {
+		if conflict, ok := err.(*podConflictError); ok {
+			if statusErr := c.updateStatus(ctx, tc, conflictStatus(tc, conflict, now)); statusErr != nil {
+				return statusErr
+			}
+		}
+		return err
+	}
+
+	mountErr, err := c.checkFileMounts(ctx, tc)
+	if err != nil {
+		return err
+	}
+
+	return c.updateStatus(ctx, tc, computeStatus(tc, pod, mountErr, now))
+}
+
+// podConflictError reports a pod with the terminal pod's name that the config does not control
+type podConflictError struct {
+	pod    string
+	config string
+}
+
+func (e *podConflictError) Error() string {
+	return fmt.Sprintf("pod %s already exists and is not controlled by TerminalConfig %s", e.pod, e.config)
+}
+
+// reconcilePod creates or replaces the terminal pod of a TerminalConfig. It returns
+// the current pod, or nil while the pod is being replaced.
+func (c *Controller) reconcilePod(ctx context.Context, tc *terminalv1.TerminalConfig) (*corev1.Pod, error) {
+	namespace, name := tc.Namespace, tc.Name
+	desired := BuildPod(tc)
+	existing, err := c.podLister.Pods(namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		log.Printf("Creating terminal pod %s/%s for TerminalConfig %s", namespace, desired.Name, name)
+		created, err := c.kubeClient.CoreV1().Pods(namespace).Create(ctx, desired, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return nil, nil
+		}
+		return created, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(existing, tc) {
+		return nil, &podConflictError{pod: namespace + "/" + existing.Name, config: name}
+	}
+
+	if existing.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	if existing.Annotations[SpecHashAnnotation] != desired.Annotations[SpecHashAnnotation] {
+		// Pod specs are mostly immutable, so replace the pod. The delete event
+		// requeues the config and the next sync creates the updated pod.
+		log.Printf("TerminalConfig %s changed, replacing terminal pod %s/%s", name, namespace, existing.Name)
+		err = c.kubeClient.CoreV1().Pods(namespace).Delete(ctx, existing.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &existing.UID},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return existing, nil
+}
+
+// fromUnstructured converts an informer object into a typed TerminalConfig
+func fromUnstructured(obj *unstructured.Unstructured) (*terminalv1.TerminalConfig, error) {
+	var tc terminalv1.TerminalConfig
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &tc); err != nil {
+		return nil, fmt.Errorf("failed to convert unstructured to TerminalConfig: %v", err)
+	}
+	return &tc, nil
+}
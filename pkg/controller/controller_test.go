@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTerminalConfig() *terminalv1.TerminalConfig {
+	runAsNonRoot := true
+	return &terminalv1.TerminalConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: terminalv1.SchemeGroupVersion.String(),
+			Kind:       "TerminalConfig",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dev",
+			Namespace: "default",
+			UID:       types.UID("dev-uid"),
+		},
+		Spec: terminalv1.TerminalConfigSpec{
+			Image:   "alpine:3.19",
+			Command: []string{"/bin/sh"},
+			Args:    []string{"-l"},
+			FileMounts: []terminalv1.FileMount{
+				{
+					Name:      "config",
+					MountPath: "/etc/app",
+					ConfigMapRef: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"},
+					},
+					ReadOnly: true,
+				},
+				{
+					Name:      "secrets",
+					MountPath: "/etc/secrets",
+					SecretRef: &corev1.SecretVolumeSource{SecretName: "app-secrets"},
+					ReadOnly:  true,
+				},
+				{
+					Name:      "data",
+					MountPath: "/data",
+					VolumeRef: &terminalv1.VolumeReference{Name: "shared-data", SubPath: "dev"},
+				},
+			},
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &runAsNonRoot},
+		},
+	}
+}
+
+func toUnstructured(t *testing.T, tc *terminalv1.TerminalConfig) *unstructured.Unstructured {
+	t.Helper()
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc)
+	if err != nil {
+		t.Fatalf("Failed to convert TerminalConfig: %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{TerminalConfigResource: "TerminalConfigList"},
+		objects...)
+}
+
+func TestBuildPod(t *testing.T) {
+	tc := newTerminalConfig()
+	pod := BuildPod(tc)
+
+	if pod.Name != "dev-terminal" || pod.Namespace != "default" {
+		t.Errorf("Unexpected pod name %s/%s", pod.Namespace, pod.Name)
+	}
+	if pod.Labels[ConfigLabel] != "dev" {
+		t.Errorf("Expected config label, got %v", pod.Labels)
+	}
+	if !metav1.IsControlledBy(pod, tc) {
+		t.Errorf("Pod is not controlled by its TerminalConfig: %v", pod.OwnerReferences)
+	}
+
+	container := pod.Spec.Containers[0]
+	if container.Image != "alpine:3.19" || !container.Stdin || !container.TTY {
+		t.Errorf("Unexpected container: %+v", container)
+	}
+	if container.Command[0] != "/bin/sh" || container.Args[0] != "-l" {
+		t.Errorf("Unexpected command %v %v", container.Command, container.Args)
+	}
+	if container.SecurityContext == nil || !*container.SecurityContext.RunAsNonRoot {
+		t.Errorf("Security context was not applied")
+	}
+	if container.Resources.Limits.Memory().String() != "256Mi" {
+		t.Errorf("Resources were not applied: %v", container.Resources)
+	}
+
+	if len(pod.Spec.Volumes) != 3 || len(container.VolumeMounts) != 3 {
+		t.Fatalf("Expected 3 volumes and mounts, got %d and %d", len(pod.Spec.Volumes), len(container.VolumeMounts))
+	}
+	if pod.Spec.Volumes[0].ConfigMap == nil || pod.Spec.Volumes[0].ConfigMap.Name != "app-config" {
+		t.Errorf("ConfigMap volume mismatch: %+v", pod.Spec.Volumes[0])
+	}
+	if pod.Spec.Volumes[1].Secret == nil || pod.Spec.Volumes[1].Secret.SecretName != "app-secrets" {
+		t.Errorf("Secret volume mismatch: %+v", pod.Spec.Volumes[1])
+	}
+	if pod.Spec.Volumes[2].PersistentVolumeClaim == nil || pod.Spec.Volumes[2].PersistentVolumeClaim.ClaimName != "shared-data" {
+		t.Errorf("Volume reference mismatch: %+v", pod.Spec.Volumes[2])
+	}
+	if !container.VolumeMounts[0].ReadOnly || container.VolumeMounts[0].MountPath != "/etc/app" {
+		t.Errorf("ConfigMap mount mismatch: %+v", container.VolumeMounts[0])
+	}
+	if container.VolumeMounts[2].SubPath != "dev" || container.VolumeMounts[2].ReadOnly {
+		t.Errorf("Volume mount mismatch: %+v", container.VolumeMounts[2])
+	}
+}
+
+func TestBuildPodDefaultsImage(t *testing.T) {
+	tc := newTerminalConfig()
+	tc.Spec.Image = ""
+	if image := BuildPod(tc).Spec.Containers[0].Image; image != DefaultImage {
+		t.Errorf("Expected default image %s, got %s", DefaultImage, image)
+	}
+}
+
+func TestControllerProvisionsAndReplacesPod(t *testing.T) {
+	tc := newTerminalConfig()
+	kubeClient := kubefake.NewSimpleClientset()
+	dynamicClient := newFakeDynamicClient(toUnstructured(t, tc))
+
+	c := New(kubeClient, dynamicClient, "default")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 1)
+
+	pod := waitForPod(t, kubeClient, func(pod *corev1.Pod) bool { return true })
+	if pod.Spec.Containers[0].Image != "alpine:3.19" {
+		t.Fatalf("Unexpected image %s", pod.Spec.Containers[0].Image)
+	}
+
+	// Changing the image replaces the pod
+	tc.Spec.Image = "alpine:3.20"
+	if _, err := dynamicClient.Resource(TerminalConfigResource).Namespace("default").
+		Update(ctx, toUnstructured(t, tc), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update TerminalConfig: %v", err)
+	}
+	waitForPod(t, kubeClient, func(pod *corev1.Pod) bool {
+		return pod.Spec.Containers[0].Image == "alpine:3.20"
+	})
+}
+
+func TestControllerIgnoresForeignPod(t *testing.T) {
+	tc := newTerminalConfig()
+	foreign := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PodName(tc.Name),
+			Namespace: "default",
+			Labels:    map[string]string{ConfigLabel: tc.Name},
+		},
+	}
+	kubeClient := kubefake.NewSimpleClientset(foreign)
+	c := New(kubeClient, newFakeDynamicClient(toUnstructured(t, tc)), "default")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.configInformerFactory.Start(ctx.Done())
+	c.podInformerFactory.Start(ctx.Done())
+	c.configInformerFactory.WaitForCacheSync(ctx.Done())
+	c.podInformerFactory.WaitForCacheSync(ctx.Done())
+
+	if err := c.sync(ctx, "default/dev"); err == nil {
+		t.Fatalf("Expected an error for a pod not controlled by the TerminalConfig")
+	}
+	pod, err := kubeClient.CoreV1().Pods("default").Get(ctx, foreign.Name, metav1.GetOptions{})
+	if err != nil || len(pod.OwnerReferences) != 0 {
+		t.Errorf("Foreign pod should be left untouched: %v %v", pod, err)
+	}
+}
+
+func TestValidateSecurityContext(t *testing.T) {
+	yes, no := true, false
+	unmasked := corev1.UnmaskedProcMount
+	allowed := []*corev1.SecurityContext{
+		nil,
+		{RunAsNonRoot: &yes, AllowPrivilegeEscalation: &no, Privileged: &no},
+		{Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}},
+		{SELinuxOptions: &corev1.SELinuxOptions{Type: "container_t", Level: "s0:c1,c2"}},
+	}
+	for _, sc := range allowed {
+		if err := ValidateSecurityContext(sc); err != nil {
+			t.Errorf("Expected %+v to be allowed, got %v", sc, err)
+		}
+	}
+	denied := map[string]*corev1.SecurityContext{
+		"privileged":           {Privileged: &yes},
+		"privilege escalation": {AllowPrivilegeEscalation: &yes},
+		"capabilities":         {Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"SYS_ADMIN"}}},
+		"proc mount":           {ProcMount: &unmasked},
+		"seccomp":              {SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}},
+		"selinux":              {SELinuxOptions: &corev1.SELinuxOptions{Type: "spc_t"}},
+		"host process":         {WindowsOptions: &corev1.WindowsSecurityContextOptions{HostProcess: &yes}},
+	}
+	for name, sc := range denied {
+		if err := ValidateSecurityContext(sc); err == nil {
+			t.Errorf("%s: expected the security context to be rejected", name)
+		}
+	}
+}
+
+func TestControllerRefusesPrivilegedConfig(t *testing.T) {
+	privileged := true
+	tc := newTerminalConfig()
+	tc.Spec.SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+	kubeClient := kubefake.NewSimpleClientset()
+	c := New(kubeClient, newFakeDynamicClient(toUnstructured(t, tc)), "default")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 1)
+
+	status := waitForPhase(t, c, terminalv1.TerminalConfigPhaseFailed)
+	if ready := findCondition(status, terminalv1.TerminalConfigReady); ready == nil || ready.Reason != ReasonInvalidSpec {
+		t.Errorf("Expected the Ready condition to report the invalid spec, got %+v", ready)
+	}
+	if pods, err := kubeClient.CoreV1().Pods("default").List(ctx, metav1.ListOptions{}); err != nil || len(pods.Items) != 0 {
+		t.Errorf("Expected no pod for a privileged config, got %v %v", pods, err)
+	}
+}
+
+// waitForPod polls until the terminal pod exists and matches cond
+func waitForPod(t *testing.T, kubeClient *kubefake.Clientset, cond func(*corev1.Pod) bool) *corev1.Pod {
+	t.Helper()
+	var found *corev1.Pod
+	err := wait.PollUntilContextTimeout(context.Background(), 20*time.Millisecond, 10*time.Second, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := kubeClient.CoreV1().Pods("default").Get(ctx, "dev-terminal", metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			found = pod
+			return cond(pod), nil
+		})
+	if err != nil {
+		t.Fatalf("Timed out waiting for terminal pod (last seen %+v): %v", found, err)
+	}
+	return found
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConfigLabel is set on every terminal pod to the name of the TerminalConfig that owns it
+	ConfigLabel = "terminal.kubernetes-web-terminal.io/config"
+
+	// SpecHashAnnotation records the hash of the pod spec derived from the TerminalConfig
+	SpecHashAnnotation = "terminal.kubernetes-web-terminal.io/spec-hash"
+
+	// ContainerName is the name of the terminal container in provisioned pods
+	ContainerName = "terminal"
+
+	// DefaultImage is used when a TerminalConfig does not specify an image
+	DefaultImage = "ubuntu:22.04"
+)
+
+// PodName returns the name of the pod provisioned for a TerminalConfig
+func PodName(configName string) string {
+	return configName + "-terminal"
+}
+
+// BuildPod returns the desired terminal pod for a TerminalConfig. The pod is
+// controlled by the config so it is garbage collected when the config is deleted.
+func BuildPod(tc *terminalv1.TerminalConfig) *corev1.Pod {
+	image := tc.Spec.Image
+	if image == "" {
+		image = DefaultImage
+	}
+
+	volumes, volumeMounts := fileMountVolumes(tc.Spec.FileMounts)
+
+	container := corev1.Container{
+		Name:         ContainerName,
+		Image:        image,
+		Command:      tc.Spec.Command,
+		Args:         tc.Spec.Args,
+		Stdin:        true,
+		TTY:          true,
+		Resources:    *tc.Spec.Resources.DeepCopy(),
+		VolumeMounts: volumeMounts,
+	}
+	if tc.Spec.SecurityContext != nil {
+		container.SecurityContext = tc.Spec.SecurityContext.DeepCopy()
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      PodName(tc.Name),
+			Namespace: tc.Namespace,
+			Labels: map[string]string{
+				ConfigLabel: tc.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(tc, terminalv1.SchemeGroupVersion.WithKind("TerminalConfig")),
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers:    []corev1.Container{container},
+			Volumes:       volumes,
+			RestartPolicy: corev1.RestartPolicyAlways,
+		},
+	}
+	pod.Annotations = map[string]string{
+		SpecHashAnnotation: specHash(&pod.Spec),
+	}
+	return pod
+}
+
+// allowedSELinuxTypes are the SELinux types the baseline Pod Security Standard allows
+var allowedSELinuxTypes = map[string]bool{
+	"":                 true,
+	"container_t":      true,
+	"container_init_t": true,
+	"container_kvm_t":  true,
+}
+
+// ValidateSecurityContext rejects security contexts that grant more than the baseline Pod
+// Security Standard: privileged mode, privilege escalation, added capabilities, unmasked
+// /proc, unconfined seccomp, custom SELinux users, roles and types, and host processes.
+// Terminal pods are created by the controller's service account, so a TerminalConfig
+// must not give its author privileges they could not get by creating a pod themselves.
+func ValidateSecurityContext(sc *corev1.SecurityContext) error {
+	if sc == nil {
+		return nil
+	}
+	var errs []error
+	if sc.Privileged != nil && *sc.Privileged {
+		errs = append(errs, fmt.Errorf("privileged containers are not allowed"))
+	}
+	if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation {
+		errs = append(errs, fmt.Errorf("allowPrivilegeEscalation is not allowed"))
+	}
+	if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 {
+		added := make([]string, len(sc.Capabilities.Add))
+		for i, capability := range sc.Capabilities.Add {
+			added[i] = string(capability)
+		}
+		errs = append(errs, fmt.Errorf("adding capabilities (%s) is not allowed", strings.Join(added, ", ")))
+	}
+	if sc.ProcMount != nil && *sc.ProcMount == corev1.UnmaskedProcMount {
+		errs = append(errs, fmt.Errorf("an unmasked /proc is not allowed"))
+	}
+	if sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		errs = append(errs, fmt.Errorf("an unconfined seccomp profile is not allowed"))
+	}
+	if se := sc.SELinuxOptions; se != nil && (se.User != "" || se.Role != "" || !allowedSELinuxTypes[se.Type]) {
+		errs = append(errs, fmt.Errorf("custom SELinux users, roles and types are not allowed"))
+	}
+	if sc.WindowsOptions != nil && sc.WindowsOptions.HostProcess != nil && *sc.WindowsOptions.HostProcess {
+		errs = append(errs, fmt.Errorf("host processes are not allowed"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid securityContext: %w", err)
+	}
+	return nil
+}
+
+// fileMountVolumes translates FileMounts into pod volumes and the matching container mounts.
+// ConfigMap and Secret references become configMap and secret volumes; a VolumeRef names an
+// existing PersistentVolumeClaim.
+func fileMountVolumes(mounts []terminalv1.FileMount) ([]corev1.Volume, []corev1.VolumeMount) {
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+
+	for _, mount := range mounts {
+		volume := corev1.Volume{Name: mount.Name}
+		volumeMount := corev1.VolumeMount{
+			Name:      mount.Name,
+			MountPath: mount.MountPath,
+			ReadOnly:  mount.ReadOnly,
+		}
+
+		switch {
+		case mount.ConfigMapRef != nil:
+			volume.ConfigMap = mount.ConfigMapRef.DeepCopy()
+		case mount.SecretRef != nil:
+			volume.Secret = mount.SecretRef.DeepCopy()
+		case mount.VolumeRef != nil:
+			volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: mount.VolumeRef.Name,
+				ReadOnly:  mount.ReadOnly,
+			}
+			volumeMount.SubPath = mount.VolumeRef.SubPath
+		default:
+			// A mount without a source cannot be satisfied; skip it rather than
+			// producing a pod the API server will reject
+			continue
+		}
+
+		volumes = append(volumes, volume)
+		volumeMounts = append(volumeMounts, volumeMount)
+	}
+
+	return volumes, volumeMounts
+}
+
+// specHash returns a stable hash of a pod spec used to detect configuration changes
+func specHash(spec *corev1.PodSpec) string {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return fmt.Sprintf("%x", hasher.Sum32())
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition reasons reported on TerminalConfigs
+const (
+	ReasonPodCreating     = "PodCreating"
+	ReasonPodConflict     = "PodConflict"
+	ReasonInvalidSpec     = "InvalidSpec"
+	ReasonPodRunning      = "PodRunning"
+	ReasonPodNotReady     = "PodNotReady"
+	ReasonPodSucceeded    = "PodSucceeded"
+	ReasonPodFailed       = "PodFailed"
+	ReasonContainerExited = "ContainerExited"
+	ReasonMountsReady     = "MountsReady"
+	ReasonNoFileMounts    = "NoFileMounts"
+	ReasonMountsPending   = "MountsPending"
+	ReasonSourceNotFound  = "SourceNotFound"
+	ReasonConfigError     = "CreateContainerConfigError"
+)
+
+// imageErrorReasons are container waiting reasons that mean the image cannot be pulled
+var imageErrorReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// IsImagePullError reports whether a container waiting reason means its image cannot be pulled
+func IsImagePullError(reason string) bool {
+	return imageErrorReasons[reason]
+}
+
+// mountError describes a FileMount whose source object does not exist
+type mountError struct {
+	mount  string
+	kind   string
+	source string
+}
+
+func (e *mountError) Error() string {
+	return fmt.Sprintf("%s %q for file mount %q not found", e.kind, e.source, e.mount)
+}
+
+// checkFileMounts verifies that every non-optional ConfigMap, Secret and PersistentVolumeClaim
+// referenced by the TerminalConfig exists
+func (c *Controller) checkFileMounts(ctx context.Context, tc *terminalv1.TerminalConfig) (*mountError, error) {
+	for _, mount := range tc.Spec.FileMounts {
+		var err error
+		var kind, source string
+		switch {
+		case mount.ConfigMapRef != nil:
+			if mount.ConfigMapRef.Optional != nil && *mount.ConfigMapRef.Optional {
+				continue
+			}
+			kind, source = "ConfigMap", mount.ConfigMapRef.Name
+			_, err = c.kubeClient.CoreV1().ConfigMaps(tc.Namespace).Get(ctx, source, metav1.GetOptions{})
+		case mount.SecretRef != nil:
+			if mount.SecretRef.Optional != nil && *mount.SecretRef.Optional {
+				continue
+			}
+			kind, source = "Secret", mount.SecretRef.SecretName
+			_, err = c.kubeClient.CoreV1().Secrets(tc.Namespace).Get(ctx, source, metav1.GetOptions{})
+		case mount.VolumeRef != nil:
+			kind, source = "PersistentVolumeClaim", mount.VolumeRef.Name
+			_, err = c.kubeClient.CoreV1().PersistentVolumeClaims(tc.Namespace).Get(ctx, source, metav1.GetOptions{})
+		default:
+			continue
+		}
+
+		if apierrors.IsNotFound(err) {
+			return &mountError{mount: mount.Name, kind: kind, source: source}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// computeStatus derives the TerminalConfig status from its pod and file mount sources.
+// pod is nil while the pod is being created or replaced.
+func computeStatus(tc *terminalv1.TerminalConfig, pod *corev1.Pod, mountErr *mountError, now metav1.Time) terminalv1.TerminalConfigStatus {
+	status := copyStatus(tc)
+	if pod != nil && !pod.CreationTimestamp.IsZero() {
+		createdAt := pod.CreationTimestamp
+		status.CreatedAt = &createdAt
+	}
+
+	filesMounted := terminalv1.TerminalConfigCondition{
+		Type:    terminalv1.TerminalConfigFilesMounted,
+		Status:  corev1.ConditionUnknown,
+		Reason:  ReasonMountsPending,
+		Message: "Waiting for the terminal pod to start",
+	}
+	ready := terminalv1.TerminalConfigCondition{
+		Type:   terminalv1.TerminalConfigReady,
+		Status: corev1.ConditionFalse,
+	}
+
+	var container *corev1.ContainerStatus
+	if pod != nil {
+		for i := range pod.Status.ContainerStatuses {
+			if pod.Status.ContainerStatuses[i].Name == ContainerName {
+				container = &pod.Status.ContainerStatuses[i]
+			}
+		}
+	}
+
+	switch {
+	case mountErr != nil:
+		status.Phase = terminalv1.TerminalConfigPhaseFailed
+		status.Message = mountErr.Error()
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionFalse, ReasonSourceNotFound, mountErr.Error()
+		ready.Reason, ready.Message = ReasonSourceNotFound, mountErr.Error()
+
+	case pod == nil:
+		status.Phase = terminalv1.TerminalConfigPhasePending
+		status.Message = "Terminal pod is being created"
+		ready.Reason, ready.Message = ReasonPodCreating, status.Message
+
+	case pod.Status.Phase == corev1.PodSucceeded:
+		status.Phase = terminalv1.TerminalConfigPhaseTerminated
+		status.Message = "Terminal pod completed"
+		ready.Reason, ready.Message = ReasonPodSucceeded, status.Message
+
+	case pod.Status.Phase == corev1.PodFailed:
+		status.Phase = terminalv1.TerminalConfigPhaseFailed
+		status.Message = podMessage(pod, "Terminal pod failed")
+		ready.Reason, ready.Message = ReasonPodFailed, status.Message
+
+	case container != nil && container.State.Waiting != nil && imageErrorReasons[container.State.Waiting.Reason]:
+		waiting := container.State.Waiting
+		status.Phase = terminalv1.TerminalConfigPhaseFailed
+		status.Message = fmt.Sprintf("Failed to pull image %s: %s", container.Image, waiting.Message)
+		ready.Reason, ready.Message = waiting.Reason, status.Message
+
+	case container != nil && container.State.Waiting != nil && container.State.Waiting.Reason == ReasonConfigError:
+		waiting := container.State.Waiting
+		status.Phase = terminalv1.TerminalConfigPhaseFailed
+		status.Message = waiting.Message
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionFalse, waiting.Reason, waiting.Message
+		ready.Reason, ready.Message = waiting.Reason, waiting.Message
+
+	case container != nil && container.State.Waiting != nil && container.State.Waiting.Reason == "CrashLoopBackOff":
+		status.Phase = terminalv1.TerminalConfigPhaseFailed
+		status.Message = "Terminal container is crash looping"
+		if last := container.LastTerminationState.Terminated; last != nil {
+			status.Message = fmt.Sprintf("Terminal container is crash looping, last exit code %d (%s)", last.ExitCode, last.Reason)
+		}
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionTrue, ReasonMountsReady, "File mounts are available"
+		ready.Reason, ready.Message = "CrashLoopBackOff", status.Message
+
+	case container != nil && container.State.Terminated != nil:
+		terminated := container.State.Terminated
+		status.Phase = terminalv1.TerminalConfigPhaseTerminated
+		if terminated.ExitCode != 0 {
+			status.Phase = terminalv1.TerminalConfigPhaseFailed
+		}
+		status.Message = fmt.Sprintf("Terminal container exited with code %d", terminated.ExitCode)
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionTrue, ReasonMountsReady, "File mounts are available"
+		ready.Reason, ready.Message = ReasonContainerExited, status.Message
+
+	case container != nil && container.State.Running != nil:
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionTrue, ReasonMountsReady, "File mounts are available"
+		if container.Ready {
+			status.Phase = terminalv1.TerminalConfigPhaseRunning
+			status.Message = "Terminal is ready"
+			ready.Status, ready.Reason, ready.Message = corev1.ConditionTrue, ReasonPodRunning, status.Message
+		} else {
+			status.Phase = terminalv1.TerminalConfigPhasePending
+			status.Message = "Terminal container is not ready"
+			ready.Reason, ready.Message = ReasonPodNotReady, status.Message
+		}
+
+	default:
+		status.Phase = terminalv1.TerminalConfigPhasePending
+		status.Message = podMessage(pod, "Waiting for the terminal pod to start")
+		ready.Reason, ready.Message = ReasonPodNotReady, status.Message
+	}
+
+	if len(tc.Spec.FileMounts) == 0 && mountErr == nil {
+		filesMounted.Status, filesMounted.Reason, filesMounted.Message = corev1.ConditionTrue, ReasonNoFileMounts, "No file mounts configured"
+	}
+
+	setCondition(&status.Conditions, ready, now)
+	setCondition(&status.Conditions, filesMounted, now)
+	return status
+}
+
+// conflictStatus reports a pod name collision with a pod the config does not control
+func conflictStatus(tc *terminalv1.TerminalConfig, err error, now metav1.Time) terminalv1.TerminalConfigStatus {
+	status := copyStatus(tc)
+	status.Phase = terminalv1.TerminalConfigPhaseFailed
+	status.Message = err.Error()
+	setCondition(&status.Conditions, terminalv1.TerminalConfigCondition{
+		Type:    terminalv1.TerminalConfigReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonPodConflict,
+		Message: err.Error(),
+	}, now)
+	return status
+}
+
+// invalidStatus reports a TerminalConfig the controller refuses to create a pod for
+func invalidStatus(tc *terminalv1.TerminalConfig, err error, now metav1.Time) terminalv1.TerminalConfigStatus {
+	status := copyStatus(tc)
+	status.Phase = terminalv1.TerminalConfigPhaseFailed
+	status.Message = err.Error()
+	setCondition(&status.Conditions, terminalv1.TerminalConfigCondition{
+		Type:    terminalv1.TerminalConfigReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonInvalidSpec,
+		Message: err.Error(),
+	}, now)
+	return status
+}
+
+// copyStatus returns a deep copy of the TerminalConfig's current status
+func copyStatus(tc *terminalv1.TerminalConfig) terminalv1.TerminalConfigStatus {
+	return tc.DeepCopyObject().(*terminalv1.TerminalConfig).Status
+}
+
+// podMessage returns the pod's status message or fallback when it has none
+func podMessage(pod *corev1.Pod, fallback string) string {
+	if pod.Status.Message != "" {
+		return pod.Status.Message
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue && condition.Message != "" {
+			return condition.Message
+		}
+	}
+	return fallback
+}
+
+// setCondition adds or updates a condition. LastTransitionTime only changes when the
+// condition's status changes, so it records when the state actually flipped.
+func setCondition(conditions *[]terminalv1.TerminalConfigCondition, condition terminalv1.TerminalConfigCondition, now metav1.Time) {
+	for i := range *conditions {
+		existing := &(*conditions)[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status != condition.Status {
+			existing.Status = condition.Status
+			existing.LastTransitionTime = now
+		}
+		existing.Reason = condition.Reason
+		existing.Message = condition.Message
+		return
+	}
+	condition.LastTransitionTime = now
+	*conditions = append(*conditions, condition)
+}
+
+// updateStatus writes status through the /status subresource if it changed
+func (c *Controller) updateStatus(ctx context.Context, tc *terminalv1.TerminalConfig, status terminalv1.TerminalConfigStatus) error {
+	if equality.Semantic.DeepEqual(tc.Status, status) {
+		return nil
+	}
+	updated := tc.DeepCopyObject().(*terminalv1.TerminalConfig)
+	updated.Status = status
+	_, err := c.terminalClient.UpdateStatus(ctx, updated)
+	return err
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func podWithContainer(phase corev1.PodPhase, status corev1.ContainerStatus) *corev1.Pod {
+	status.Name = ContainerName
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "dev-terminal",
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		Status: corev1.PodStatus{
+			Phase:             phase,
+			ContainerStatuses: []corev1.ContainerStatus{status},
+		},
+	}
+}
+
+func findCondition(status terminalv1.TerminalConfigStatus, conditionType terminalv1.TerminalConfigConditionType) *terminalv1.TerminalConfigCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestComputeStatus(t *testing.T) {
+	now := metav1.Now()
+	testCases := []struct {
+		name         string
+		pod          *corev1.Pod
+		mountErr     *mountError
+		phase        terminalv1.TerminalConfigPhase
+		ready        corev1.ConditionStatus
+		readyReason  string
+		filesMounted corev1.ConditionStatus
+	}{
+		{
+			name:         "pod being created",
+			phase:        terminalv1.TerminalConfigPhasePending,
+			ready:        corev1.ConditionFalse,
+			readyReason:  ReasonPodCreating,
+			filesMounted: corev1.ConditionUnknown,
+		},
+		{
+			name: "running and ready",
+			pod: podWithContainer(corev1.PodRunning, corev1.ContainerStatus{
+				Ready: true,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}),
+			phase:        terminalv1.TerminalConfigPhaseRunning,
+			ready:        corev1.ConditionTrue,
+			readyReason:  ReasonPodRunning,
+			filesMounted: corev1.ConditionTrue,
+		},
+		{
+			name: "image pull error",
+			pod: podWithContainer(corev1.PodPending, corev1.ContainerStatus{
+				Image: "does-not-exist:latest",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			}),
+			phase:        terminalv1.TerminalConfigPhaseFailed,
+			ready:        corev1.ConditionFalse,
+			readyReason:  "ImagePullBackOff",
+			filesMounted: corev1.ConditionUnknown,
+		},
+		{
+			name: "missing secret key",
+			pod: podWithContainer(corev1.PodPending, corev1.ContainerStatus{
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+					Reason:  ReasonConfigError,
+					Message: `couldn't find key token in Secret default/app-secrets`,
+				}},
+			}),
+			phase:        terminalv1.TerminalConfigPhaseFailed,
+			ready:        corev1.ConditionFalse,
+			readyReason:  ReasonConfigError,
+			filesMounted: corev1.ConditionFalse,
+		},
+		{
+			name:         "missing config map",
+			pod:          podWithContainer(corev1.PodPending, corev1.ContainerStatus{}),
+			mountErr:     &mountError{mount: "config", kind: "ConfigMap", source: "app-config"},
+			phase:        terminalv1.TerminalConfigPhaseFailed,
+			ready:        corev1.ConditionFalse,
+			readyReason:  ReasonSourceNotFound,
+			filesMounted: corev1.ConditionFalse,
+		},
+		{
+			name: "crash looping",
+			pod: podWithContainer(corev1.PodRunning, corev1.ContainerStatus{
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 127, Reason: "Error"}},
+			}),
+			phase:        terminalv1.TerminalConfigPhaseFailed,
+			ready:        corev1.ConditionFalse,
+			readyReason:  "CrashLoopBackOff",
+			filesMounted: corev1.ConditionTrue,
+		},
+		{
+			name:         "pod succeeded",
+			pod:          podWithContainer(corev1.PodSucceeded, corev1.ContainerStatus{}),
+			phase:        terminalv1.TerminalConfigPhaseTerminated,
+			ready:        corev1.ConditionFalse,
+			readyReason:  ReasonPodSucceeded,
+			filesMounted: corev1.ConditionUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newTerminalConfig()
+			status := computeStatus(config, tc.pod, tc.mountErr, now)
+
+			if status.Phase != tc.phase {
+				t.Errorf("Phase mismatch: got %s, want %s (%s)", status.Phase, tc.phase, status.Message)
+			}
+			ready := findCondition(status, terminalv1.TerminalConfigReady)
+			if ready == nil || ready.Status != tc.ready || ready.Reason != tc.readyReason {
+				t.Errorf("Ready condition mismatch: got %+v, want %s/%s", ready, tc.ready, tc.readyReason)
+			}
+			filesMounted := findCondition(status, terminalv1.TerminalConfigFilesMounted)
+			if filesMounted == nil || filesMounted.Status != tc.filesMounted {
+				t.Errorf("FilesMounted condition mismatch: got %+v, want %s", filesMounted, tc.filesMounted)
+			}
+			if tc.pod != nil && (status.CreatedAt == nil || !status.CreatedAt.Equal(&tc.pod.CreationTimestamp)) {
+				t.Errorf("CreatedAt should match the pod creation time, got %v", status.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestSetConditionTransitionTime(t *testing.T) {
+	first := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	second := metav1.NewTime(first.Add(time.Minute))
+	third := metav1.NewTime(first.Add(2 * time.Minute))
+
+	var conditions []terminalv1.TerminalConfigCondition
+	setCondition(&conditions, terminalv1.TerminalConfigCondition{
+		Type: terminalv1.TerminalConfigReady, Status: corev1.ConditionFalse, Reason: ReasonPodCreating,
+	}, first)
+	setCondition(&conditions, terminalv1.TerminalConfigCondition{
+		Type: terminalv1.TerminalConfigReady, Status: corev1.ConditionFalse, Reason: ReasonPodNotReady,
+	}, second)
+
+	if len(conditions) != 1 {
+		t.Fatalf("Expected a single condition, got %d", len(conditions))
+	}
+	if !conditions[0].LastTransitionTime.Equal(&first) || conditions[0].Reason != ReasonPodNotReady {
+		t.Errorf("Reason change without status change must keep the transition time: %+v", conditions[0])
+	}
+
+	setCondition(&conditions, terminalv1.TerminalConfigCondition{
+		Type: terminalv1.TerminalConfigReady, Status: corev1.ConditionTrue, Reason: ReasonPodRunning,
+	}, third)
+	if !conditions[0].LastTransitionTime.Equal(&third) {
+		t.Errorf("Status change must update the transition time: %+v", conditions[0])
+	}
+}
+
+func TestControllerReportsPodStatus(t *testing.T) {
+	tc := newTerminalConfig()
+	tc.Spec.FileMounts = tc.Spec.FileMounts[:1]
+	kubeClient := kubefake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+	})
+	dynamicClient := newFakeDynamicClient(toUnstructured(t, tc))
+
+	c := New(kubeClient, dynamicClient, "default")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 1)
+
+	waitForPhase(t, c, terminalv1.TerminalConfigPhasePending)
+
+	// Simulate the kubelet starting the container
+	pod := waitForPod(t, kubeClient, func(*corev1.Pod) bool { return true })
+	pod.Status = podWithContainer(corev1.PodRunning, corev1.ContainerStatus{
+		Ready: true,
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}).Status
+	if _, err := kubeClient.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update pod status: %v", err)
+	}
+
+	status := waitForPhase(t, c, terminalv1.TerminalConfigPhaseRunning)
+	if ready := findCondition(status, terminalv1.TerminalConfigReady); ready == nil || ready.Status != corev1.ConditionTrue {
+		t.Errorf("Expected Ready condition to be true, got %+v", ready)
+	}
+	if filesMounted := findCondition(status, terminalv1.TerminalConfigFilesMounted); filesMounted == nil || filesMounted.Status != corev1.ConditionTrue {
+		t.Errorf("Expected FilesMounted condition to be true, got %+v", filesMounted)
+	}
+}
+
+// waitForPhase polls the TerminalConfig until its status reaches phase
+func waitForPhase(t *testing.T, c *Controller, phase terminalv1.TerminalConfigPhase) terminalv1.TerminalConfigStatus {
+	t.Helper()
+	var status terminalv1.TerminalConfigStatus
+	err := wait.PollUntilContextTimeout(context.Background(), 20*time.Millisecond, 10*time.Second, true,
+		func(ctx context.Context) (bool, error) {
+			tc, err := c.terminalClient.Get(ctx, "dev")
+			if err != nil {
+				return false, nil
+			}
+			status = tc.Status
+			return status.Phase == phase, nil
+		})
+	if err != nil {
+		t.Fatalf("Timed out waiting for phase %s (last status %+v): %v", phase, status, err)
+	}
+	return status
+}
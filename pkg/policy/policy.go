@@ -0,0 +1,116 @@
+// Package policy decides which commands users may run in terminals and scripts.
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+)
+
+// Policy is a list of deny rules. A nil Policy allows every command.
+type Policy struct {
+	rules []rule
+}
+
+type rule struct {
+	pattern *regexp.Regexp
+	message string
+}
+
+// New compiles the deny rules. It returns nil if there are none.
+func New(rules []terminalv1.CommandRule) (*Policy, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	p := &Policy{}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %q: %v", r.Pattern, err)
+		}
+		p.rules = append(p.rules, rule{pattern: re, message: r.Message})
+	}
+	return p, nil
+}
+
+// ForConfig compiles the command policy of a TerminalConfig
+func ForConfig(tc *terminalv1.TerminalConfig) (*Policy, error) {
+	if tc == nil || tc.Spec.CommandPolicy == nil {
+		return nil, nil
+	}
+	return New(tc.Spec.CommandPolicy.Deny)
+}
+
+// Combine returns a policy that denies what any of the policies denies
+func Combine(policies ...*Policy) *Policy {
+	var combined *Policy
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+		if combined == nil {
+			combined = &Policy{}
+		}
+		combined.rules = append(combined.rules, p.rules...)
+	}
+	return combined
+}
+
+// DeniedError is returned for commands that match a deny rule
+type DeniedError struct {
+	Command string
+	Pattern string
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Message != "" {
+		return "command blocked by policy: " + e.Message
+	}
+	return fmt.Sprintf("command blocked by policy: matches %q", e.Pattern)
+}
+
+// Check returns a *DeniedError if the command line matches a deny rule. Patterns are
+// searched for in the line as typed and with quotes, backslashes and repeated whitespace
+// removed, so `r'm' -rf  /` is caught by a rule for `rm -rf /`.
+func (p *Policy) Check(command string) error {
+	if p == nil {
+		return nil
+	}
+	normalized := normalize(command)
+	for _, r := range p.rules {
+		if r.pattern.MatchString(command) || r.pattern.MatchString(normalized) {
+			return &DeniedError{Command: command, Pattern: r.pattern.String(), Message: r.message}
+		}
+	}
+	return nil
+}
+
+// CheckScript checks every line of a script, joining lines continued with a backslash
+func (p *Policy) CheckScript(script string) error {
+	if p == nil {
+		return nil
+	}
+	script = strings.ReplaceAll(script, "\\\r\n", "")
+	script = strings.ReplaceAll(script, "\\\n", "")
+	for _, line := range strings.Split(script, "\n") {
+		if err := p.Check(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalize strips shell quoting and collapses whitespace
+func normalize(command string) string {
+	command = strings.Map(func(r rune) rune {
+		switch r {
+		case '\'', '"', '\\':
+			return -1
+		}
+		return r
+	}, command)
+	return strings.Join(strings.Fields(command), " ")
+}
@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := New([]terminalv1.CommandRule{
+		{Pattern: `rm\s+-\w*r\w*\s+/(\s|$)`, Message: "Removing / is not allowed"},
+		{Pattern: `kubectl\s+delete\s+(ns|namespaces?)\b`},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	tests := map[string]bool{
+		"ls -la":                                 false,
+		"rm -rf /tmp/cache":                      false,
+		"rm -rf /":                               true,
+		"cd / && rm  -fr / --no-preserve":        true,
+		`r'm' -rf "/"`:                           true,
+		"kubectl delete ns prod":                 true,
+		"kubectl delete pod web-0":               false,
+		"echo hi; kubectl delete namespace prod": true,
+	}
+	for command, denied := range tests {
+		err := p.Check(command)
+		if (err != nil) != denied {
+			t.Errorf("Check(%q) = %v, want denied %v", command, err, denied)
+		}
+	}
+
+	var denied *DeniedError
+	if err := p.Check("rm -rf /"); !errors.As(err, &denied) || denied.Message != "Removing / is not allowed" || err.Error() != "command blocked by policy: Removing / is not allowed" {
+		t.Errorf("Expected the rule's message, got %v", err)
+	}
+	if err := p.Check("kubectl delete ns x"); err == nil || err.Error() != `command blocked by policy: matches "kubectl\\s+delete\\s+(ns|namespaces?)\\b"` {
+		t.Errorf("Expected the pattern without a message, got %v", err)
+	}
+
+	if _, err := New([]terminalv1.CommandRule{{Pattern: "("}}); err == nil {
+		t.Errorf("Expected invalid patterns to be rejected")
+	}
+	var none *Policy
+	if none.Check("rm -rf /") != nil || none.CheckScript("rm -rf /") != nil {
+		t.Errorf("A nil policy must allow everything")
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	p, err := New([]terminalv1.CommandRule{{Pattern: `rm\s+-rf\s+/(\s|$)`}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := p.CheckScript("set -e\necho cleaning\nrm -rf /tmp/x\n"); err != nil {
+		t.Errorf("Expected the script to be allowed, got %v", err)
+	}
+	if err := p.CheckScript("set -e\nrm -rf \\\n  /\n"); err == nil {
+		t.Errorf("Expected a continued line to be checked as one")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	cluster, _ := New([]terminalv1.CommandRule{{Pattern: "shutdown"}})
+	config, _ := ForConfig(&terminalv1.TerminalConfig{Spec: terminalv1.TerminalConfigSpec{
+		CommandPolicy: &terminalv1.CommandPolicy{Deny: []terminalv1.CommandRule{{Pattern: "reboot"}}},
+	}})
+	combined := Combine(cluster, nil, config)
+	if combined.Check("shutdown now") == nil || combined.Check("reboot") == nil || combined.Check("uptime") != nil {
+		t.Errorf("Expected the rules of both policies")
+	}
+	if Combine(nil, nil) != nil {
+		t.Errorf("Expected no policy without rules")
+	}
+	if p, err := ForConfig(&terminalv1.TerminalConfig{}); p != nil || err != nil {
+		t.Errorf("Expected no policy for a config without one, got %v, %v", p, err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package recording records terminal sessions in the asciicast v2 format
+// (https://docs.asciinema.org/manual/asciicast/v2/) and stores the recordings.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Event codes of asciicast v2
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a single line of an asciicast v2 file after the header
+type Event struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// MarshalJSON encodes the event as the [time, code, data] array used by asciicast
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Code, e.Data})
+}
+
+// UnmarshalJSON decodes a [time, code, data] array
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("asciicast event has %d fields, expected 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Code); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Reader decodes an asciicast v2 file one event at a time, so recordings of any length
+// can be replayed without loading them into memory
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	events int
+}
+
+// NewReader reads the header of an asciicast v2 file
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	line, err := reader.r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &reader.Header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %v", err)
+	}
+	if reader.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", reader.Header.Version)
+	}
+	return reader, nil
+}
+
+// Next returns the next event, or io.EOF after the last one. A last line cut short, as
+// left by a session whose recording was interrupted, is treated as the end of the file.
+func (r *Reader) Next() (Event, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return Event{}, err
+		}
+		truncated := err == io.EOF
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if truncated {
+				return Event{}, io.EOF
+			}
+			continue
+		}
+		if truncated && !json.Valid(line) {
+			return Event{}, io.EOF
+		}
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return Event{}, fmt.Errorf("invalid asciicast event %d: %v", r.events+1, err)
+		}
+		r.events++
+		return event, nil
+	}
+}
+
+// Decode reads a whole asciicast v2 file. Events are returned in file order, which is also
+// time order for files written by Recorder.
+func Decode(r io.Reader) (*Header, []Event, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var events []Event
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return &reader.Header, events, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+}
+
+// Recorder writes terminal output, and optionally input, as timestamped asciicast events.
+// It is safe for concurrent use. After a write error further events are dropped and the
+// error is returned by Close.
+type Recorder struct {
+	mu          sync.Mutex
+	w           io.WriteCloser
+	encoder     *json.Encoder
+	start       time.Time
+	now         func() time.Time
+	recordInput bool
+	// partial holds the incomplete UTF-8 sequence at the end of the last chunk of each stream
+	partial map[string][]byte
+	err     error
+	closed  bool
+}
+
+// NewRecorder writes the header to w and returns a recorder for the session. The header's
+// version and timestamp are filled in.
+func NewRecorder(w io.WriteCloser, header Header, recordInput bool) (*Recorder, error) {
+	r := &Recorder{
+		w:           w,
+		encoder:     json.NewEncoder(w),
+		now:         time.Now,
+		recordInput: recordInput,
+		partial:     make(map[string][]byte),
+	}
+	r.start = r.now()
+	header.Version = 2
+	header.Timestamp = r.start.Unix()
+	if err := r.encoder.Encode(header); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Output records bytes written to the terminal. Like all Recorder methods it does nothing
+// on a nil recorder, so sessions that are not recorded need no checks.
+func (r *Recorder) Output(p []byte) {
+	if r != nil {
+		r.record(EventOutput, p)
+	}
+}
+
+// Input records bytes typed into the terminal, if input recording is enabled
+func (r *Recorder) Input(p []byte) {
+	if r != nil && r.recordInput {
+		r.record(EventInput, p)
+	}
+}
+
+// Resize records a change of the terminal size
+func (r *Recorder) Resize(cols, rows uint16) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(EventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *Recorder) record(code string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial[code], p...)
+	complete, rest := splitUTF8(data)
+	r.partial[code] = append([]byte(nil), rest...)
+	if len(complete) > 0 {
+		r.write(code, string(complete))
+	}
+}
+
+// write encodes an event. The caller holds r.mu.
+func (r *Recorder) write(code, data string) {
+	if r.err != nil || r.closed {
+		return
+	}
+	elapsed := r.now().Sub(r.start).Seconds()
+	event := Event{Time: math.Round(elapsed*1e6) / 1e6, Code: code, Data: data}
+	r.err = r.encoder.Encode(event)
+}
+
+// Close flushes incomplete characters and closes the underlying writer
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.err
+	}
+	for _, code := range []string{EventOutput, EventInput} {
+		if len(r.partial[code]) > 0 {
+			r.write(code, string(r.partial[code]))
+		}
+	}
+	r.closed = true
+	if err := r.w.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// splitUTF8 splits p before a multi-byte character that continues in the next chunk, so
+// characters split across writes are not recorded as invalid UTF-8
+func splitUTF8(p []byte) (complete, rest []byte) {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			return p[:i], p[i:]
+		}
+		break
+	}
+	return p, nil
+}
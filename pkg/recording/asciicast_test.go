@@ -0,0 +1,138 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	var buf bufferCloser
+	start := time.Unix(1700000000, 0)
+	recorder, err := NewRecorder(&buf, Header{Width: 80, Height: 24, Title: "dev/web-0"}, false)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	clock := start
+	recorder.start = start
+	recorder.now = func() time.Time { return clock }
+
+	clock = start.Add(1500 * time.Millisecond)
+	recorder.Output([]byte("$ "))
+	recorder.Input([]byte("ls\r"))
+	clock = start.Add(2 * time.Second)
+	recorder.Resize(120, 40)
+	// "é" split across two writes
+	recorder.Output([]byte("caf\xc3"))
+	recorder.Output([]byte("\xa9\r\n"))
+	if err := recorder.Close(); err != nil || !buf.closed {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var header Header
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("Invalid header %q: %v", lines[0], err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Title != "dev/web-0" || header.Timestamp == 0 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	want := []string{
+		`[1.5,"o","$ "]`,
+		`[2,"r","120x40"]`,
+		`[2,"o","caf"]`,
+		`[2,"o","é\r\n"]`,
+	}
+	if got := lines[1:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// Events after Close are dropped
+	recorder.Output([]byte("late"))
+	if strings.Contains(buf.String(), "late") {
+		t.Errorf("Recorded output after Close")
+	}
+}
+
+func TestRecorderInput(t *testing.T) {
+	var buf bufferCloser
+	recorder, err := NewRecorder(&buf, Header{Width: 80, Height: 24}, true)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	recorder.Input([]byte("whoami\r"))
+	recorder.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var event Event
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &event) != nil {
+		t.Fatalf("Expected one event, got %q", buf.String())
+	}
+	if event.Code != EventInput || event.Data != "whoami\r" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var recorder *Recorder
+	recorder.Output([]byte("x"))
+	recorder.Input([]byte("x"))
+	recorder.Resize(80, 24)
+	if err := recorder.Close(); err != nil {
+		t.Errorf("Close of a nil recorder failed: %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	header, events, err := Decode(strings.NewReader(`{"version":2,"width":80,"height":24}
+[0.5,"o","$ "]
+[1.25,"r","120x40"]
+`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if header.Width != 80 || len(events) != 2 || events[1] != (Event{Time: 1.25, Code: EventResize, Data: "120x40"}) {
+		t.Errorf("Unexpected recording %+v %+v", header, events)
+	}
+
+	for _, invalid := range []string{
+		`{"version":1,"width":80,"height":24}`,
+		`{"version":2,"width":80,"height":24}` + "\n" + `[0.5,"o"]`,
+		`not json`,
+	} {
+		if _, _, err := Decode(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestDecodeTruncatedRecording(t *testing.T) {
+	_, events, err := Decode(strings.NewReader(`{"version":2,"width":80,"height":24}
+[0.5,"o","$ "]
+[1.25,"o","partial out`))
+	if err != nil {
+		t.Fatalf("Expected the truncated last line to be skipped, got %v", err)
+	}
+	if len(events) != 1 || events[0].Data != "$ " {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	if _, _, err := Decode(strings.NewReader(`{"version":2,"width":80,"height":24}
+[0.5,"o"
+[1.25,"o","$ "]
+`)); err == nil {
+		t.Error("Expected an invalid line before the end to be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for recordings that do not exist
+var ErrNotFound = errors.New("recording not found")
+
+// idPattern restricts recording IDs, which are used as file and object names
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Metadata describes a recorded session
+type Metadata struct {
+	ID        string     `json:"id"`
+	User      string     `json:"user"`
+	Namespace string     `json:"namespace"`
+	Pod       string     `json:"pod"`
+	Container string     `json:"container,omitempty"`
+	Config    string     `json:"config,omitempty"`
+	Input     bool       `json:"input"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	Size      int64      `json:"size"`
+}
+
+// Filter selects recordings. Empty fields match everything; Since and Until select the
+// sessions that were running at some point in between.
+type Filter struct {
+	User      string
+	Namespace string
+	Pod       string
+	Config    string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Matches reports whether a recording is selected by the filter
+func (f Filter) Matches(meta Metadata) bool {
+	switch {
+	case f.User != "" && meta.User != f.User,
+		f.Namespace != "" && meta.Namespace != f.Namespace,
+		f.Pod != "" && meta.Pod != f.Pod,
+		f.Config != "" && meta.Config != f.Config:
+		return false
+	case !f.Since.IsZero() && meta.EndedAt != nil && meta.EndedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && meta.StartedAt.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Storage keeps recordings. Local disk is the only implementation so far; the interface
+// is small enough for object stores such as S3.
+type Storage interface {
+	// Create starts a recording. Its metadata is updated with the end time and size when
+	// the returned writer is closed.
+	Create(ctx context.Context, meta Metadata) (io.WriteCloser, error)
+	// Get returns the metadata of a recording
+	Get(ctx context.Context, id string) (*Metadata, error)
+	// List returns the metadata of all recordings, most recent first
+	List(ctx context.Context) ([]Metadata, error)
+	// Open returns the asciicast content of a recording
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+}
+
+// LocalStorage stores each recording as <id>.cast with its metadata in <id>.json
+type LocalStorage struct {
+	dir string
+	// mu serializes metadata updates
+	mu sync.Mutex
+}
+
+// NewLocalStorage creates the directory if needed and returns a storage backed by it
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %v", err)
+	}
+	return &LocalStorage{dir: dir}, nil
+}
+
+// Create implements Storage
+func (s *LocalStorage) Create(ctx context.Context, meta Metadata) (io.WriteCloser, error) {
+	if !idPattern.MatchString(meta.ID) {
+		return nil, fmt.Errorf("invalid recording ID %q", meta.ID)
+	}
+	file, err := os.OpenFile(s.path(meta.ID, ".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeMetadata(meta); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &localWriter{file: file, storage: s, meta: meta}, nil
+}
+
+// Get implements Storage
+func (s *LocalStorage) Get(ctx context.Context, id string) (*Metadata, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata for recording %s: %v", id, err)
+	}
+	return &meta, nil
+}
+
+// List implements Storage
+func (s *LocalStorage) List(ctx context.Context) ([]Metadata, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	recordings := []Metadata{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		meta, err := s.Get(ctx, id)
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, *meta)
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.After(recordings[j].StartedAt)
+	})
+	return recordings, nil
+}
+
+// Open implements Storage
+func (s *LocalStorage) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(s.path(id, ".cast"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStorage) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// writeMetadata replaces the metadata file atomically, so readers never see partial JSON
+func (s *LocalStorage) writeMetadata(meta Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(meta.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(meta.ID, ".json"))
+}
+
+// localWriter counts the bytes of a recording and finalizes its metadata on Close
+type localWriter struct {
+	file    *os.File
+	storage *LocalStorage
+	meta    Metadata
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.meta.Size += int64(n)
+	return n, err
+}
+
+func (w *localWriter) Close() error {
+	err := w.file.Close()
+	ended := time.Now()
+	w.meta.EndedAt = &ended
+	if metaErr := w.storage.writeMetadata(w.meta); err == nil {
+		err = metaErr
+	}
+	return err
+}
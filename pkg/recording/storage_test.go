@@ -0,0 +1,104 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+
+	older := Metadata{ID: "older", User: "alice", Namespace: "dev", Pod: "web-0", StartedAt: time.Now().Add(-time.Hour)}
+	newer := Metadata{ID: "newer", User: "bob", Namespace: "dev", Pod: "web-1", StartedAt: time.Now()}
+	for _, meta := range []Metadata{older, newer} {
+		w, err := storage.Create(ctx, meta)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if meta.ID == "older" {
+			io.WriteString(w, "recorded\n")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+		}
+	}
+
+	// Recordings in progress are listed without an end time
+	recordings, err := storage.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(recordings) != 2 || recordings[0].ID != "newer" || recordings[1].ID != "older" {
+		t.Fatalf("Expected recordings most recent first, got %+v", recordings)
+	}
+	if recordings[0].EndedAt != nil {
+		t.Errorf("Expected the open recording to have no end time")
+	}
+	if recordings[1].EndedAt == nil || recordings[1].Size != int64(len("recorded\n")) {
+		t.Errorf("Expected the closed recording to be finalized, got %+v", recordings[1])
+	}
+
+	content, err := storage.Open(ctx, "older")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "recorded\n" {
+		t.Errorf("Unexpected content %q", data)
+	}
+
+	if _, err := storage.Create(ctx, older); err == nil {
+		t.Errorf("Expected existing recordings not to be overwritten")
+	}
+	if _, err := storage.Create(ctx, Metadata{ID: "../escape"}); err == nil {
+		t.Errorf("Expected invalid IDs to be refused")
+	}
+	for _, id := range []string{"missing", "../older", ""} {
+		if _, err := storage.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): expected ErrNotFound, got %v", id, err)
+		}
+		if _, err := storage.Open(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q): expected ErrNotFound, got %v", id, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	meta := Metadata{User: "alice", Namespace: "dev", Pod: "web-0", Config: "debug", StartedAt: start, EndedAt: &end}
+
+	tests := []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{User: "alice", Namespace: "dev", Pod: "web-0", Config: "debug"}, true},
+		{Filter{User: "bob"}, false},
+		{Filter{Pod: "web-1"}, false},
+		{Filter{Config: "other"}, false},
+		// The session overlaps the range
+		{Filter{Since: start.Add(30 * time.Minute), Until: start.Add(2 * time.Hour)}, true},
+		{Filter{Since: end.Add(time.Minute)}, false},
+		{Filter{Until: start.Add(-time.Minute)}, false},
+	}
+	for _, test := range tests {
+		if got := test.filter.Matches(meta); got != test.want {
+			t.Errorf("%+v.Matches() = %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	// Sessions in progress match any later range
+	meta.EndedAt = nil
+	if !(Filter{Since: end.Add(time.Hour)}).Matches(meta) {
+		t.Errorf("Expected a session in progress to match")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
+)
+
+// Recording playback WebSocket protocol.
+//
+// GET /api/recordings/{id}/play?speed=2&start=30 replays a recording with the framing of
+// the terminal protocol, so the terminal in the browser can render it unchanged. Binary
+// frames carry the recorded output; text frames carry a TerminalMessage:
+//
+//	server -> browser  {"op":"resize","cols":120,"rows":40}
+//	server -> browser  {"op":"position","time":30}
+//	server -> browser  {"op":"exit","code":0}
+//	browser -> server  {"op":"seek","time":12.5}
+//	browser -> server  {"op":"speed","speed":4}
+//	browser -> server  {"op":"pause"}
+//	browser -> server  {"op":"resume"}
+//	browser -> server  {"op":"ping"}
+//
+// A seek resets the terminal and replays all output up to the new position at once, so
+// the screen shows what the user saw at that time, and is confirmed by a position frame.
+// The exit frame marks the end of the recording; the connection stays open so the viewer
+// can seek back.
+const (
+	OpSeek     = "seek"
+	OpSpeed    = "speed"
+	OpPause    = "pause"
+	OpResume   = "resume"
+	OpPosition = "position"
+)
+
+// maxPlaybackSpeed limits accelerated playback
+const maxPlaybackSpeed = 64
+
+// maxSeekFrame bounds the output coalesced into one frame when seeking, so seeking far
+// into a large recording neither buffers all of it nor sends it in one message
+const maxSeekFrame = 64 << 10
+
+// terminalReset is the RIS escape sequence, which clears the screen and resets all modes
+const terminalReset = "\x1bc"
+
+// player replays the events of a recording to a WebSocket. Events are read from storage
+// as they are played; seeking reads the recording again from the start.
+type player struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	// open opens the recording, reader reads its events from content
+	open     func() (io.ReadCloser, error)
+	content  io.Closer
+	reader   *recording.Reader
+	header   recording.Header
+	controls chan TerminalMessage
+	// disconnected is closed when the browser goes away, done when playback stops
+	disconnected chan struct{}
+	done         chan struct{}
+
+	// Playback state, only used by run. upcoming is the event to play next, nil after the
+	// last one.
+	upcoming *recording.Event
+	position float64
+	speed    float64
+	paused   bool
+}
+
+// playRecordingHandler upgrades to a WebSocket and replays a recording
+func (s *Server) playRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupRecording(w, r)
+	if !ok {
+		return
+	}
+	speed, err := floatParam(r, "speed", 1)
+	if err != nil || !validSpeed(speed) {
+		http.Error(w, fmt.Sprintf("speed must be greater than 0 and at most %d", maxPlaybackSpeed), http.StatusBadRequest)
+		return
+	}
+	start, err := floatParam(r, "start", 0)
+	if err != nil || start < 0 {
+		http.Error(w, "start must be a non-negative number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	open := func() (io.ReadCloser, error) {
+		return s.recordings.Open(ctx, meta.ID)
+	}
+	content, err := open()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open recording: %v", err), http.StatusInternalServerError)
+		return
+	}
+	reader, err := recording.NewReader(content)
+	content.Close()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read recording: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Playing recording %s for %s", meta.ID, requestUser(r))
+	p := &player{
+		conn:         conn,
+		open:         open,
+		header:       reader.Header,
+		controls:     make(chan TerminalMessage),
+		disconnected: make(chan struct{}),
+		done:         make(chan struct{}),
+		speed:        speed,
+	}
+	if err := p.run(start); err != nil {
+		log.Printf("Playback of recording %s ended: %v", meta.ID, err)
+	}
+}
+
+// floatParam parses an optional numeric query parameter
+func floatParam(r *http.Request, name string, defaultValue float64) (float64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+func validSpeed(speed float64) bool {
+	return speed > 0 && speed <= maxPlaybackSpeed
+}
+
+// run plays the recording from start until the browser disconnects
+func (p *player) run(start float64) error {
+	defer close(p.done)
+	defer p.closeContent()
+	go p.readLoop()
+	if err := p.seek(start); err != nil {
+		return err
+	}
+
+	for {
+		var timer *time.Timer
+		var wait <-chan time.Time
+		waitStarted := time.Now()
+		if !p.paused && p.upcoming != nil {
+			timer = time.NewTimer(p.delay())
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+			if err := p.play(); err != nil {
+				return err
+			}
+		case msg := <-p.controls:
+			if timer != nil {
+				timer.Stop()
+				p.advance(time.Since(waitStarted))
+			}
+			if err := p.handleMessage(msg); err != nil {
+				return err
+			}
+		case <-p.disconnected:
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		}
+	}
+}
+
+// readLoop decodes control frames and hands them to run
+func (p *player) readLoop() {
+	defer close(p.disconnected)
+	for {
+		messageType, data, err := p.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			p.sendMessage(TerminalMessage{Op: OpError, Message: "recordings are read-only"})
+			continue
+		}
+
+		var msg TerminalMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			p.sendMessage(TerminalMessage{Op: OpError, Message: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+		if msg.Op == OpPing {
+			p.sendMessage(TerminalMessage{Op: OpPong})
+			continue
+		}
+		select {
+		case p.controls <- msg:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// handleMessage applies a control frame from the browser
+func (p *player) handleMessage(msg TerminalMessage) error {
+	switch msg.Op {
+	case OpSeek:
+		if msg.Time == nil || *msg.Time < 0 {
+			return p.sendMessage(TerminalMessage{Op: OpError, Message: "seek requires a non-negative time"})
+		}
+		return p.seek(*msg.Time)
+	case OpSpeed:
+		if !validSpeed(msg.Speed) {
+			return p.sendMessage(TerminalMessage{Op: OpError, Message: fmt.Sprintf("speed must be greater than 0 and at most %d", maxPlaybackSpeed)})
+		}
+		p.speed = msg.Speed
+	case OpPause:
+		p.paused = true
+		return p.sendPosition()
+	case OpResume:
+		p.paused = false
+	default:
+		return p.sendMessage(TerminalMessage{Op: OpError, Message: fmt.Sprintf("unsupported op %q", msg.Op)})
+	}
+	return nil
+}
+
+// delay is the wall-clock time until the next event at the current speed
+func (p *player) delay() time.Duration {
+	wait := (p.upcoming.Time - p.position) / p.speed
+	return time.Duration(math.Max(wait, 0) * float64(time.Second))
+}
+
+// advance moves the position by the recording time that passed while waiting
+func (p *player) advance(elapsed time.Duration) {
+	p.position = math.Min(p.position+elapsed.Seconds()*p.speed, p.upcoming.Time)
+}
+
+// play sends the next event and reports the end of the recording after the last one
+func (p *player) play() error {
+	event := *p.upcoming
+	p.position = event.Time
+	if err := p.send(event); err != nil {
+		return err
+	}
+	p.readNext()
+	if p.upcoming == nil {
+		return p.sendEnd()
+	}
+	return nil
+}
+
+// readNext reads the event to play next. An invalid event ends the recording early.
+func (p *player) readNext() {
+	event, err := p.reader.Next()
+	switch {
+	case err == io.EOF:
+		p.upcoming = nil
+	case err != nil:
+		log.Printf("Playback stops at an unreadable event: %v", err)
+		p.upcoming = nil
+	default:
+		p.upcoming = &event
+	}
+}
+
+// rewind opens the recording again to play it from the start
+func (p *player) rewind() error {
+	content, err := p.open()
+	if err != nil {
+		return err
+	}
+	reader, err := recording.NewReader(content)
+	if err != nil {
+		content.Close()
+		return err
+	}
+	p.closeContent()
+	p.content, p.reader = content, reader
+	p.readNext()
+	return nil
+}
+
+func (p *player) closeContent() {
+	if p.content != nil {
+		p.content.Close()
+		p.content = nil
+	}
+}
+
+// seek resets the terminal and replays everything up to t at once. A position past the
+// end of the recording moves to its last event.
+func (p *player) seek(t float64) error {
+	if err := p.rewind(); err != nil {
+		return err
+	}
+	if err := p.writeOutput(terminalReset); err != nil {
+		return err
+	}
+	if err := p.sendResize(p.header.Width, p.header.Height); err != nil {
+		return err
+	}
+
+	// Output between resizes is coalesced into frames of up to maxSeekFrame bytes
+	var output strings.Builder
+	last := 0.0
+	for p.upcoming != nil && p.upcoming.Time <= t {
+		event := *p.upcoming
+		last = event.Time
+		p.readNext()
+		if event.Code == recording.EventOutput {
+			output.WriteString(event.Data)
+			if output.Len() >= maxSeekFrame {
+				if err := p.writeOutput(output.String()); err != nil {
+					return err
+				}
+				output.Reset()
+			}
+			continue
+		}
+		if output.Len() > 0 && event.Code == recording.EventResize {
+			if err := p.writeOutput(output.String()); err != nil {
+				return err
+			}
+			output.Reset()
+		}
+		if err := p.send(event); err != nil {
+			return err
+		}
+	}
+	if output.Len() > 0 {
+		if err := p.writeOutput(output.String()); err != nil {
+			return err
+		}
+	}
+
+	if p.upcoming == nil {
+		t = last
+	}
+	p.position = t
+	if err := p.sendPosition(); err != nil {
+		return err
+	}
+	if p.upcoming == nil {
+		return p.sendEnd()
+	}
+	return nil
+}
+
+// send replays a single event. Input events are skipped, the terminal echo is in the output.
+func (p *player) send(event recording.Event) error {
+	switch event.Code {
+	case recording.EventOutput:
+		return p.writeOutput(event.Data)
+	case recording.EventResize:
+		var cols, rows int
+		if _, err := fmt.Sscanf(event.Data, "%dx%d", &cols, &rows); err != nil {
+			log.Printf("Skipping invalid resize event %q: %v", event.Data, err)
+			return nil
+		}
+		return p.sendResize(cols, rows)
+	}
+	return nil
+}
+
+func (p *player) sendResize(cols, rows int) error {
+	return p.sendMessage(TerminalMessage{Op: OpResize, Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+func (p *player) sendPosition() error {
+	position := p.position
+	return p.sendMessage(TerminalMessage{Op: OpPosition, Time: &position})
+}
+
+// sendEnd marks the end of the recording with an exit frame
+func (p *player) sendEnd() error {
+	code := 0
+	return p.sendMessage(TerminalMessage{Op: OpExit, Code: &code})
+}
+
+// writeOutput sends recorded output as a binary frame
+func (p *player) writeOutput(data string) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.conn.WriteMessage(websocket.BinaryMessage, []byte(data))
+}
+
+// sendMessage writes a JSON control frame to the browser
+func (p *player) sendMessage(msg TerminalMessage) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.conn.WriteJSON(msg)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
+)
+
+const testCast = `{"version":2,"width":80,"height":24}
+[0.01,"o","hello "]
+[0.02,"r","100x30"]
+[0.03,"i","secret"]
+[0.04,"o","world"]
+[30,"o","!"]
+`
+
+func TestPlayback(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	storage, err := recording.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	server.recordings = storage
+	server.authorizer = newFakeAuthorizer()
+	w, err := storage.Create(context.Background(), recording.Metadata{ID: "session", User: "alice", Namespace: "default", StartedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+	w.Write([]byte(testCast))
+	w.Close()
+
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+	play := func(token, query string) (*testTerminal, *http.Response, error) {
+		wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/recordings/session/play" + query
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			return nil, resp, err
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &testTerminal{t: t, conn: conn}, resp, nil
+	}
+
+	if _, resp, err := play("bob-token", ""); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected other users' recordings to be hidden, got %v", err)
+	}
+	if _, resp, err := play("alice-token", "?speed=1000"); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid speed to be rejected, got %v", err)
+	}
+
+	term, _, err := play("alice-token", "?speed=2")
+	if err != nil {
+		t.Fatalf("Failed to dial playback: %v", err)
+	}
+	if msg := term.expectMessage(OpResize); msg.Cols != 80 || msg.Rows != 24 {
+		t.Errorf("Expected the recorded size first, got %+v", msg)
+	}
+	if msg := term.expectMessage(OpPosition); msg.Time == nil || *msg.Time != 0 {
+		t.Errorf("Expected playback to start at 0, got %+v", msg)
+	}
+	if msg := term.expectMessage(OpResize); msg.Cols != 100 || msg.Rows != 30 {
+		t.Errorf("Expected the recorded resize, got %+v", msg)
+	}
+	term.expectOutput("hello world")
+
+	// Seeking replays everything up to the position at once
+	seekTo := func(position float64) {
+		term.output.Reset()
+		term.send(TerminalMessage{Op: OpSeek, Time: &position})
+		msg := term.expectMessage(OpPosition)
+		if msg.Time == nil || *msg.Time != position {
+			t.Errorf("Expected position %v, got %+v", position, msg)
+		}
+	}
+	seekTo(30)
+	if want := terminalReset + "hello world!"; term.output.String() != want {
+		t.Errorf("Expected output %q after seeking, got %q", want, term.output.String())
+	}
+	term.expectExit()
+
+	seekTo(0.015)
+	if want := terminalReset + "hello "; term.output.String() != want {
+		t.Errorf("Expected output %q after seeking back, got %q", want, term.output.String())
+	}
+	term.send(TerminalMessage{Op: OpSpeed, Speed: maxPlaybackSpeed})
+	term.expectOutput("world")
+	if strings.Contains(term.output.String(), "secret") {
+		t.Errorf("Input must not be replayed as output")
+	}
+
+	term.send(TerminalMessage{Op: OpPing})
+	term.expectMessage(OpPong)
+	term.stdin("ls\r")
+	if msg := term.expectMessage(OpError); !strings.Contains(msg.Message, "read-only") {
+		t.Errorf("Expected input to be refused, got %q", msg.Message)
+	}
+}
+
+func TestPlaybackTruncatedRecording(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	storage, err := recording.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	server.recordings = storage
+	w, err := storage.Create(context.Background(), recording.Metadata{ID: "session", User: "alice", Namespace: "default", StartedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+	// The session ended while the last event was being written
+	w.Write([]byte(testCast + `[31,"o","cut sh`))
+	w.Close()
+
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/recordings/session/play?start=60"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err != nil {
+		t.Fatalf("Failed to dial playback: %v", err)
+	}
+	defer conn.Close()
+	term := &testTerminal{t: t, conn: conn}
+
+	if msg := term.expectMessage(OpPosition); msg.Time == nil || *msg.Time != 30 {
+		t.Errorf("Expected playback to stop at the last complete event, got %+v", msg)
+	}
+	term.expectExit()
+	if want := terminalReset + "hello world!"; term.output.String() != want {
+		t.Errorf("Expected output %q, got %q", want, term.output.String())
+	}
+}
+
+func TestPlaybackSeekSplitsOutput(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	storage, err := recording.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	server.recordings = storage
+	w, err := storage.Create(context.Background(), recording.Metadata{ID: "session", User: "alice", Namespace: "default", StartedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+	line := strings.Repeat("x", 1023) + "\n"
+	w.Write([]byte(`{"version":2,"width":80,"height":24}` + "\n"))
+	for i := 0; i < 1024; i++ {
+		w.Write([]byte(`[` + strconv.FormatFloat(float64(i)/1000, 'f', 3, 64) + `,"o","` + strings.TrimSuffix(line, "\n") + `\n"]` + "\n"))
+	}
+	w.Close()
+
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/api/recordings/session/play?start=60"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer alice-token"}})
+	if err != nil {
+		t.Fatalf("Failed to dial playback: %v", err)
+	}
+	defer conn.Close()
+
+	// Seeking past 1 MiB of output sends it in bounded frames
+	var output strings.Builder
+	frames := 0
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read playback: %v", err)
+		}
+		if messageType == websocket.TextMessage {
+			if strings.Contains(string(data), `"op":"position"`) {
+				break
+			}
+			continue
+		}
+		if len(data) > maxSeekFrame+len(line) {
+			t.Errorf("Expected frames of at most %d bytes, got %d", maxSeekFrame+len(line), len(data))
+		}
+		frames++
+		output.Write(data)
+	}
+	if want := terminalReset + strings.Repeat(line, 1024); output.String() != want {
+		t.Errorf("Expected all output after seeking, got %d bytes", output.Len())
+	}
+	if frames < 16 {
+		t.Errorf("Expected the output to be split into several frames, got %d", frames)
+	}
+}
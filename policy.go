@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/controller"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newCommandPolicy reads the cluster-wide deny rules from COMMAND_DENY_PATTERNS, one
+// regular expression per line. They apply to every terminal and script.
+func newCommandPolicy() (*policy.Policy, error) {
+	var rules []terminalv1.CommandRule
+	for _, pattern := range strings.Split(os.Getenv("COMMAND_DENY_PATTERNS"), "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			rules = append(rules, terminalv1.CommandRule{Pattern: pattern})
+		}
+	}
+	p, err := policy.New(rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid COMMAND_DENY_PATTERNS: %v", err)
+	}
+	return p, nil
+}
+
+// commandGuard keeps command lines denied by a policy from reaching the shell of a
+// terminal. The methods do nothing on a nil guard.
+type commandGuard struct {
+	policy *policy.Policy
+	lines  *audit.LineAssembler
+	// denied is called with every blocked line
+	denied func(command string, err error)
+}
+
+// newCommandGuard returns a guard for the cluster-wide and TerminalConfig rules, or nil
+// if there are none. Blocked lines are audited as denied terminal.command events.
+func (s *Server) newCommandGuard(tc *terminalv1.TerminalConfig, record *audit.Record, sessionID string) (*commandGuard, error) {
+	configPolicy, err := policy.ForConfig(tc)
+	if err != nil {
+		return nil, err
+	}
+	combined := policy.Combine(s.commandPolicy, configPolicy)
+	if combined == nil {
+		return nil, nil
+	}
+	return &commandGuard{
+		policy: combined,
+		lines:  audit.NewLineAssembler(nil),
+		denied: func(command string, err error) {
+			session := record.Event()
+			s.audit.Log(audit.Event{
+				User:      session.User,
+				SourceIP:  session.SourceIP,
+				Action:    "terminal.command",
+				Namespace: session.Namespace,
+				Pod:       session.Pod,
+				Container: session.Container,
+				Config:    session.Config,
+				Session:   sessionID,
+				Command:   s.redactor.Redact(command),
+				Outcome:   audit.OutcomeDenied,
+				Error:     err.Error(),
+			})
+		},
+	}, nil
+}
+
+// newTerminalInput returns the line tracking of a user typing into a terminal session:
+// the command auditor and guard, logging under record, the event of the user's request
+func (s *Server) newTerminalInput(tc *terminalv1.TerminalConfig, record *audit.Record, sessionID string) (terminalInput, error) {
+	guard, err := s.newCommandGuard(tc, record, sessionID)
+	if err != nil {
+		return terminalInput{}, err
+	}
+	return terminalInput{Commands: s.commandAuditor(record, sessionID), Guard: guard}, nil
+}
+
+// filter returns the keystrokes to pass on to the shell, with Enter replaced by Ctrl-C
+// for blocked lines, and the reasons the lines were blocked
+func (g *commandGuard) filter(p []byte) ([]byte, []error) {
+	if g == nil {
+		return p, nil
+	}
+	var blocked []error
+	p = g.lines.Filter(p, func(line string) bool {
+		err := g.policy.Check(line)
+		if err != nil {
+			blocked = append(blocked, err)
+			g.denied(line, err)
+		}
+		return err == nil
+	})
+	return p, blocked
+}
+
+// output lets the guard follow the terminal output, which tells whether the shell
+// expects bracketed paste
+func (g *commandGuard) output(p []byte) {
+	if g != nil {
+		g.lines.Output(p)
+	}
+}
+
+// scriptPolicy is the policy for a script: the cluster-wide rules and, if the script
+// runs in a terminal pod, the rules of its TerminalConfig
+func (s *Server) scriptPolicy(ctx context.Context, req ScriptRequest) (*policy.Policy, error) {
+	if req.PodName == "" {
+		return s.commandPolicy, nil
+	}
+	tc, err := s.podTerminalConfig(ctx, req.Namespace, req.PodName)
+	if err != nil || tc == nil {
+		return s.commandPolicy, err
+	}
+	configPolicy, err := policy.ForConfig(tc)
+	if err != nil {
+		return nil, fmt.Errorf("TerminalConfig %s: %v", tc.Name, err)
+	}
+	return policy.Combine(s.commandPolicy, configPolicy), nil
+}
+
+// podTerminalConfig returns the TerminalConfig whose controller provisioned the pod, or
+// nil if the pod or its TerminalConfig does not exist or the pod belongs to none. Its
+// command policy, recording and session limits apply to everything run in the pod.
+func (s *Server) podTerminalConfig(ctx context.Context, namespace, podName string) (*terminalv1.TerminalConfig, error) {
+	pod, err := s.kubeClient.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+	}
+	return s.ownerTerminalConfig(ctx, pod)
+}
+
+// ownerTerminalConfig returns the TerminalConfig named by the pod's config label, or nil
+// if the pod has no such label or the TerminalConfig is gone
+func (s *Server) ownerTerminalConfig(ctx context.Context, pod *corev1.Pod) (*terminalv1.TerminalConfig, error) {
+	configName := pod.Labels[controller.ConfigLabel]
+	if configName == "" {
+		return nil, nil
+	}
+	tc, err := s.terminalClient.Get(ctx, configName)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TerminalConfig %s: %w", configName, err)
+	}
+	return tc, nil
+}
+
+// blockedMessage is shown in the terminal in place of a blocked command's output
+func blockedMessage(err error) []byte {
+	return []byte("\r\n\x1b[1;31m" + err.Error() + "\x1b[0m\r\n")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/controller"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTerminalCommandPolicy(t *testing.T) {
+	received := make(chan string, 1)
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		// Echo until the shell is told to exit, then report everything it was sent
+		var stdin strings.Builder
+		buf := make([]byte, 64)
+		for !strings.Contains(stdin.String(), "exit\r") {
+			n, err := req.Stdin.Read(buf)
+			if err != nil {
+				return 1
+			}
+			stdin.Write(buf[:n])
+			req.Stdout.Write(buf[:n])
+		}
+		received <- stdin.String()
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			CommandPolicy: &terminalv1.CommandPolicy{Deny: []terminalv1.CommandRule{
+				{Pattern: `kubectl\s+delete\s+ns\b`, Message: "namespaces are deleted by the platform team"},
+			}},
+		},
+	}))
+	cluster, err := policy.New([]terminalv1.CommandRule{{Pattern: `rm\s+-rf\s+/(\s|$)`}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	server.commandPolicy = cluster
+	server.audit = audit.NewLogger(100)
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	term := dialTerminalAs(t, httpServer.URL, "config=prod", "alice-token")
+	term.stdin("kubectl delete ns prod")
+	term.expectOutput("kubectl delete ns prod")
+	term.stdin("\r")
+	term.expectOutput("command blocked by policy: namespaces are deleted by the platform team")
+	term.stdin("rm -rf /\r")
+	term.expectOutput(`command blocked by policy: matches "rm\\s+-rf\\s+/(\\s|$)"`)
+	term.stdin("exit\r")
+	if code := term.expectExit(); code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+
+	// The blocked lines were abandoned with Ctrl-C instead of being submitted
+	if stdin := <-received; stdin != "kubectl delete ns prod\x03rm -rf /\x03exit\r" {
+		t.Errorf("Unexpected input reached the pod: %q", stdin)
+	}
+	event := waitForAuditEvent(t, server.audit, "terminal.command", "alice")
+	if event.Outcome != audit.OutcomeDenied || event.Command != "rm -rf /" || event.Config != "prod" || event.Session == "" {
+		t.Errorf("Expected a denied command event, got %+v", event)
+	}
+}
+
+func TestDirectTerminalFollowsOwnerConfig(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	execServer.addPod(&corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controller.PodName("prod"),
+			Namespace: "default",
+			Labels:    map[string]string{controller.ConfigLabel: "prod"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: controller.ContainerName}}},
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			CommandPolicy: &terminalv1.CommandPolicy{Deny: []terminalv1.CommandRule{{Pattern: `reboot`}}},
+		},
+	}))
+	server.audit = audit.NewLogger(100)
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// Opening the TerminalConfig's pod directly does not escape its policy
+	term := dialTerminalAs(t, httpServer.URL, "pod="+controller.PodName("prod"), "alice-token")
+	term.stdin("reboot\r")
+	term.expectOutput("command blocked by policy")
+	event := waitForAuditEvent(t, server.audit, "terminal.command", "alice")
+	if event.Outcome != audit.OutcomeDenied || event.Config != "prod" {
+		t.Errorf("Expected a denied command event for the config, got %+v", event)
+	}
+}
+
+func TestDirectTerminalWithDeletedOwnerConfig(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      controller.PodName("deleted"),
+			Namespace: "default",
+			Labels:    map[string]string{controller.ConfigLabel: "deleted"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: controller.ContainerName}}},
+	}
+	execServer.addPod(pod)
+	server := newTestServer(t, execServer)
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	// A pod left behind by a deleted TerminalConfig falls back to no config
+	if config, err := server.ownerTerminalConfig(context.Background(), pod); config != nil || err != nil {
+		t.Errorf("Expected no owner config, got %v, %v", config, err)
+	}
+	term := dialTerminalAs(t, httpServer.URL, "pod="+controller.PodName("deleted"), "alice-token")
+	term.stdin("echo hi\r")
+	term.expectOutput("echo hi")
+}
+
+func TestScriptCommandPolicy(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	cluster, err := policy.New([]terminalv1.CommandRule{{Pattern: `shutdown`, Message: "do not shut down shared nodes"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	server.commandPolicy = cluster
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	for _, target := range []string{`"podName":"tools-0"`, `"podName":""`} {
+		body := `{"type":"bash","script":"echo bye\nsudo shutdown -h now",` + target + `}`
+		resp, err := http.DefaultClient.Do(authorizedRequest(t, "POST", httpServer.URL+"/api/execute-script", "alice-token", body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var forbidden ForbiddenResponse
+		json.NewDecoder(resp.Body).Decode(&forbidden)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || forbidden.Reason != "do not shut down shared nodes" {
+			t.Errorf("Expected the script for %s to be blocked, got %d %+v", target, resp.StatusCode, forbidden)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "POST", httpServer.URL+"/api/execute-script", "alice-token",
+		`{"type":"bash","script":"uptime","podName":"tools-0"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected an allowed script to start, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
+)
+
+// recordingsDir is where session recordings are stored, overridden by RECORDINGS_DIR
+var recordingsDir = "./recordings"
+
+// Initial size written to recording headers; the browser's first resize follows
+const (
+	recordingCols = 80
+	recordingRows = 24
+)
+
+// startRecording creates a recorder for a terminal session if the TerminalConfig's policy
+// asks for one. It returns nil when the session is not recorded.
+func (s *Server) startRecording(ctx context.Context, config *terminalv1.TerminalConfig, namespace, pod, container string) (*recording.Recorder, error) {
+	policy := config.Spec.Recording
+	if policy == nil || !policy.Enabled {
+		return nil, nil
+	}
+	if s.recordings == nil {
+		return nil, fmt.Errorf("recording storage is not configured")
+	}
+
+	meta := recording.Metadata{
+		ID:        newID(),
+		Namespace: namespace,
+		Pod:       pod,
+		Container: container,
+		Config:    config.Name,
+		Input:     policy.RecordInput,
+		StartedAt: time.Now(),
+	}
+	if user, ok := auth.UserFrom(ctx); ok {
+		meta.User = user.Name
+	}
+	w, err := s.recordings.Create(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	recorder, err := recording.NewRecorder(w, recording.Header{
+		Width:  recordingCols,
+		Height: recordingRows,
+		Title:  fmt.Sprintf("%s/%s", namespace, pod),
+		Env:    map[string]string{"TERM": "xterm-256color"},
+	}, policy.RecordInput)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Recording terminal session in pod %s/%s as %s", namespace, pod, meta.ID)
+	return recorder, nil
+}
+
+// recordingAttributes is the permission needed to read other users' recordings of
+// sessions in a namespace. There is no such Kubernetes resource; RBAC rules can still
+// grant it to reviewers.
+func recordingAttributes(namespace string) auth.Attributes {
+	return auth.Attributes{Namespace: namespace, Verb: "get", Group: terminal.GroupName, Resource: "recordings"}
+}
+
+// canReadRecording reports whether the request's user may see a recording: their own
+// sessions, and sessions in namespaces where they may get recordings
+func (s *Server) canReadRecording(r *http.Request, meta *recording.Metadata) bool {
+	return ownsRecording(r, meta) || s.authorize(r.Context(), recordingAttributes(meta.Namespace)) == nil
+}
+
+// ownsRecording reports whether the recording is of a session of the request's user
+func ownsRecording(r *http.Request, meta *recording.Metadata) bool {
+	return meta.User != "" && meta.User == requestUser(r)
+}
+
+// lookupRecording returns the metadata of the recording in the URL, or writes 404 if it
+// does not exist or the user may not see it
+func (s *Server) lookupRecording(w http.ResponseWriter, r *http.Request) (*recording.Metadata, bool) {
+	if s.recordings == nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return nil, false
+	}
+	meta, err := s.recordings.Get(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, recording.ErrNotFound) || (err == nil && !s.canReadRecording(r, meta)) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read recording: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(meta.Namespace, meta.Pod, meta.Container)
+	record.SetConfig(meta.Config)
+	return meta, true
+}
+
+// recordingFilter parses the user, namespace, pod, config, since and until query
+// parameters of the recordings list. Times are RFC 3339.
+func recordingFilter(r *http.Request) (recording.Filter, error) {
+	query := r.URL.Query()
+	filter := recording.Filter{
+		User:      query.Get("user"),
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		Config:    query.Get("config"),
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %v", param, err)
+		}
+		*t = parsed
+	}
+	return filter, nil
+}
+
+// listRecordingsHandler returns the recordings visible to the user that match the query,
+// most recent first
+func (s *Server) listRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := recordingFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recordings := []recording.Metadata{}
+	if s.recordings != nil {
+		all, err := s.recordings.List(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list recordings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// Authorization is decided once per namespace
+		readable := make(map[string]bool)
+		for i := range all {
+			meta := &all[i]
+			if !filter.Matches(*meta) {
+				continue
+			}
+			allowed, ok := readable[meta.Namespace]
+			if !ok {
+				allowed = s.authorize(r.Context(), recordingAttributes(meta.Namespace)) == nil
+				readable[meta.Namespace] = allowed
+			}
+			if allowed || ownsRecording(r, meta) {
+				recordings = append(recordings, *meta)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordings)
+}
+
+// getRecordingHandler returns the metadata of a recording
+func (s *Server) getRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupRecording(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// downloadRecordingHandler returns the asciicast file of a recording
+func (s *Server) downloadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	meta, ok := s.lookupRecording(w, r)
+	if !ok {
+		return
+	}
+	content, err := s.recordings.Open(r.Context(), meta.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open recording: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cast"`, meta.ID))
+	io.Copy(w, content)
+}
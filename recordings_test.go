@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// asTestUser runs handler as an authenticated user, as the auth middleware would
+func asTestUser(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(auth.WithUser(r.Context(), &auth.User{Name: name})))
+	}
+}
+
+// readRecording waits for a recording to be finalized and returns its metadata and events
+func readRecording(t *testing.T, storage recording.Storage) (recording.Metadata, recording.Header, []recording.Event) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var recordings []recording.Metadata
+	for {
+		var err error
+		recordings, err = storage.List(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to list recordings: %v", err)
+		}
+		if len(recordings) == 1 && recordings[0].EndedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one finished recording, got %+v", recordings)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	content, err := storage.Open(context.Background(), recordings[0].ID)
+	if err != nil {
+		t.Fatalf("Failed to open recording: %v", err)
+	}
+	defer content.Close()
+	scanner := bufio.NewScanner(content)
+	var header recording.Header
+	var events []recording.Event
+	for scanner.Scan() {
+		if header.Version == 0 {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatalf("Invalid header %q: %v", scanner.Text(), err)
+			}
+			continue
+		}
+		var event recording.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return recordings[0], header, events
+}
+
+func TestTerminalSessionIsRecorded(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		buf := make([]byte, 64)
+		n, _ := req.Stdin.Read(buf)
+		fmt.Fprintf(req.Stdout, "you typed %s\r\n", buf[:n])
+		return 0
+	})
+	tc := terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "audited", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			Recording: &terminalv1.RecordingPolicy{Enabled: true, RecordInput: true},
+		},
+	})
+	server := newTestServer(t, execServer, tc)
+	storage, err := recording.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	server.recordings = storage
+	httpServer := httptest.NewServer(asTestUser("alice", server.terminalHandler))
+	defer httpServer.Close()
+
+	term := dialTerminal(t, httpServer.URL, "config=audited")
+	term.send(TerminalMessage{Op: OpResize, Cols: 100, Rows: 30})
+	term.stdin("héllo")
+	term.expectOutput("you typed héllo")
+	term.expectExit()
+
+	meta, header, events := readRecording(t, storage)
+	if meta.User != "alice" || meta.Config != "audited" || meta.Pod != "audited-terminal" || !meta.Input || meta.Size == 0 {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+	if header.Version != 2 || header.Width != recordingCols || header.Timestamp == 0 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+
+	streams := map[string]string{}
+	for _, event := range events {
+		streams[event.Code] += event.Data
+	}
+	if streams[recording.EventResize] != "100x30" {
+		t.Errorf("Expected resize to be recorded, got %q", streams[recording.EventResize])
+	}
+	if streams[recording.EventInput] != "héllo" {
+		t.Errorf("Expected input to be recorded, got %q", streams[recording.EventInput])
+	}
+	if !strings.Contains(streams[recording.EventOutput], "you typed héllo") {
+		t.Errorf("Expected output to be recorded, got %q", streams[recording.EventOutput])
+	}
+}
+
+// failingStorage refuses to create recordings
+type failingStorage struct {
+	recording.Storage
+}
+
+func (failingStorage) Create(context.Context, recording.Metadata) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("disk full")
+}
+
+func TestRequiredRecordingBlocksTerminal(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		t.Errorf("Terminal was opened without a recording")
+		return 0
+	})
+	tc := terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "audited", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			Recording: &terminalv1.RecordingPolicy{Enabled: true, Required: true},
+		},
+	})
+	server := newTestServer(t, execServer, tc)
+	server.recordings = failingStorage{}
+
+	rec := httptest.NewRecorder()
+	server.terminalHandler(rec, httptest.NewRequest("GET", "/api/terminal?config=audited", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "disk full") {
+		t.Errorf("Expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecordingsAPI(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer)
+	storage, err := recording.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	server.recordings = storage
+	server.authorizer = newFakeAuthorizer("carol get recordings.terminal.kubernetes-web-terminal.io team-a")
+
+	for _, meta := range []recording.Metadata{
+		{ID: "alice1", User: "alice", Namespace: "default", Pod: "web-0", StartedAt: time.Now().Add(-time.Hour)},
+		{ID: "bob1", User: "bob", Namespace: "team-a", Pod: "api-0", StartedAt: time.Now()},
+	} {
+		w, err := storage.Create(context.Background(), meta)
+		if err != nil {
+			t.Fatalf("Failed to create recording: %v", err)
+		}
+		w.Write([]byte(`{"version":2,"width":80,"height":24}` + "\n"))
+		w.Close()
+	}
+
+	users := tokenAuthenticator{"alice-token": {Name: "alice"}, "bob-token": {Name: "bob"}, "carol-token": {Name: "carol"}}
+	httpServer := httptest.NewServer(server.routes(users, nil))
+	defer httpServer.Close()
+
+	get := func(token, path string) (*http.Response, string) {
+		resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", httpServer.URL+path, token, ""))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	list := func(token, query string) []string {
+		_, body := get(token, "/api/recordings"+query)
+		var recordings []recording.Metadata
+		if err := json.Unmarshal([]byte(body), &recordings); err != nil {
+			t.Fatalf("Invalid list response %q", body)
+		}
+		var ids []string
+		for _, meta := range recordings {
+			ids = append(ids, meta.ID)
+		}
+		return ids
+	}
+
+	// Users see their own sessions; reviewers see the namespaces they may read
+	if ids := list("alice-token", ""); strings.Join(ids, ",") != "alice1" {
+		t.Errorf("alice: expected her own recording, got %v", ids)
+	}
+	if ids := list("carol-token", ""); strings.Join(ids, ",") != "bob1" {
+		t.Errorf("carol: expected team-a recordings, got %v", ids)
+	}
+
+	// Filters narrow down the visible recordings
+	server.authorizer = newFakeAuthorizer(
+		"carol get recordings.terminal.kubernetes-web-terminal.io team-a",
+		"carol get recordings.terminal.kubernetes-web-terminal.io default",
+	)
+	if ids := list("carol-token", ""); strings.Join(ids, ",") != "bob1,alice1" {
+		t.Errorf("carol: expected all recordings, got %v", ids)
+	}
+	if ids := list("carol-token", "?user=alice&pod=web-0"); strings.Join(ids, ",") != "alice1" {
+		t.Errorf("Expected filtering by user and pod, got %v", ids)
+	}
+	until := url.QueryEscape(time.Now().Add(-time.Minute).Format(time.RFC3339))
+	if ids := list("carol-token", "?until="+until); strings.Join(ids, ",") != "alice1" {
+		t.Errorf("Expected filtering by time, got %v", ids)
+	}
+	if resp, _ := get("carol-token", "/api/recordings?until=yesterday"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected invalid times to be rejected, got %s", resp.Status)
+	}
+
+	if resp, _ := get("alice-token", "/api/recordings/bob1"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected other users' recordings to be hidden, got %s", resp.Status)
+	}
+	resp, body := get("carol-token", "/api/recordings/bob1/download")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-asciicast" {
+		t.Fatalf("Expected download, got %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, `{"version":2`) || !strings.Contains(resp.Header.Get("Content-Disposition"), "bob1.cast") {
+		t.Errorf("Unexpected download %q", body)
+	}
+	if resp, _ := get("bob-token", "/api/recordings/../../etc/passwd"); resp.StatusCode == http.StatusOK {
+		t.Errorf("Expected path traversal to fail, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/controller"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/exec"
+)
+
+const (
+	// scriptExecutionLabel identifies the Job and pod of a script execution
+	scriptExecutionLabel = "terminal.kubernetes-web-terminal.io/script-execution"
+	// scriptContainerName is the name of the container that runs the script in a Job
+	scriptContainerName = "script"
+
+	defaultScriptTimeout = 5 * time.Minute
+	maxScriptTimeout     = 30 * time.Minute
+	// scriptJobTTL is how long finished Jobs are kept if the server could not delete them
+	scriptJobTTL = int32(300)
+)
+
+// States of a script execution. All but ScriptRunning are final.
+const (
+	ScriptRunning   = "Running"
+	ScriptSucceeded = "Succeeded"
+	ScriptFailed    = "Failed"
+	ScriptTimedOut  = "TimedOut"
+	ScriptCancelled = "Cancelled"
+	ScriptError     = "Error"
+)
+
+// scriptPollInterval is how often the state of a script pod is checked
+var scriptPollInterval = 500 * time.Millisecond
+
+// scriptRuntime describes how a script type is run. The script is appended to Command.
+type scriptRuntime struct {
+	Image   string
+	Command []string
+}
+
+// scriptRuntimes maps ScriptRequest.Type to the image and interpreter that run it. The image
+// can be overridden with SCRIPT_IMAGE_<TYPE>, e.g. SCRIPT_IMAGE_PYTHON.
+var scriptRuntimes = map[string]scriptRuntime{
+	"bash":    {Image: "bash:5.2", Command: []string{"bash", "-c"}},
+	"python":  {Image: "python:3.12-slim", Command: []string{"python3", "-c"}},
+	"kubectl": {Image: "bitnami/kubectl:latest", Command: []string{"bash", "-c"}},
+}
+
+// ScriptEvent is an event of a script execution. Output events carry one line of Stream in
+// Data; the final event carries Status and, if the script ran to completion, ExitCode.
+type ScriptEvent struct {
+	Time     time.Time `json:"time"`
+	Stream   string    `json:"stream,omitempty"`
+	Data     string    `json:"data,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	ExitCode *int      `json:"exitCode,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// scriptRuntimeFor returns the runtime of a script type with any image override applied
+func scriptRuntimeFor(scriptType string) (scriptRuntime, bool) {
+	runtime, ok := scriptRuntimes[scriptType]
+	if !ok {
+		return runtime, false
+	}
+	if image := os.Getenv("SCRIPT_IMAGE_" + strings.ToUpper(scriptType)); image != "" {
+		runtime.Image = image
+	}
+	return runtime, true
+}
+
+// scriptTimeout validates the requested timeout and applies the default
+func scriptTimeout(seconds int) (time.Duration, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("timeoutSeconds must not be negative")
+	}
+	if seconds == 0 {
+		return defaultScriptTimeout, nil
+	}
+	timeout := time.Duration(seconds) * time.Second
+	if timeout > maxScriptTimeout {
+		return 0, fmt.Errorf("timeoutSeconds must not exceed %d", int(maxScriptTimeout.Seconds()))
+	}
+	return timeout, nil
+}
+
+// preparedScript is a validated script with its parameters applied
+type preparedScript struct {
+	Image string
+	// Body is the script with its parameters applied; Expanded has their values in place
+	// for the command policy to check
+	Body     string
+	Expanded string
+	Command  []string
+	// Env holds NAME=value pairs for the interpreter's environment
+	Env []string
+}
+
+// prepareScript resolves the runtime and parameters of req and renders the script
+func prepareScript(req ScriptRequest) (*preparedScript, error) {
+	runtime, ok := scriptRuntimeFor(req.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported script type %q", req.Type)
+	}
+	if strings.TrimSpace(req.Script) == "" {
+		return nil, fmt.Errorf("script is required")
+	}
+
+	values, err := resolveParameters(req.ParameterDefinitions, req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderScript(req.Type, req.Script, values)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &preparedScript{Image: runtime.Image, Body: body, Expanded: expandScript(req.Script, values)}
+	script.Command = append(script.Command, runtime.Command...)
+	script.Command = append(script.Command, body)
+	script.Env = parameterEnv(values)
+	return script, nil
+}
+
+// execCommand returns the command line that runs the script with exec. Exec cannot set
+// environment variables, so they are passed through env(1).
+func (p *preparedScript) execCommand() []string {
+	if len(p.Env) == 0 {
+		return p.Command
+	}
+	command := append([]string{"env"}, p.Env...)
+	return append(command, p.Command...)
+}
+
+// containerEnv returns the environment as container env vars
+func (p *preparedScript) containerEnv() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, pair := range p.Env {
+		name, value, _ := strings.Cut(pair, "=")
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	return env
+}
+
+// executeScriptHandler starts a script and returns its execution ID. The output is streamed
+// from /api/executions/{id}/events. The script runs in the pod named by podName if given,
+// otherwise in a short-lived Job.
+func (s *Server) executeScriptHandler(w http.ResponseWriter, r *http.Request) {
+	var req ScriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	s.startScript(w, r, req)
+}
+
+// startScript validates req, starts it in the background and writes the execution ID
+func (s *Server) startScript(w http.ResponseWriter, r *http.Request, req ScriptRequest) {
+	// Validation errors are reported before anything runs
+	script, err := prepareScript(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout, err := scriptTimeout(req.TimeoutSeconds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Namespace == "" {
+		req.Namespace = s.namespace
+	}
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(req.Namespace, req.PodName, req.Container)
+	if err := s.authorize(r.Context(), scriptAttributes(req)); err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if req.PodName != "" {
+		container, err := s.resolveContainer(r.Context(), req.Namespace, req.PodName, req.Container)
+		if err != nil {
+			http.Error(w, err.Error(), containerErrorStatus(err))
+			return
+		}
+		req.Container = container
+		record.SetTarget(req.Namespace, req.PodName, req.Container)
+	}
+	commands, err := s.scriptPolicy(r.Context(), req)
+	if err == nil {
+		err = commands.CheckScript(script.Expanded)
+	}
+	if err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	execution := s.executions.start(r.Context(), req, timeout, func(ctx context.Context, stdout, stderr *lineWriter) (int, error) {
+		return s.runScript(ctx, req, script, stdout, stderr)
+	})
+	id := execution.snapshot().ID
+	// Saved scripts are audited by name, ad-hoc scripts by execution
+	if record.Event().Name == "" {
+		record.SetName(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ExecutionResponse{
+		ID:        id,
+		Status:    ScriptRunning,
+		EventsURL: "/api/executions/" + id + "/events",
+	})
+}
+
+// scriptResult builds the final event of a script execution
+func scriptResult(ctx context.Context, code int, err error) ScriptEvent {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ScriptEvent{Status: ScriptTimedOut, Error: "script did not finish within its timeout"}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ScriptEvent{Status: ScriptCancelled, Error: "script was cancelled"}
+	case err != nil:
+		return ScriptEvent{Status: ScriptError, Error: err.Error()}
+	case code != 0:
+		return ScriptEvent{Status: ScriptFailed, ExitCode: &code}
+	default:
+		return ScriptEvent{Status: ScriptSucceeded, ExitCode: &code}
+	}
+}
+
+// runScript runs req to completion and returns its exit code
+func (s *Server) runScript(ctx context.Context, req ScriptRequest, script *preparedScript, stdout, stderr io.Writer) (int, error) {
+	if req.PodName != "" {
+		return s.runScriptInPod(ctx, req, script, stdout, stderr)
+	}
+	return s.runScriptAsJob(ctx, req, script, stdout)
+}
+
+// runScriptInPod runs the script with exec in an existing pod. The pod must provide the
+// interpreter. Cancelling ctx closes the stream but cannot stop the remote process.
+func (s *Server) runScriptInPod(ctx context.Context, req ScriptRequest, script *preparedScript, stdout, stderr io.Writer) (int, error) {
+	log.Printf("Running %s script in pod %s/%s", req.Type, req.Namespace, req.PodName)
+	err := s.streamExec(ctx, execOptions{
+		Namespace: req.Namespace,
+		Pod:       req.PodName,
+		Container: req.Container,
+		Command:   script.execCommand(),
+		Stdout:    stdout,
+		Stderr:    stderr,
+	})
+	var exitErr exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// runScriptAsJob runs the script in a new Job, follows the pod log and reports the exit code
+// of the script container. The pod log interleaves stdout and stderr, so all output is
+// written to stdout. The Job is deleted when the script finishes or ctx is done.
+func (s *Server) runScriptAsJob(ctx context.Context, req ScriptRequest, script *preparedScript, stdout io.Writer) (int, error) {
+	if err := s.authorize(ctx, jobAttributes(req.Namespace)); err != nil {
+		return -1, err
+	}
+	id := newID()
+	job := buildScriptJob(id, req.Namespace, script, scriptDeadline(ctx))
+	log.Printf("Running %s script in job %s/%s", req.Type, req.Namespace, job.Name)
+
+	jobs := s.kubeClient.BatchV1().Jobs(req.Namespace)
+	if _, err := jobs.Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		return -1, fmt.Errorf("failed to create job: %v", err)
+	}
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		propagation := metav1.DeletePropagationBackground
+		if err := jobs.Delete(cleanupCtx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			log.Printf("Failed to delete script job %s/%s: %v", req.Namespace, job.Name, err)
+		}
+	}()
+
+	pod, err := s.waitForScriptPod(ctx, req.Namespace, id, func(pod *corev1.Pod) (bool, error) {
+		if status := scriptContainerStatus(pod); status != nil && status.State.Waiting != nil &&
+			controller.IsImagePullError(status.State.Waiting.Reason) {
+			return false, fmt.Errorf("failed to pull image %s: %s", script.Image, status.State.Waiting.Message)
+		}
+		return pod.Status.Phase != corev1.PodPending, nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	logs, err := s.kubeClient.CoreV1().Pods(req.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: scriptContainerName,
+		Follow:    true,
+	}).Stream(ctx)
+	if err != nil {
+		return -1, fmt.Errorf("failed to stream logs: %v", err)
+	}
+	_, err = io.Copy(stdout, logs)
+	logs.Close()
+	if err != nil {
+		return -1, err
+	}
+
+	// The log stream ends when the container exits; wait for the kubelet to report it
+	pod, err = s.waitForScriptPod(ctx, req.Namespace, id, func(pod *corev1.Pod) (bool, error) {
+		status := scriptContainerStatus(pod)
+		return status != nil && status.State.Terminated != nil, nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return int(scriptContainerStatus(pod).State.Terminated.ExitCode), nil
+}
+
+// waitForScriptPod polls the pod of a script execution until done reports true
+func (s *Server) waitForScriptPod(ctx context.Context, namespace, id string, done func(*corev1.Pod) (bool, error)) (*corev1.Pod, error) {
+	var pod *corev1.Pod
+	err := wait.PollUntilContextCancel(ctx, scriptPollInterval, true, func(ctx context.Context) (bool, error) {
+		pods, err := s.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: scriptExecutionLabel + "=" + id,
+		})
+		if err != nil || len(pods.Items) == 0 {
+			return false, nil
+		}
+		pod = &pods.Items[0]
+		return done(pod)
+	})
+	return pod, err
+}
+
+// scriptDeadline returns the remaining time before ctx expires, rounded up to whole seconds
+func scriptDeadline(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return int64(maxScriptTimeout.Seconds())
+	}
+	return int64(time.Until(deadline).Seconds()) + 1
+}
+
+// buildScriptJob returns a Job that runs the script once. activeDeadlineSeconds stops the
+// pod even if the server goes away before it can delete the Job.
+func buildScriptJob(id, namespace string, script *preparedScript, deadlineSeconds int64) *batchv1.Job {
+	labels := map[string]string{scriptExecutionLabel: id}
+	backoffLimit := int32(0)
+	ttl := scriptJobTTL
+	allowPrivilegeEscalation := false
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "script-" + id,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadlineSeconds,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: os.Getenv("SCRIPT_SERVICE_ACCOUNT"),
+					Containers: []corev1.Container{{
+						Name:    scriptContainerName,
+						Image:   script.Image,
+						Command: script.Command,
+						Env:     script.containerEnv(),
+						SecurityContext: &corev1.SecurityContext{
+							AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// scriptContainerStatus returns the status of the script container, or nil if it has none yet
+func scriptContainerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == scriptContainerName {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
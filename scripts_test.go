@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// postScript calls executeScriptHandler and returns the status code and started execution
+func postScript(t *testing.T, server *Server, body string) (int, ExecutionResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	server.executeScriptHandler(rec, httptest.NewRequest(http.MethodPost, "/api/execute-script", strings.NewReader(body)))
+
+	var response ExecutionResponse
+	if rec.Code == http.StatusAccepted {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return rec.Code, response
+}
+
+// sseEvent is a parsed Server-Sent Event
+type sseEvent struct {
+	id    string
+	name  string
+	event ScriptEvent
+}
+
+// streamExecution reads the event stream of an execution until it ends
+func streamExecution(t *testing.T, server *Server, id, lastEventID string) []sseEvent {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/executions/"+id+"/events", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	rec := httptest.NewRecorder()
+	server.executionEventsHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				if err := json.Unmarshal([]byte(value), &event.event); err != nil {
+					t.Fatalf("Invalid event data %q: %v", value, err)
+				}
+			}
+		}
+		events = append(events, event)
+	}
+	last := events[len(events)-1]
+	if last.name != "status" || last.event.Status == "" {
+		t.Fatalf("Event stream did not end with a status event: %+v", events)
+	}
+	return events
+}
+
+// scriptOutput returns the output lines of one stream
+func scriptOutput(events []sseEvent, stream string) []string {
+	var lines []string
+	for _, event := range events {
+		if event.name == "output" && event.event.Stream == stream {
+			lines = append(lines, event.event.Data)
+		}
+	}
+	return lines
+}
+
+func TestExecuteScriptInPod(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		fmt.Fprint(req.Stdout, "hello\nwor")
+		fmt.Fprint(req.Stderr, "warning\n")
+		fmt.Fprint(req.Stdout, "ld")
+		return 2
+	})
+	server := newTestServer(t, execServer)
+
+	code, execution := postScript(t, server, `{"type":"python","script":"print('hello')","podName":"app-0","container":"app"}`)
+	if code != http.StatusAccepted || execution.ID == "" || execution.Status != ScriptRunning {
+		t.Fatalf("Expected a running execution, got %d %+v", code, execution)
+	}
+	events := streamExecution(t, server, execution.ID, "")
+
+	if got := scriptOutput(events, "stdout"); strings.Join(got, "|") != "hello|world" {
+		t.Errorf("Unexpected stdout %q", got)
+	}
+	if got := scriptOutput(events, "stderr"); strings.Join(got, "|") != "warning" {
+		t.Errorf("Unexpected stderr %q", got)
+	}
+	for _, event := range events {
+		if event.event.Time.IsZero() {
+			t.Errorf("Event without timestamp: %+v", event)
+		}
+	}
+	result := events[len(events)-1].event
+	if result.Status != ScriptFailed || result.ExitCode == nil || *result.ExitCode != 2 {
+		t.Errorf("Expected Failed with exit code 2, got %+v", result)
+	}
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/app-0/exec" {
+		t.Errorf("Unexpected exec path %s", req.Path)
+	}
+	if strings.Join(req.Command, " ") != "python3 -c print('hello')" {
+		t.Errorf("Unexpected command %v", req.Command)
+	}
+}
+
+func TestExecuteScriptAsJob(t *testing.T) {
+	scriptPollInterval = 10 * time.Millisecond
+	kubeClient := kubefake.NewSimpleClientset()
+	server := &Server{kubeClient: kubeClient, namespace: "default", executions: newExecutionManager()}
+	ctx := context.Background()
+
+	// Play the job controller and kubelet: start a pod for the job and let it exit
+	go func() {
+		var jobName string
+		wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+			jobs, _ := kubeClient.BatchV1().Jobs("default").List(ctx, metav1.ListOptions{})
+			if len(jobs.Items) == 0 {
+				return false, nil
+			}
+			jobName = jobs.Items[0].Name
+			return true, nil
+		})
+		job, err := kubeClient.BatchV1().Jobs("default").Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: jobName + "-abcde", Namespace: "default", Labels: job.Spec.Template.Labels},
+			Spec:       job.Spec.Template.Spec,
+			Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  scriptContainerName,
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+				}},
+			},
+		}
+		kubeClient.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+	}()
+
+	_, execution := postScript(t, server, `{"type":"kubectl","script":"kubectl get pods","timeoutSeconds":60}`)
+	events := streamExecution(t, server, execution.ID, "")
+
+	// The fake clientset always returns "fake logs" for pod logs
+	if got := scriptOutput(events, "stdout"); strings.Join(got, "|") != "fake logs" {
+		t.Errorf("Unexpected output %q", got)
+	}
+	result := events[len(events)-1].event
+	if result.Status != ScriptSucceeded || result.ExitCode == nil || *result.ExitCode != 0 {
+		t.Errorf("Expected Succeeded with exit code 0, got %+v", result)
+	}
+
+	var created, deleted bool
+	for _, action := range kubeClient.Actions() {
+		if action.GetResource().Resource != "jobs" {
+			continue
+		}
+		switch action.GetVerb() {
+		case "create":
+			created = true
+		case "delete":
+			deleted = true
+		}
+	}
+	if !created || !deleted {
+		t.Errorf("Expected the job to be created and deleted, got create=%t delete=%t", created, deleted)
+	}
+}
+
+func TestBuildScriptJob(t *testing.T) {
+	t.Setenv("SCRIPT_IMAGE_BASH", "registry.example.com/tools:1.0")
+	t.Setenv("SCRIPT_SERVICE_ACCOUNT", "script-runner")
+	script, err := prepareScript(ScriptRequest{Type: "bash", Script: "echo hi"})
+	if err != nil {
+		t.Fatalf("Failed to prepare script: %v", err)
+	}
+	job := buildScriptJob("1234", "team-a", script, 60)
+
+	if job.Name != "script-1234" || job.Namespace != "team-a" {
+		t.Errorf("Unexpected job name %s/%s", job.Namespace, job.Name)
+	}
+	if *job.Spec.BackoffLimit != 0 || *job.Spec.ActiveDeadlineSeconds != 60 {
+		t.Errorf("Job must run once within its deadline: %+v", job.Spec)
+	}
+	podSpec := job.Spec.Template.Spec
+	if podSpec.RestartPolicy != corev1.RestartPolicyNever || podSpec.ServiceAccountName != "script-runner" {
+		t.Errorf("Unexpected pod spec %+v", podSpec)
+	}
+	container := podSpec.Containers[0]
+	if container.Image != "registry.example.com/tools:1.0" {
+		t.Errorf("Image override not applied, got %s", container.Image)
+	}
+	if strings.Join(container.Command, " ") != "bash -c echo hi" {
+		t.Errorf("Unexpected command %v", container.Command)
+	}
+	if job.Spec.Template.Labels[scriptExecutionLabel] != "1234" {
+		t.Errorf("Pod template must carry the execution label, got %v", job.Spec.Template.Labels)
+	}
+}
+
+func TestExecuteScriptValidation(t *testing.T) {
+	server := &Server{namespace: "default", executions: newExecutionManager()}
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"unknown type", `{"type":"ruby","script":"puts 1"}`},
+		{"empty script", `{"type":"bash","script":"  "}`},
+		{"timeout too long", `{"type":"bash","script":"true","timeoutSeconds":86400}`},
+		{"negative timeout", `{"type":"bash","script":"true","timeoutSeconds":-1}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, _ := postScript(t, server, tc.body); code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d", code)
+			}
+		})
+	}
+}
+
+func TestScriptResultTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if result := scriptResult(ctx, -1, ctx.Err()); result.Status != ScriptTimedOut || result.ExitCode != nil {
+		t.Errorf("Expected TimedOut without exit code, got %+v", result)
+	}
+}
+
+func TestCancelExecution(t *testing.T) {
+	release := make(chan struct{})
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		fmt.Fprint(req.Stdout, "started\n")
+		<-release
+		return 0
+	})
+	t.Cleanup(func() { close(release) })
+	server := newTestServer(t, execServer)
+
+	_, execution := postScript(t, server, `{"type":"bash","script":"sleep 600","podName":"app-0"}`)
+
+	// Wait for the script to produce output so the cancellation hits a running exec
+	running, _ := server.executions.get(execution.ID)
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		events, _, _, _ := running.eventsSince(0)
+		return len(events) > 0, nil
+	})
+	if err != nil {
+		t.Fatalf("Script never started: %v", err)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/executions/"+execution.ID, nil), map[string]string{"id": execution.ID})
+	rec := httptest.NewRecorder()
+	server.cancelExecutionHandler(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", rec.Code)
+	}
+
+	events := streamExecution(t, server, execution.ID, "")
+	if result := events[len(events)-1].event; result.Status != ScriptCancelled {
+		t.Errorf("Expected Cancelled, got %+v", result)
+	}
+	if status := running.snapshot(); status.Status != ScriptCancelled || status.FinishedAt == nil {
+		t.Errorf("Execution status not updated: %+v", status)
+	}
+}
+
+func TestExecutionEventsResume(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		fmt.Fprint(req.Stdout, "one\ntwo\nthree\n")
+		return 0
+	})
+	server := newTestServer(t, execServer)
+
+	_, execution := postScript(t, server, `{"type":"bash","script":"seq 3","podName":"app-0"}`)
+	all := streamExecution(t, server, execution.ID, "")
+	if got := scriptOutput(all, "stdout"); strings.Join(got, "|") != "one|two|three" {
+		t.Fatalf("Unexpected output %q", got)
+	}
+
+	resumed := streamExecution(t, server, execution.ID, all[0].id)
+	if got := scriptOutput(resumed, "stdout"); strings.Join(got, "|") != "two|three" {
+		t.Errorf("Resuming after event %s should skip it, got %q", all[0].id, got)
+	}
+}
+
+func TestExecutionKeepsRecentEvents(t *testing.T) {
+	execution := &scriptExecution{changed: make(chan struct{})}
+	for i := 0; i < maxExecutionEvents+5; i++ {
+		execution.add(ScriptEvent{Stream: "stdout", Data: fmt.Sprint(i)})
+	}
+
+	events, first, _, done := execution.eventsSince(0)
+	if len(events) != maxExecutionEvents || first != 5 || events[0].Data != "5" || done {
+		t.Errorf("Expected the %d most recent events from sequence 5, got %d from %d", maxExecutionEvents, len(events), first)
+	}
+}
+
+func TestExecutionNotFound(t *testing.T) {
+	server := &Server{executions: newExecutionManager()}
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/executions/missing", nil), map[string]string{"id": "missing"})
+	rec := httptest.NewRecorder()
+	server.getExecutionHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "bytes"
+
+// defaultScrollback is how much output is kept for reattaching browsers unless
+// TERMINAL_SCROLLBACK_BYTES is set
+const defaultScrollback = 256 << 10
+
+// ringBuffer keeps the most recent bytes written to it. The methods do nothing on a nil
+// buffer.
+type ringBuffer struct {
+	buf     []byte
+	start   int
+	size    int
+	wrapped bool
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	if capacity <= 0 {
+		return nil
+	}
+	return &ringBuffer{buf: make([]byte, capacity)}
+}
+
+// Write appends p, overwriting the oldest bytes when the buffer is full
+func (b *ringBuffer) Write(p []byte) {
+	if b == nil || len(p) == 0 {
+		return
+	}
+	capacity := len(b.buf)
+	if len(p) >= capacity {
+		copy(b.buf, p[len(p)-capacity:])
+		b.start, b.size = 0, capacity
+		b.wrapped = true
+		return
+	}
+	end := (b.start + b.size) % capacity
+	n := copy(b.buf[end:], p)
+	copy(b.buf, p[n:])
+	if overflow := b.size + len(p) - capacity; overflow > 0 {
+		b.start = (b.start + overflow) % capacity
+		b.size = capacity
+		b.wrapped = true
+	} else {
+		b.size += len(p)
+	}
+}
+
+// Bytes returns a copy of the buffered bytes. Once older output was dropped, it starts
+// after the first line break so that replay does not begin inside a character or an
+// escape sequence.
+func (b *ringBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	out := make([]byte, 0, b.size)
+	first := min(b.size, len(b.buf)-b.start)
+	out = append(out, b.buf[b.start:b.start+first]...)
+	out = append(out, b.buf[:b.size-first]...)
+	if b.wrapped {
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+	}
+	return out
+}
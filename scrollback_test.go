@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRingBuffer(t *testing.T) {
+	var nilBuffer *ringBuffer
+	nilBuffer.Write([]byte("ignored"))
+	if got := nilBuffer.Bytes(); got != nil {
+		t.Errorf("Expected nothing from a nil buffer, got %q", got)
+	}
+
+	b := newRingBuffer(16)
+	b.Write([]byte("abc"))
+	b.Write([]byte("def\n"))
+	if got := string(b.Bytes()); got != "abcdef\n" {
+		t.Errorf("Expected all output before the buffer fills, got %q", got)
+	}
+
+	// Older output is dropped up to the next line break
+	b.Write([]byte("ghij\nklmnop"))
+	if got := string(b.Bytes()); got != "ghij\nklmnop" {
+		t.Errorf("Expected output from the first full line, got %q", got)
+	}
+	b.Write([]byte("0123456789\nabcdefghijklmnopqrstuvwxyz"))
+	if got := string(b.Bytes()); got != "klmnopqrstuvwxyz" {
+		t.Errorf("Expected the newest bytes without a line break, got %q", got)
+	}
+}
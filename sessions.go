@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+)
+
+// defaultDetachGrace is how long a session waits for its browser to reattach unless
+// TERMINAL_DETACH_GRACE is set
+const defaultDetachGrace = 2 * time.Minute
+
+// SessionInfo describes an open terminal session
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	User         string    `json:"user"`
+	Namespace    string    `json:"namespace"`
+	Pod          string    `json:"pod"`
+	Container    string    `json:"container,omitempty"`
+	Config       string    `json:"config,omitempty"`
+	Mode         string    `json:"mode"`
+	StartedAt    time.Time `json:"startedAt"`
+	LastActivity time.Time `json:"lastActivity"`
+	BytesIn      int64     `json:"bytesIn"`
+	BytesOut     int64     `json:"bytesOut"`
+	// Attached is false while the session waits for its browser to reattach
+	Attached bool         `json:"attached"`
+	Viewers  []ViewerInfo `json:"viewers,omitempty"`
+}
+
+// activeSession is a terminal session in the registry. token is the secret that lets
+// the browser reattach.
+type activeSession struct {
+	info    SessionInfo
+	token   string
+	session *TerminalSession
+
+	// mu guards the invites to the session and the users removed from it
+	mu      sync.Mutex
+	invites map[string]Invite
+	barred  map[string]bool
+}
+
+// snapshot returns the session's description with its current counters
+func (a *activeSession) snapshot() SessionInfo {
+	info := a.info
+	info.LastActivity = time.Unix(0, a.session.lastActivity.Load())
+	info.BytesIn = a.session.bytesIn.Load()
+	info.BytesOut = a.session.bytesOut.Load()
+	info.Attached = a.session.attached()
+	info.Viewers = a.session.viewerList()
+	return info
+}
+
+// sessionManager tracks the open terminal sessions of this server
+type sessionManager struct {
+	mu       sync.RWMutex
+	sessions map[string]*activeSession
+}
+
+func newSessionManager() *sessionManager {
+	return &sessionManager{sessions: make(map[string]*activeSession)}
+}
+
+// add registers a session until remove is called with its ID and returns the token to
+// reattach to it
+func (m *sessionManager) add(info SessionInfo, session *TerminalSession) string {
+	token := newSessionToken()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[info.ID] = &activeSession{info: info, token: token, session: session}
+	return token
+}
+
+func (m *sessionManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+func (m *sessionManager) get(id string) (*activeSession, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, ok := m.sessions[id]
+	return session, ok
+}
+
+// list returns all open sessions, oldest first
+func (m *sessionManager) list() []*activeSession {
+	m.mu.RLock()
+	sessions := make([]*activeSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.mu.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].info.StartedAt.Before(sessions[j].info.StartedAt)
+	})
+	return sessions
+}
+
+// newSessionToken returns a random secret for reattaching to a session
+func newSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sessionAttributes is the permission needed to list or delete other users' sessions in a
+// namespace. There is no such Kubernetes resource; RBAC rules can still grant it to admins.
+func sessionAttributes(verb, namespace string) auth.Attributes {
+	return auth.Attributes{Namespace: namespace, Verb: verb, Group: terminal.GroupName, Resource: "sessions"}
+}
+
+// ownsSession reports whether the session was opened by the request's user
+func ownsSession(r *http.Request, info SessionInfo) bool {
+	return info.User != "" && info.User == requestUser(r)
+}
+
+// listSessionsHandler returns the open sessions of the user and, in namespaces where the
+// user may list sessions, those of everyone
+func (s *Server) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions := []SessionInfo{}
+	// Authorization is decided once per namespace
+	visible := make(map[string]bool)
+	for _, active := range s.sessions.list() {
+		info := active.snapshot()
+		allowed, ok := visible[info.Namespace]
+		if !ok {
+			allowed = s.authorize(r.Context(), sessionAttributes("list", info.Namespace)) == nil
+			visible[info.Namespace] = allowed
+		}
+		if allowed || ownsSession(r, info) {
+			sessions = append(sessions, info)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// deleteSessionHandler terminates a session. Users may end their own sessions; ending
+// those of others requires delete on sessions in the session's namespace.
+func (s *Server) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.sessions.get(mux.Vars(r)["id"])
+	var info SessionInfo
+	if ok {
+		info = active.snapshot()
+		ok = ownsSession(r, info) || s.authorize(r.Context(), sessionAttributes("list", info.Namespace)) == nil
+	}
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(info.Namespace, info.Pod, info.Container)
+	record.SetConfig(info.Config)
+	record.SetSession(info.ID)
+
+	user := requestUser(r)
+	if !ownsSession(r, info) {
+		if err := s.authorize(r.Context(), sessionAttributes("delete", info.Namespace)); err != nil {
+			if !writeForbidden(w, err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	log.Printf("Terminal session %s of %s in pod %s/%s terminated by %s", info.ID, info.User, info.Namespace, info.Pod, user)
+	active.session.terminate(CloseTerminated, "terminated", fmt.Sprintf("Session terminated by %s.", user))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// attachSessionHandler reattaches a browser to one of the user's sessions with the token
+// sent when the session started. The scrollback is replayed before live output resumes;
+// a browser still attached elsewhere is disconnected.
+func (s *Server) attachSessionHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.sessions.get(mux.Vars(r)["id"])
+	token := r.URL.Query().Get("token")
+	// The token alone identifies the browser when authentication is disabled
+	if !ok || active.info.User != requestUser(r) || subtle.ConstantTimeCompare([]byte(token), []byte(active.token)) != 1 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	info := active.info
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(info.Namespace, info.Pod, info.Container)
+	record.SetConfig(info.Config)
+	record.SetSession(info.ID)
+
+	// Permissions may have been revoked while the browser was away
+	if err := s.authorize(r.Context(), podAttributes(info.Namespace, info.Pod, info.Mode)); err != nil {
+		if !writeForbidden(w, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	detached, err := active.session.attach(conn)
+	if detached == nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "session has ended"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to replay the scrollback of session %s: %v", info.ID, err)
+	}
+	log.Printf("Browser reattached to terminal session %s in pod %s/%s", info.ID, info.Namespace, info.Pod)
+
+	// The session's handler sends the exit frame before the session is closed
+	select {
+	case <-detached:
+	case <-active.session.done:
+	}
+}
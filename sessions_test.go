@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSessionManager(t *testing.T) {
+	manager := newSessionManager()
+	started := time.Now()
+
+	// Sessions come and go while the registry is read
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("s%02d", i)
+			session := &TerminalSession{}
+			session.bytesIn.Add(int64(i))
+			manager.add(SessionInfo{ID: id, StartedAt: started.Add(time.Duration(i) * time.Second)}, session)
+			manager.list()
+			if i%2 == 1 {
+				manager.remove(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sessions := manager.list()
+	if len(sessions) != 10 {
+		t.Fatalf("Expected 10 sessions, got %d", len(sessions))
+	}
+	for i, active := range sessions {
+		info := active.snapshot()
+		if want := fmt.Sprintf("s%02d", i*2); info.ID != want || info.BytesIn != int64(i*2) {
+			t.Errorf("Expected session %s oldest first, got %+v", want, info)
+		}
+	}
+	if _, ok := manager.get("s01"); ok {
+		t.Errorf("Expected removed sessions to be gone")
+	}
+}
+
+// listSessions returns the sessions visible to the user with the token
+func listSessions(t *testing.T, serverURL, token string) []SessionInfo {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "GET", serverURL+"/api/sessions", token, ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var sessions []SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	return sessions
+}
+
+// waitForSessions waits until the user with the token sees n sessions
+func waitForSessions(t *testing.T, serverURL, token string, n int) []SessionInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sessions := listSessions(t, serverURL, token)
+		if len(sessions) == n {
+			return sessions
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sessions, got %+v", n, sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func deleteSession(t *testing.T, serverURL, token, id string) (int, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "DELETE", serverURL+"/api/sessions/"+id, token, ""))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestSessionsAPI(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	authorizer := newFakeAuthorizer(
+		"alice create pods/exec default",
+		"bob create pods/exec default",
+		"bob list sessions.terminal.kubernetes-web-terminal.io default",
+	)
+	server.authorizer = authorizer
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	alice := dialTerminalAs(t, httpServer.URL, "config=dev", "alice-token")
+	alice.stdin("hello")
+	alice.expectOutput("hello")
+	bob := dialTerminalAs(t, httpServer.URL, "config=dev", "bob-token")
+
+	// Users see their own sessions, admins everyone's in their namespaces
+	all := waitForSessions(t, httpServer.URL, "bob-token", 2)
+	own := listSessions(t, httpServer.URL, "alice-token")
+	if len(own) != 1 || own[0].User != "alice" || own[0].Pod != "dev-terminal" || own[0].Config != "dev" || own[0].BytesIn != 5 || own[0].BytesOut != 5 {
+		t.Fatalf("Expected alice's session, got %+v", own)
+	}
+	if all[0].ID != own[0].ID || all[1].User != "bob" {
+		t.Errorf("Expected both sessions, oldest first, got %+v", all)
+	}
+	if own[0].LastActivity.Before(own[0].StartedAt) {
+		t.Errorf("Expected the last activity after the start, got %+v", own[0])
+	}
+
+	// Sessions of others are hidden, or forbidden without delete
+	if code, _ := deleteSession(t, httpServer.URL, "alice-token", all[1].ID); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's session, got %d", code)
+	}
+	code, body := deleteSession(t, httpServer.URL, "bob-token", own[0].ID)
+	decodeForbidden(t, code, body)
+
+	// An admin terminates the session, which is then gone
+	authorizer.mu.Lock()
+	authorizer.allowed["bob delete sessions.terminal.kubernetes-web-terminal.io default"] = true
+	authorizer.mu.Unlock()
+	if code, body := deleteSession(t, httpServer.URL, "bob-token", own[0].ID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", code, body)
+	}
+	closeErr := alice.expectClose()
+	if closeErr.Code != CloseTerminated || closeErr.Text != "terminated" {
+		t.Errorf("Expected the terminated close code, got %v", closeErr)
+	}
+	alice.expectOutput("Session terminated by bob.")
+	waitForSessions(t, httpServer.URL, "bob-token", 1)
+
+	// Users may end their own sessions
+	if code, body := deleteSession(t, httpServer.URL, "bob-token", all[1].ID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", code, body)
+	}
+	bob.expectClose()
+	waitForSessions(t, httpServer.URL, "bob-token", 0)
+	if code, _ := deleteSession(t, httpServer.URL, "bob-token", all[1].ID); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an ended session, got %d", code)
+	}
+}
+
+// dialAttach reattaches to a session as the user with the token. It returns the HTTP
+// status when the WebSocket handshake is refused.
+func dialAttach(t *testing.T, serverURL, id, sessionToken, token string) (*testTerminal, int) {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/api/sessions/" + id + "/attach?token=" + sessionToken
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("Failed to dial session: %v", err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testTerminal{t: t, conn: conn}, http.StatusSwitchingProtocols
+}
+
+// waitForDetached waits until the user's only session has no browser attached
+func waitForDetached(t *testing.T, serverURL, token string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sessions := listSessions(t, serverURL, token)
+		if len(sessions) == 1 && !sessions[0].Attached {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a detached session, got %+v", sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionReattach(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		// Echo lines, answering "slow" only after the browser had time to go away
+		scanner := bufio.NewScanner(req.Stdin)
+		for scanner.Scan() {
+			line := scanner.Text()
+			io.WriteString(req.Stdout, line+"\r\n")
+			if line == "slow" {
+				time.Sleep(200 * time.Millisecond)
+				io.WriteString(req.Stdout, "done\r\n")
+			}
+		}
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	server.detachGrace = time.Second
+	server.scrollback = 1024
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	term := dialTerminalAs(t, httpServer.URL, "config=dev", "alice-token")
+	msg := term.expectMessage(OpSession)
+	if msg.Session == "" || msg.Token == "" {
+		t.Fatalf("Expected a session ID and token, got %+v", msg)
+	}
+	term.stdin("hello\n")
+	term.expectOutput("hello")
+
+	// The connection drops while the process is still writing
+	term.stdin("slow\n")
+	term.expectOutput("slow")
+	term.conn.UnderlyingConn().Close()
+	waitForDetached(t, httpServer.URL, "alice-token")
+
+	// Only the owner with the token may reattach
+	if _, code := dialAttach(t, httpServer.URL, msg.Session, "wrong", "alice-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a wrong token, got %d", code)
+	}
+	if _, code := dialAttach(t, httpServer.URL, msg.Session, msg.Token, "bob-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user, got %d", code)
+	}
+
+	// Output from before and during the disconnect is replayed, then the session goes on
+	term, _ = dialAttach(t, httpServer.URL, msg.Session, msg.Token, "alice-token")
+	term.expectOutput("done")
+	if output := term.output.String(); output != "hello\r\nslow\r\ndone\r\n" {
+		t.Errorf("Expected the scrollback to be replayed once, got %q", output)
+	}
+	term.stdin("again\n")
+	term.expectOutput("again")
+
+	// A second browser takes the session over
+	other, _ := dialAttach(t, httpServer.URL, msg.Session, msg.Token, "alice-token")
+	if closeErr := term.expectClose(); closeErr.Code != CloseReplaced {
+		t.Errorf("Expected the replaced close code, got %v", closeErr)
+	}
+	other.expectOutput("again")
+
+	// The session ends when nobody reattaches within the grace period
+	other.conn.UnderlyingConn().Close()
+	waitForDetached(t, httpServer.URL, "alice-token")
+	waitForSessions(t, httpServer.URL, "alice-token", 0)
+	if _, code := dialAttach(t, httpServer.URL, msg.Session, msg.Token, "alice-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an ended session, got %d", code)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+)
+
+// Access granted by an invite to a shared session
+const (
+	ShareReadOnly  = "read-only"
+	ShareReadWrite = "read-write"
+)
+
+// Presence events of a shared session
+const (
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+// Invite lets other users join a session. Token is the secret part of the link.
+type Invite struct {
+	ID        string    `json:"id"`
+	Mode      string    `json:"mode"`
+	Token     string    `json:"token,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ViewerInfo describes a browser that joined a shared session
+type ViewerInfo struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`
+	Mode     string    `json:"mode"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// Bounds on writing to viewers. Output is queued for each viewer and written by its own
+// goroutine so a slow viewer never holds up the owner; a viewer that falls
+// viewerQueueSize frames behind, or takes viewerWriteTimeout over one frame, is
+// disconnected.
+const (
+	viewerQueueSize    = 256
+	viewerWriteTimeout = 10 * time.Second
+)
+
+// viewer is a browser connected to a session through an invite
+type viewer struct {
+	ViewerInfo
+	invite string
+	conn   *websocket.Conn
+	// input follows the command lines of a read-write viewer; it is nil for read-only ones
+	input *terminalInput
+
+	// queue holds the frames writeLoop has yet to write. It is closed, under the session's
+	// writeMu, once nothing more is to be sent.
+	queue  chan viewerFrame
+	closed bool
+}
+
+// viewerFrame is a WebSocket message queued for a viewer
+type viewerFrame struct {
+	messageType int
+	data        []byte
+}
+
+// presence returns the frame announcing the viewer
+func (v *viewer) presence(event string) TerminalMessage {
+	return TerminalMessage{Op: OpPresence, Event: event, User: v.User, Viewer: v.ID, Mode: v.Mode}
+}
+
+// send queues a frame for the viewer, disconnecting it if its queue is full. The caller
+// holds the session's writeMu and must not modify data afterwards.
+func (v *viewer) send(messageType int, data []byte) {
+	if v.closed {
+		return
+	}
+	select {
+	case v.queue <- viewerFrame{messageType: messageType, data: data}:
+	default:
+		log.Printf("Disconnecting viewer %s of %s, too slow to keep up with the output", v.ID, v.User)
+		v.finish()
+		// Closing the connection unblocks writeLoop; the viewer's read loop then fails and
+		// announces the departure
+		v.conn.Close()
+	}
+}
+
+// sendJSON queues a JSON control frame for the viewer
+func (v *viewer) sendJSON(msg TerminalMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	v.send(websocket.TextMessage, data)
+}
+
+// finish stops queueing frames. writeLoop closes the connection once it has written the
+// frames already queued. The caller holds the session's writeMu.
+func (v *viewer) finish() {
+	if !v.closed {
+		v.closed = true
+		close(v.queue)
+	}
+}
+
+// writeLoop writes the queued frames to the viewer's connection until the queue is
+// finished, then closes the connection
+func (v *viewer) writeLoop() {
+	defer v.conn.Close()
+	for frame := range v.queue {
+		v.conn.SetWriteDeadline(time.Now().Add(viewerWriteTimeout))
+		if err := v.conn.WriteMessage(frame.messageType, frame.data); err != nil {
+			// The viewer's read loop fails and announces the departure
+			v.conn.Close()
+		}
+	}
+}
+
+// join adds a viewer to the session. The viewer is sent the scrollback and who is
+// present, and the others are told it joined. The returned channel is closed when the
+// viewer has left.
+func (t *TerminalSession) join(v *viewer) (<-chan struct{}, error) {
+	t.writeMu.Lock()
+	select {
+	case <-t.done:
+		t.writeMu.Unlock()
+		return nil, fmt.Errorf("session has ended")
+	default:
+	}
+	v.queue = make(chan viewerFrame, viewerQueueSize)
+	go v.writeLoop()
+	if replay := t.scrollback.Bytes(); len(replay) > 0 {
+		v.send(websocket.BinaryMessage, replay)
+	}
+	v.sendJSON(TerminalMessage{Op: OpPresence, Event: PresenceJoin, User: t.User, Mode: "owner"})
+	for _, other := range t.viewerListLocked() {
+		v.sendJSON(TerminalMessage{Op: OpPresence, Event: PresenceJoin, User: other.User, Viewer: other.ID, Mode: other.Mode})
+	}
+	t.broadcastLocked(v.presence(PresenceJoin))
+	t.viewers[v.ID] = v
+	t.writeMu.Unlock()
+
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		t.viewerLoop(v)
+	}()
+	return left, nil
+}
+
+// viewerLoop passes on the input of read-write viewers until the viewer's connection
+// fails, then removes the viewer. Viewers cannot resize the terminal.
+func (t *TerminalSession) viewerLoop(v *viewer) {
+	defer t.leave(v)
+	for {
+		messageType, data, err := v.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			if v.input != nil && !t.sendStdin(data, v.input) {
+				return
+			}
+			continue
+		}
+
+		var msg TerminalMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Op {
+		case OpStdin:
+			if v.input != nil && !t.sendStdin([]byte(msg.Data), v.input) {
+				return
+			}
+		case OpPing:
+			t.writeMu.Lock()
+			v.sendJSON(TerminalMessage{Op: OpPong})
+			t.writeMu.Unlock()
+		}
+	}
+}
+
+// leave removes a viewer and tells the others
+func (t *TerminalSession) leave(v *viewer) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if t.viewers[v.ID] != v {
+		return
+	}
+	delete(t.viewers, v.ID)
+	v.finish()
+	t.broadcastLocked(v.presence(PresenceLeave))
+}
+
+// revoke disconnects the viewers that match and returns how many there were
+func (t *TerminalSession) revoke(match func(v *viewer) bool) int {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	n := 0
+	for _, v := range t.viewers {
+		if match(v) {
+			v.send(websocket.CloseMessage, websocket.FormatCloseMessage(CloseRevoked, "access revoked"))
+			v.finish()
+			n++
+		}
+	}
+	return n
+}
+
+// viewerList returns the viewers of the session in the order they joined
+func (t *TerminalSession) viewerList() []ViewerInfo {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.viewerListLocked()
+}
+
+func (t *TerminalSession) viewerListLocked() []ViewerInfo {
+	var viewers []ViewerInfo
+	for _, v := range t.viewers {
+		viewers = append(viewers, v.ViewerInfo)
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].JoinedAt.Before(viewers[j].JoinedAt)
+	})
+	return viewers
+}
+
+// createInvite adds an invite to the session
+func (a *activeSession) createInvite(mode string) Invite {
+	invite := Invite{ID: newID(), Mode: mode, Token: newSessionToken(), CreatedAt: time.Now()}
+	invite.URL = fmt.Sprintf("/api/sessions/%s/join?invite=%s", a.info.ID, invite.Token)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.invites == nil {
+		a.invites = make(map[string]Invite)
+	}
+	a.invites[invite.ID] = invite
+	return invite
+}
+
+// removeInvite deletes an invite, reporting whether it existed
+func (a *activeSession) removeInvite(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.invites[id]
+	delete(a.invites, id)
+	return ok
+}
+
+// findInvite returns the invite with the token unless the user was removed from the
+// session
+func (a *activeSession) findInvite(token, user string) (Invite, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.barred[user] {
+		return Invite{}, false
+	}
+	for _, invite := range a.invites {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(invite.Token)) == 1 {
+			return invite, true
+		}
+	}
+	return Invite{}, false
+}
+
+// bar keeps the user from joining the session again. Viewers signed in as the owner,
+// such as everyone when authentication is disabled, cannot be told apart by user, so
+// they are only disconnected and the owner revokes their invite to keep them out.
+func (a *activeSession) bar(user string) {
+	if user == a.info.User {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.barred == nil {
+		a.barred = make(map[string]bool)
+	}
+	a.barred[user] = true
+}
+
+// ownedSession returns the session in the request path if the user owns it, writing a
+// 404 otherwise
+func (s *Server) ownedSession(w http.ResponseWriter, r *http.Request) (*activeSession, bool) {
+	active, ok := s.sessions.get(mux.Vars(r)["id"])
+	if !ok || !ownsSession(r, active.info) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return nil, false
+	}
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(active.info.Namespace, active.info.Pod, active.info.Container)
+	record.SetConfig(active.info.Config)
+	record.SetSession(active.info.ID)
+	return active, true
+}
+
+// createInviteHandler lets the owner of a session invite others to watch it, or with
+// read-write access to type into it as well
+func (s *Server) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = ShareReadOnly
+	case ShareReadOnly, ShareReadWrite:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid mode %q, expected %s or %s", req.Mode, ShareReadOnly, ShareReadWrite), http.StatusBadRequest)
+		return
+	}
+
+	invite := active.createInvite(req.Mode)
+	log.Printf("Created %s invite %s to terminal session %s", invite.Mode, invite.ID, active.info.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// revokeInviteHandler deletes an invite and disconnects the viewers who joined with it
+func (s *Server) revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["invite"]
+	if !active.removeInvite(id) {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	n := active.session.revoke(func(v *viewer) bool { return v.invite == id })
+	log.Printf("Revoked invite %s to terminal session %s, disconnecting %d viewers", id, active.info.ID, n)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeViewerHandler disconnects a viewer and keeps its user from joining again
+func (s *Server) removeViewerHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["viewer"]
+	var user string
+	n := active.session.revoke(func(v *viewer) bool {
+		if v.ID != id {
+			return false
+		}
+		user = v.User
+		return true
+	})
+	if n == 0 {
+		http.Error(w, "Viewer not found", http.StatusNotFound)
+		return
+	}
+	active.bar(user)
+	log.Printf("Removed %s from terminal session %s", user, active.info.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// joinSessionHandler connects a user with an invite to a shared session. Read-write
+// viewers type into the pod, so they need the same exec permission as the owner, and
+// their commands are audited and checked against the command policy as their own.
+func (s *Server) joinSessionHandler(w http.ResponseWriter, r *http.Request) {
+	active, ok := s.sessions.get(mux.Vars(r)["id"])
+	user := requestUser(r)
+	var invite Invite
+	if ok {
+		invite, ok = active.findInvite(r.URL.Query().Get("invite"), user)
+	}
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	info := active.info
+	record := audit.RecordFrom(r.Context())
+	record.SetTarget(info.Namespace, info.Pod, info.Container)
+	record.SetConfig(info.Config)
+	record.SetSession(info.ID)
+
+	var input *terminalInput
+	if invite.Mode == ShareReadWrite {
+		if err := s.authorize(r.Context(), podAttributes(info.Namespace, info.Pod, info.Mode)); err != nil {
+			if !writeForbidden(w, err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		viewerInput, err := active.session.NewInput(record)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid command policy: %v", err), http.StatusInternalServerError)
+			return
+		}
+		input = &viewerInput
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	v := &viewer{
+		ViewerInfo: ViewerInfo{ID: newID(), User: user, Mode: invite.Mode, JoinedAt: time.Now()},
+		invite:     invite.ID,
+		conn:       conn,
+		input:      input,
+	}
+	left, err := active.session.join(v)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "session has ended"))
+		return
+	}
+	log.Printf("%s joined terminal session %s of %s as a %s viewer", user, info.ID, info.User, invite.Mode)
+
+	// Once the session ends, the viewer is removed after the frames queued for it, such as
+	// the close frame, have been written
+	select {
+	case <-left:
+	case <-active.session.done:
+		active.session.leave(v)
+		<-left
+	}
+}
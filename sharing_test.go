@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createInvite asks for an invite to a session as the user with the token
+func createInvite(t *testing.T, serverURL, token, id, mode string) (int, Invite) {
+	t.Helper()
+	body := `{"mode":"` + mode + `"}`
+	resp, err := http.DefaultClient.Do(authorizedRequest(t, "POST", serverURL+"/api/sessions/"+id+"/invites", token, body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var invite Invite
+	if resp.StatusCode == http.StatusCreated {
+		if err := json.NewDecoder(resp.Body).Decode(&invite); err != nil {
+			t.Fatalf("Failed to decode invite: %v", err)
+		}
+	}
+	return resp.StatusCode, invite
+}
+
+// dialJoin joins a session with an invite URL as the user with the token. It returns the
+// HTTP status and body when the WebSocket handshake is refused.
+func dialJoin(t *testing.T, serverURL, inviteURL, token string) (*testTerminal, int, string) {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + inviteURL
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("Failed to join session: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode, string(body)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testTerminal{t: t, conn: conn}, http.StatusSwitchingProtocols, ""
+}
+
+// expectPresence reads until a presence frame for the event and user arrives
+func (tt *testTerminal) expectPresence(event, user string) TerminalMessage {
+	tt.t.Helper()
+	for {
+		if msg := tt.expectMessage(OpPresence); msg.Event == event && msg.User == user {
+			return msg
+		}
+	}
+}
+
+func TestSharedSession(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	server.scrollback = 1024
+	authorizer := newFakeAuthorizer("alice create pods/exec default")
+	server.authorizer = authorizer
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	owner := dialTerminalAs(t, httpServer.URL, "config=dev", "alice-token")
+	owner.stdin("hello ")
+	owner.expectOutput("hello ")
+	id := waitForSessions(t, httpServer.URL, "alice-token", 1)[0].ID
+
+	// Only the owner invites, read-only unless asked otherwise
+	if code, _ := createInvite(t, httpServer.URL, "bob-token", id, ShareReadOnly); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's session, got %d", code)
+	}
+	if code, _ := createInvite(t, httpServer.URL, "alice-token", id, "admin"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown mode, got %d", code)
+	}
+	code, readOnly := createInvite(t, httpServer.URL, "alice-token", id, "")
+	if code != http.StatusCreated || readOnly.Mode != ShareReadOnly || readOnly.URL == "" {
+		t.Fatalf("Expected a read-only invite, got %d %+v", code, readOnly)
+	}
+	if _, code, _ := dialJoin(t, httpServer.URL, "/api/sessions/"+id+"/join?invite=wrong", "bob-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a wrong invite, got %d", code)
+	}
+
+	// A viewer sees the output so far and who is present, and is announced
+	watcher, _, _ := dialJoin(t, httpServer.URL, readOnly.URL, "bob-token")
+	watcher.expectOutput("hello ")
+	if msg := watcher.expectMessage(OpPresence); msg.User != "alice" || msg.Mode != "owner" {
+		t.Errorf("Expected the owner to be present, got %+v", msg)
+	}
+	joined := owner.expectPresence(PresenceJoin, "bob")
+	if joined.Mode != ShareReadOnly || joined.Viewer == "" {
+		t.Errorf("Expected a read-only viewer, got %+v", joined)
+	}
+
+	// Read-only viewers cannot type but see what the owner does
+	watcher.stdin("ignored ")
+	owner.stdin("world ")
+	watcher.expectOutput("world ")
+	if output := watcher.output.String(); strings.Contains(output, "ignored") {
+		t.Errorf("Expected read-only input to be dropped, got %q", output)
+	}
+
+	// Read-write viewers need to be allowed to exec into the pod
+	_, readWrite := createInvite(t, httpServer.URL, "alice-token", id, ShareReadWrite)
+	_, code, body := dialJoin(t, httpServer.URL, readWrite.URL, "bob-token")
+	decodeForbidden(t, code, body)
+	authorizer.mu.Lock()
+	authorizer.allowed["bob create pods/exec default"] = true
+	authorizer.mu.Unlock()
+	writer, _, _ := dialJoin(t, httpServer.URL, readWrite.URL, "bob-token")
+	writer.expectPresence(PresenceJoin, "bob")
+	writerID := owner.expectPresence(PresenceJoin, "bob").Viewer
+
+	// Input from the owner and the writer reaches the same shell
+	writer.stdin("from-bob ")
+	owner.expectOutput("from-bob ")
+	watcher.expectOutput("from-bob ")
+	if viewers := listSessions(t, httpServer.URL, "alice-token")[0].Viewers; len(viewers) != 2 || viewers[1].Mode != ShareReadWrite {
+		t.Errorf("Expected two viewers, got %+v", viewers)
+	}
+
+	// Revoking an invite disconnects the viewers who joined with it
+	if code, _ := deleteSession(t, httpServer.URL, "alice-token", id+"/invites/"+readOnly.ID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if closeErr := watcher.expectClose(); closeErr.Code != CloseRevoked {
+		t.Errorf("Expected the revoked close code, got %v", closeErr)
+	}
+	owner.expectPresence(PresenceLeave, "bob")
+	if _, code, _ := dialJoin(t, httpServer.URL, readOnly.URL, "bob-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a revoked invite, got %d", code)
+	}
+
+	// A removed viewer cannot join again, even with a new invite
+	if code, _ := deleteSession(t, httpServer.URL, "bob-token", id+"/viewers/"+writerID); code != http.StatusNotFound {
+		t.Errorf("Expected 404 when a viewer removes another, got %d", code)
+	}
+	if code, _ := deleteSession(t, httpServer.URL, "alice-token", id+"/viewers/"+writerID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if closeErr := writer.expectClose(); closeErr.Code != CloseRevoked {
+		t.Errorf("Expected the revoked close code, got %v", closeErr)
+	}
+	_, again := createInvite(t, httpServer.URL, "alice-token", id, ShareReadOnly)
+	if _, code, _ := dialJoin(t, httpServer.URL, again.URL, "bob-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a removed viewer, got %d", code)
+	}
+
+	// Viewers are told when the session ends
+	viewer, _, _ := dialJoin(t, httpServer.URL, again.URL, "alice-token")
+	viewer.expectPresence(PresenceJoin, "alice")
+	if code, body := deleteSession(t, httpServer.URL, "alice-token", id); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", code, body)
+	}
+	if closeErr := viewer.expectClose(); closeErr.Code != CloseTerminated {
+		t.Errorf("Expected the terminated close code, got %v", closeErr)
+	}
+	owner.expectClose()
+}
+
+func TestSlowViewerIsDisconnected(t *testing.T) {
+	chunk := []byte(strings.Repeat("x", 32<<10))
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		req.Stdin.Read(make([]byte, 1))
+		for i := 0; i < 1024; i++ {
+			if _, err := req.Stdout.Write(chunk); err != nil {
+				return 1
+			}
+		}
+		io.Copy(io.Discard, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	owner := dialTerminalAs(t, httpServer.URL, "config=dev", "alice-token")
+	id := waitForSessions(t, httpServer.URL, "alice-token", 1)[0].ID
+	_, invite := createInvite(t, httpServer.URL, "alice-token", id, ShareReadOnly)
+
+	// The viewer never reads, so its queue fills while the owner keeps up with the output
+	dialJoin(t, httpServer.URL, invite.URL, "bob-token")
+	owner.expectPresence(PresenceJoin, "bob")
+	owner.stdin("go")
+	owner.expectPresence(PresenceLeave, "bob")
+	for owner.output.Len() < len(chunk)*1024 {
+		owner.next()
+	}
+}
+
+func TestRemovingAnonymousViewerKeepsInviteUsable(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+	}))
+	httpServer := httptest.NewServer(server.routes(auth.Anonymous{Name: "anonymous"}, nil))
+	defer httpServer.Close()
+
+	owner := dialTerminalAs(t, httpServer.URL, "config=dev", "")
+	id := waitForSessions(t, httpServer.URL, "", 1)[0].ID
+	_, invite := createInvite(t, httpServer.URL, "", id, ShareReadOnly)
+
+	// Everyone is the same user, so removing a viewer only disconnects that connection
+	first, _, _ := dialJoin(t, httpServer.URL, invite.URL, "")
+	viewerID := owner.expectPresence(PresenceJoin, "anonymous").Viewer
+	if code, body := deleteSession(t, httpServer.URL, "", id+"/viewers/"+viewerID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", code, body)
+	}
+	if closeErr := first.expectClose(); closeErr.Code != CloseRevoked {
+		t.Errorf("Expected the revoked close code, got %v", closeErr)
+	}
+	second, code, body := dialJoin(t, httpServer.URL, invite.URL, "")
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected other viewers to still join, got %d: %s", code, body)
+	}
+	second.expectPresence(PresenceJoin, "anonymous")
+}
+
+func TestViewerCommandsAreTheirOwn(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		io.Copy(req.Stdout, req.Stdin)
+		return 0
+	})
+	server := newTestServer(t, execServer, terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "default"},
+		Spec: terminalv1.TerminalConfigSpec{
+			CommandPolicy: &terminalv1.CommandPolicy{Deny: []terminalv1.CommandRule{{Pattern: `reboot`}}},
+		},
+	}))
+	server.audit = audit.NewLogger(100)
+	server.auditCommands = true
+	httpServer := httptest.NewServer(server.routes(testUsers, nil))
+	defer httpServer.Close()
+
+	owner := dialTerminalAs(t, httpServer.URL, "config=dev", "alice-token")
+	id := waitForSessions(t, httpServer.URL, "alice-token", 1)[0].ID
+	_, invite := createInvite(t, httpServer.URL, "alice-token", id, ShareReadWrite)
+	writer, _, _ := dialJoin(t, httpServer.URL, invite.URL, "bob-token")
+	owner.expectPresence(PresenceJoin, "bob")
+
+	// The owner's half-typed line and the viewer's command are assembled separately
+	owner.stdin("echo al")
+	owner.expectOutput("echo al")
+	writer.stdin("whoami\r")
+	owner.expectOutput("whoami\r")
+	writer.stdin("reboot\r")
+	owner.expectOutput("reboot")
+	owner.stdin("ice\r")
+	owner.expectOutput("ice\r")
+
+	commands := make(map[string]audit.Event)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(commands) < 3 && time.Now().Before(deadline) {
+		for _, event := range server.audit.Recent(auditCapacity) {
+			if event.Action == "terminal.command" {
+				commands[event.User+" "+event.Command] = event
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if event, ok := commands["bob whoami"]; !ok || event.Outcome != audit.OutcomeSuccess || event.Session != id {
+		t.Errorf("Expected the viewer's command to be audited as theirs, got %+v", commands)
+	}
+	if event, ok := commands["bob reboot"]; !ok || event.Outcome != audit.OutcomeDenied {
+		t.Errorf("Expected the viewer's blocked command to be denied as theirs, got %+v", commands)
+	}
+	if event, ok := commands["alice echo alice"]; !ok || event.Outcome != audit.OutcomeSuccess {
+		t.Errorf("Expected the owner's command to be assembled on its own, got %+v", commands)
+	}
+}
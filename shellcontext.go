@@ -0,0 +1,169 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Quoting contexts of a position in a shell script
+const (
+	shellUnquoted = iota
+	shellSingleQuoted
+	shellDoubleQuoted
+	// shellHeredoc is the body of a heredoc whose delimiter is unquoted, where parameters
+	// are expanded as in double quotes
+	shellHeredoc
+	// shellQuotedHeredoc is the body of a heredoc whose delimiter is quoted, which is
+	// taken literally
+	shellQuotedHeredoc
+)
+
+// heredocPattern matches the redirection starting a heredoc and captures its delimiter
+var heredocPattern = regexp.MustCompile(`^<<-?[ \t]*(?:'([^'\n]+)'|"([^"\n]+)"|\\?([A-Za-z0-9_]+))`)
+
+// heredoc is a heredoc whose body starts on the next line
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+	quoted    bool
+}
+
+// shellContexts returns the quoting context of each offset in offsets, which must be
+// ascending, in a bash script. Command substitutions and backticks start a fresh
+// unquoted context, even inside double quotes, like the shell does. Offsets inside a
+// comment are reported as unquoted.
+func shellContexts(script string, offsets []int) []int {
+	contexts := make([]int, 0, len(offsets))
+	// stack holds the quote characters and command substitutions that are open
+	var stack []byte
+	var pending []heredoc
+	top := func() byte {
+		if len(stack) == 0 {
+			return 0
+		}
+		return stack[len(stack)-1]
+	}
+
+	i := 0
+	for len(contexts) < len(offsets) {
+		// Report the context of every offset reached
+		for len(contexts) < len(offsets) && offsets[len(contexts)] <= i {
+			switch top() {
+			case '\'':
+				contexts = append(contexts, shellSingleQuoted)
+			case '"':
+				contexts = append(contexts, shellDoubleQuoted)
+			default:
+				contexts = append(contexts, shellUnquoted)
+			}
+		}
+		if i >= len(script) {
+			for len(contexts) < len(offsets) {
+				contexts = append(contexts, shellUnquoted)
+			}
+			break
+		}
+
+		c := script[i]
+		switch top() {
+		case '\'':
+			if c == '\'' {
+				stack = stack[:len(stack)-1]
+			}
+			i++
+			continue
+		case '"':
+			switch {
+			case c == '\\':
+				i += 2
+			case c == '"':
+				stack = stack[:len(stack)-1]
+				i++
+			case strings.HasPrefix(script[i:], "$("):
+				stack = append(stack, '(')
+				i += 2
+			case c == '`':
+				stack = append(stack, '`')
+				i++
+			default:
+				i++
+			}
+			continue
+		}
+
+		// Unquoted, at the top level or in a command substitution
+		switch {
+		case c == '\\':
+			i += 2
+		case c == '\'' || c == '"':
+			stack = append(stack, c)
+			i++
+		case c == '`' && top() == '`':
+			stack = stack[:len(stack)-1]
+			i++
+		case c == '`':
+			stack = append(stack, '`')
+			i++
+		case strings.HasPrefix(script[i:], "$("):
+			stack = append(stack, '(')
+			i += 2
+		case c == ')' && top() == '(':
+			stack = stack[:len(stack)-1]
+			i++
+		case c == '#' && (i == 0 || strings.ContainsRune(" \t\n;&|(", rune(script[i-1]))):
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(script[i:], "<<<"):
+			i += 3
+		case strings.HasPrefix(script[i:], "<<"):
+			match := heredocPattern.FindStringSubmatch(script[i:])
+			if match == nil {
+				i += 2
+				continue
+			}
+			doc := heredoc{delimiter: match[1] + match[2] + match[3], stripTabs: strings.HasPrefix(script[i:], "<<-")}
+			doc.quoted = match[1] != "" || match[2] != "" || strings.HasPrefix(strings.TrimLeft(script[i+2:], "- \t"), `\`)
+			pending = append(pending, doc)
+			i += len(match[0])
+		case c == '\n' && len(pending) > 0:
+			i++
+			for _, doc := range pending {
+				i = skipHeredoc(script, i, doc, offsets, &contexts)
+			}
+			pending = nil
+		default:
+			i++
+		}
+	}
+	return contexts
+}
+
+// skipHeredoc reports the context of the offsets in the heredoc body starting at i and
+// returns the offset after its delimiter line
+func skipHeredoc(script string, i int, doc heredoc, offsets []int, contexts *[]int) int {
+	context := shellHeredoc
+	if doc.quoted {
+		context = shellQuotedHeredoc
+	}
+	for i < len(script) {
+		end := strings.IndexByte(script[i:], '\n')
+		if end < 0 {
+			end = len(script)
+		} else {
+			end += i
+		}
+		line := script[i:end]
+		if doc.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == doc.delimiter {
+			return end
+		}
+		for len(*contexts) < len(offsets) && offsets[len(*contexts)] < end {
+			*contexts = append(*contexts, context)
+		}
+		i = end + 1
+	}
+	return i
+}
@@ -3,7 +3,10 @@ let currentScript = null;
 let savedScripts = [];
 
 // Initialize the application
-document.addEventListener('DOMContentLoaded', function() {
+document.addEventListener('DOMContentLoaded', async function() {
+    if (!await ensureLoggedIn()) {
+        return;
+    }
     loadPods();
     loadSavedScripts();
     
@@ -14,6 +17,21 @@ document.addEventListener('DOMContentLoaded', function() {
     });
 });
 
+// Send the browser to the login page when the server requires it
+async function ensureLoggedIn() {
+    const response = await fetch('/auth/me');
+    if (response.status !== 401) {
+        return true;
+    }
+    const body = await response.json().catch(() => ({}));
+    if (body.loginUrl) {
+        window.location.href = body.loginUrl + '?redirect=' + encodeURIComponent(window.location.pathname);
+    } else {
+        showOutput('Authentication required: provide a bearer token to use the API', 'error');
+    }
+    return false;
+}
+
 // Tab functionality
 function openTab(evt, tabName) {
     const tabContents = document.getElementsByClassName("tab-content");
@@ -31,6 +49,20 @@ function openTab(evt, tabName) {
 }
 
 // Terminal functionality
+// Pods returned by /api/pods, indexed by the value of their option in the pod dropdown
+let terminalPods = [];
+// The open terminal: its xterm.js instance, WebSocket and the session to reattach to
+let terminalState = null;
+
+const terminalCloseMessages = {
+    4000: 'Session closed after being idle',
+    4001: 'Session reached its maximum duration',
+    4002: 'Session was terminated',
+    4003: 'Session was opened in another window',
+    4004: 'Access to the session was revoked',
+    4005: 'Connection to the container was lost, the process is still running'
+};
+
 async function loadPods() {
     try {
         const response = await fetch('/api/pods');
@@ -38,11 +70,12 @@ async function loadPods() {
         
         const podSelect = document.getElementById('pod-select');
         podSelect.innerHTML = '';
+        terminalPods = data.pods || [];
         
-        if (data.pods && data.pods.length > 0) {
-            data.pods.forEach(pod => {
+        if (terminalPods.length > 0) {
+            terminalPods.forEach((pod, index) => {
                 const option = document.createElement('option');
-                option.value = pod.name;
+                option.value = index;
                 option.textContent = `${pod.name} (${pod.namespace})`;
                 podSelect.appendChild(option);
             });
@@ -52,6 +85,8 @@ async function loadPods() {
             option.textContent = 'No pods found';
             podSelect.appendChild(option);
         }
+        podSelect.onchange = loadContainers;
+        loadContainers();
     } catch (error) {
         console.error('Error loading pods:', error);
         const podSelect = document.getElementById('pod-select');
@@ -59,27 +94,161 @@ async function loadPods() {
     }
 }
 
+// Fill the container dropdown for the selected pod, preselecting its default container
+function loadContainers() {
+    const pod = terminalPods[document.getElementById('pod-select').value];
+    const containerSelect = document.getElementById('container-select');
+    containerSelect.innerHTML = '';
+    
+    const containers = pod ? pod.containers || [] : [];
+    if (containers.length === 0) {
+        containerSelect.innerHTML = '<option value="">Default container</option>';
+        return;
+    }
+    containers.forEach(container => {
+        const option = document.createElement('option');
+        option.value = container.name;
+        option.textContent = container.type === 'container' ? container.name : `${container.name} (${container.type})`;
+        if (container.state && container.state !== 'running') {
+            option.textContent += ` - ${container.reason || container.state}`;
+        }
+        option.selected = container.name === pod.defaultContainer;
+        containerSelect.appendChild(option);
+    });
+}
+
 function connectToTerminal() {
-    const podSelect = document.getElementById('pod-select');
-    const selectedPod = podSelect.value;
+    const pod = terminalPods[document.getElementById('pod-select').value];
     
-    if (!selectedPod) {
+    if (!pod) {
         alert('Please select a pod first');
         return;
     }
     
-    // TODO: Implement WebSocket terminal connection
-    const terminal = document.getElementById('terminal');
-    terminal.innerHTML = `<div style="color: #00ff00;">Connecting to pod: ${selectedPod}...</div>`;
+    disconnectTerminal();
+    const element = document.getElementById('terminal');
+    element.innerHTML = '';
+    const term = new Terminal({ cursorBlink: true, fontFamily: "'Courier New', monospace", fontSize: 14 });
+    const fit = new FitAddon.FitAddon();
+    term.loadAddon(fit);
+    term.open(element);
+    fit.fit();
+    
+    terminalState = { term: term, fit: fit, socket: null, session: null, reconnects: 0 };
+    const query = new URLSearchParams({ namespace: pod.namespace, pod: pod.name });
+    const container = document.getElementById('container-select').value;
+    if (container) {
+        query.set('container', container);
+    }
+    const mode = document.getElementById('mode-select').value;
+    if (mode !== 'exec') {
+        query.set('mode', mode);
+    }
+    term.writeln(`${mode === 'attach' ? 'Attaching' : 'Connecting'} to ${pod.namespace}/${pod.name}${container ? ' (' + container + ')' : ''}...`);
+    
+    const encoder = new TextEncoder();
+    term.onData(data => {
+        const socket = terminalState && terminalState.socket;
+        if (socket && socket.readyState === WebSocket.OPEN) {
+            socket.send(encoder.encode(data));
+        }
+    });
+    term.onResize(size => sendTerminalMessage({ op: 'resize', cols: size.cols, rows: size.rows }));
+    window.onresize = () => fit.fit();
     
-    // Placeholder for terminal connection
-    setTimeout(() => {
-        terminal.innerHTML += `<div style="color: #00ff00;">Connected! Terminal functionality will be implemented with WebSocket connection.</div>`;
-    }, 1000);
+    openTerminalSocket(`/api/terminal?${query}`);
+    document.getElementById('disconnect-btn').disabled = false;
+}
+
+// Open the terminal WebSocket. Dropped connections are reattached to the session while
+// the server keeps it open.
+function openTerminalSocket(path, reattach = false) {
+    const state = terminalState;
+    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const socket = new WebSocket(`${protocol}//${window.location.host}${path}`);
+    socket.binaryType = 'arraybuffer';
+    state.socket = socket;
+    
+    socket.onopen = () => {
+        // The server replays the session's recent output
+        if (reattach) {
+            state.term.reset();
+        }
+        state.reconnects = 0;
+        sendTerminalMessage({ op: 'resize', cols: state.term.cols, rows: state.term.rows });
+        state.term.focus();
+    };
+    
+    socket.onmessage = event => {
+        if (typeof event.data !== 'string') {
+            state.term.write(new Uint8Array(event.data));
+            return;
+        }
+        const message = JSON.parse(event.data);
+        if (message.op === 'session') {
+            state.session = message;
+        } else if (message.op === 'exit') {
+            state.session = null;
+            state.term.writeln(`\r\n\x1b[1;33m[Process exited with code ${message.code}]\x1b[0m`);
+        } else if (message.op === 'error') {
+            state.session = null;
+            state.term.writeln(`\r\n\x1b[1;31m${message.message}\x1b[0m`);
+        } else if (message.op === 'presence') {
+            const verb = message.event === 'join' ? 'joined' : 'left';
+            state.term.writeln(`\r\n\x1b[1;36m[${message.user} ${verb} (${message.mode})]\x1b[0m`);
+        }
+    };
+    
+    socket.onclose = event => {
+        if (terminalState !== state || state.socket !== socket) {
+            return;
+        }
+        if (terminalCloseMessages[event.code]) {
+            state.term.writeln(`\r\n\x1b[1;33m[${terminalCloseMessages[event.code]}]\x1b[0m`);
+        } else if (event.code === 1006 && state.session && state.reconnects < 5) {
+            // The connection dropped while the process may still be running
+            state.reconnects++;
+            state.term.writeln('\r\n\x1b[1;33m[Connection lost, reconnecting...]\x1b[0m');
+            const session = state.session;
+            const path = `/api/sessions/${session.session}/attach?token=${encodeURIComponent(session.token)}`;
+            setTimeout(() => {
+                if (terminalState === state) {
+                    openTerminalSocket(path, true);
+                }
+            }, 1000 * state.reconnects);
+            return;
+        } else if (event.code !== 1000) {
+            state.term.writeln(`\r\n\x1b[1;31m[Connection closed${event.reason ? ': ' + event.reason : ''}]\x1b[0m`);
+        }
+        document.getElementById('disconnect-btn').disabled = true;
+    };
+}
+
+// Send a JSON control frame if the terminal is connected
+function sendTerminalMessage(message) {
+    const socket = terminalState && terminalState.socket;
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify(message));
+    }
+}
+
+function disconnectTerminal() {
+    if (!terminalState) {
+        return;
+    }
+    const state = terminalState;
+    terminalState = null;
+    if (state.socket) {
+        state.socket.close(1000);
+    }
+    state.term.dispose();
+    window.onresize = null;
+    document.getElementById('disconnect-btn').disabled = true;
 }
 
 // Tool Builder functionality
-function saveScript() {
+// Scripts are stored as TerminalScript resources and shared through /api/scripts
+async function saveScript() {
     const scriptName = document.getElementById('script-name').value.trim();
     const scriptType = document.getElementById('script-type').value;
     const scriptContent = document.getElementById('script-editor').value;
@@ -94,45 +263,53 @@ function saveScript() {
         return;
     }
     
-    const script = {
-        id: Date.now().toString(),
-        name: scriptName,
-        type: scriptType,
-        content: scriptContent,
-        createdAt: new Date().toISOString()
-    };
-    
     // Check if script with same name exists
-    const existingIndex = savedScripts.findIndex(s => s.name === scriptName);
-    if (existingIndex >= 0) {
-        if (confirm(`Script "${scriptName}" already exists. Do you want to overwrite it?`)) {
-            savedScripts[existingIndex] = script;
-        } else {
-            return;
-        }
-    } else {
-        savedScripts.push(script);
+    const existing = savedScripts.find(s => s.name === scriptName);
+    if (existing && !confirm(`Script "${scriptName}" already exists. Do you want to overwrite it?`)) {
+        return;
     }
     
-    // Save to localStorage
-    localStorage.setItem('k8s-scripts', JSON.stringify(savedScripts));
-    
-    // Refresh the scripts list
-    renderScriptsList();
+    // Keep fields the editor does not show, such as parameters and the default target
+    const spec = { ...(existing ? existing.spec : {}), type: scriptType, body: scriptContent };
     
-    // Show success message
-    showOutput(`Script "${scriptName}" saved successfully!`, 'success');
+    try {
+        const response = await fetch(existing ? `/api/scripts/${encodeURIComponent(scriptName)}` : '/api/scripts', {
+            method: existing ? 'PUT' : 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ metadata: { name: scriptName }, spec: spec })
+        });
+        
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || response.statusText);
+        }
+        
+        await loadSavedScripts();
+        showOutput(`Script "${scriptName}" saved successfully!`, 'success');
+    } catch (error) {
+        showOutput(`Failed to save script: ${error.message}`, 'error');
+    }
 }
 
-function loadSavedScripts() {
-    const stored = localStorage.getItem('k8s-scripts');
-    if (stored) {
-        try {
-            savedScripts = JSON.parse(stored);
-        } catch (error) {
-            console.error('Error loading saved scripts:', error);
-            savedScripts = [];
+async function loadSavedScripts() {
+    try {
+        const response = await fetch('/api/scripts');
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || response.statusText);
         }
+        
+        const data = await response.json();
+        savedScripts = (data.items || []).map(item => ({
+            id: item.metadata.name,
+            name: item.metadata.name,
+            type: item.spec.type,
+            content: item.spec.body,
+            spec: item.spec
+        }));
+    } catch (error) {
+        console.error('Error loading saved scripts:', error);
+        savedScripts = [];
     }
     renderScriptsList();
 }
@@ -192,19 +369,52 @@ function runScript() {
     executeScript(scriptContent, scriptType);
 }
 
-function runScriptById(scriptId) {
+async function runScriptById(scriptId) {
     const script = savedScripts.find(s => s.id === scriptId);
-    if (script) {
-        executeScript(script.content, script.type);
+    const parameters = promptForParameters(script ? script.spec.parameters || [] : []);
+    if (!parameters) return;
+    
+    showOutput(`Running script "${scriptId}"...`, 'info');
+    
+    try {
+        const response = await fetch(`/api/scripts/${encodeURIComponent(scriptId)}/run`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ parameters: parameters })
+        });
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}: ${(await response.text()).trim() || response.statusText}`);
+        }
+        streamExecution(await response.json());
+    } catch (error) {
+        document.getElementById('output-content').textContent += `\n✗ ${error.message}`;
     }
 }
 
+// Ask for a value for each declared parameter. The server validates the values, so only
+// cancelling the prompt is handled here; it returns null to abort the run.
+function promptForParameters(definitions) {
+    const parameters = {};
+    for (const param of definitions) {
+        let label = param.name;
+        if (param.description) label += ` - ${param.description}`;
+        if (param.type === 'enum') label += ` (${param.enum.join(', ')})`;
+        else if (param.type && param.type !== 'string') label += ` (${param.type})`;
+        
+        const value = prompt(label, param.default || '');
+        if (value === null) return null;
+        if (value !== '') parameters[param.name] = value;
+    }
+    return parameters;
+}
+
 async function executeScript(scriptContent, scriptType) {
     showOutput('Executing script...', 'info');
+    const outputContent = document.getElementById('output-content');
     
     try {
-        // For now, we'll simulate script execution
-        // In a real implementation, this would send the script to the backend
         const response = await fetch('/api/execute-script', {
             method: 'POST',
             headers: {
@@ -216,34 +426,89 @@ async function executeScript(scriptContent, scriptType) {
             })
         });
         
-        if (response.ok) {
-            const result = await response.text();
-            showOutput(result, 'success');
-        } else {
-            throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}: ${(await response.text()).trim() || response.statusText}`);
         }
+        
+        const execution = await response.json();
+        streamExecution(execution);
     } catch (error) {
-        // Simulate execution for demonstration
-        showOutput(`Simulating ${scriptType} script execution:\n\n${scriptContent}\n\n--- Output ---\nScript execution simulated successfully!\nIn a real environment, this would execute the script in a Kubernetes context.`, 'success');
+        outputContent.textContent += `\n✗ ${error.message}`;
     }
 }
 
-function deleteScript(scriptId) {
+// Stream the output of a script execution until its final status event
+function streamExecution(execution) {
+    const outputContent = document.getElementById('output-content');
+    const cancelButton = document.getElementById('cancel-script-btn');
+    const events = new EventSource(execution.eventsUrl);
+    
+    cancelButton.disabled = false;
+    cancelButton.onclick = () => fetch(`/api/executions/${execution.id}`, { method: 'DELETE' });
+    
+    events.addEventListener('output', message => {
+        const event = JSON.parse(message.data);
+        const time = new Date(event.time).toLocaleTimeString();
+        const prefix = event.stream === 'stderr' ? '!' : ' ';
+        outputContent.textContent += `[${time}]${prefix} ${event.data}\n`;
+        outputContent.scrollTop = outputContent.scrollHeight;
+    });
+    
+    events.addEventListener('status', message => {
+        events.close();
+        cancelButton.disabled = true;
+        showScriptResult(JSON.parse(message.data));
+    });
+    
+    events.onerror = () => {
+        // EventSource reconnects with Last-Event-ID, so only give up once it stops retrying
+        if (events.readyState === EventSource.CLOSED) {
+            cancelButton.disabled = true;
+            showScriptResult(null);
+        }
+    };
+}
+
+function showScriptResult(result) {
+    const outputContent = document.getElementById('output-content');
+    if (!result) {
+        outputContent.textContent += '\n✗ Connection closed before the script finished';
+    } else if (result.status === 'Succeeded') {
+        outputContent.textContent += '\n✓ Script completed successfully';
+    } else if (result.exitCode !== undefined) {
+        outputContent.textContent += `\n✗ Script exited with code ${result.exitCode}`;
+    } else {
+        outputContent.textContent += `\n✗ ${result.status}: ${result.error}`;
+    }
+    outputContent.scrollTop = outputContent.scrollHeight;
+}
+
+async function deleteScript(scriptId) {
     const script = savedScripts.find(s => s.id === scriptId);
-    if (script && confirm(`Are you sure you want to delete "${script.name}"?`)) {
-        savedScripts = savedScripts.filter(s => s.id !== scriptId);
-        localStorage.setItem('k8s-scripts', JSON.stringify(savedScripts));
-        renderScriptsList();
-        
-        // Clear editor if the deleted script was loaded
-        if (currentScript && currentScript.id === scriptId) {
-            document.getElementById('script-name').value = '';
-            document.getElementById('script-editor').value = '';
-            currentScript = null;
+    if (!script || !confirm(`Are you sure you want to delete "${script.name}"?`)) {
+        return;
+    }
+    
+    try {
+        const response = await fetch(`/api/scripts/${encodeURIComponent(scriptId)}`, { method: 'DELETE' });
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || response.statusText);
         }
-        
-        showOutput(`Script "${script.name}" deleted`, 'info');
+    } catch (error) {
+        showOutput(`Failed to delete script: ${error.message}`, 'error');
+        return;
     }
+    
+    await loadSavedScripts();
+    
+    // Clear editor if the deleted script was loaded
+    if (currentScript && currentScript.id === scriptId) {
+        document.getElementById('script-name').value = '';
+        document.getElementById('script-editor').value = '';
+        currentScript = null;
+    }
+    
+    showOutput(`Script "${script.name}" deleted`, 'info');
 }
 
 function showOutput(message, type = 'info') {
@@ -251,7 +516,7 @@ function showOutput(message, type = 'info') {
     const timestamp = new Date().toLocaleTimeString();
     const typePrefix = type === 'success' ? '✓' : type === 'error' ? '✗' : 'ℹ';
     
-    outputContent.textContent = `[${timestamp}] ${typePrefix} ${message}`;
+    outputContent.textContent = `[${timestamp}] ${typePrefix} ${message}\n`;
     outputContent.scrollTop = outputContent.scrollHeight;
 }
 
@@ -489,11 +754,11 @@ class FileUploadManager {
             });
 
             if (!response.ok) {
-                throw new Error(`Mount failed: ${response.statusText}`);
+                throw new Error(`Mount failed: ${(await response.text()).trim() || response.statusText}`);
             }
 
             const result = await response.json();
-            alert(`File mounted successfully at ${result.targetPath}`);
+            alert(`Copied ${this.formatFileSize(result.bytesWritten)} to ${result.targetPath}\n${result.checksum}`);
             
         } catch (error) {
             console.error('Mount error:', error);
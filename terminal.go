@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/audit"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/recording"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Terminal WebSocket protocol.
+//
+// Binary frames carry raw terminal bytes in both directions: keystrokes from the
+// browser and process output from the pod. Text frames carry a JSON encoded
+// TerminalMessage used for everything else:
+//
+//	browser -> server  {"op":"stdin","data":"ls\r"}
+//	browser -> server  {"op":"resize","cols":120,"rows":40}
+//	browser -> server  {"op":"ping"}
+//	server -> browser  {"op":"pong"}
+//	server -> browser  {"op":"exit","code":0}
+//	server -> browser  {"op":"error","message":"..."}
+//	server -> browser  {"op":"session","session":"<id>","token":"<token>"}
+//	server -> browser  {"op":"presence","event":"join","user":"bob","viewer":"<id>","mode":"read-only"}
+//
+// The session frame is sent first when the session can be reattached after the
+// WebSocket is lost, through /api/sessions/<id>/attach?token=<token>. Presence frames
+// announce the viewers joining and leaving a shared session.
+//
+// Sessions closed for exceeding their idle timeout or maximum duration, or terminated
+// through the sessions API, end with one of the close codes below instead of an exit
+// frame.
+const (
+	OpStdin    = "stdin"
+	OpResize   = "resize"
+	OpPing     = "ping"
+	OpPong     = "pong"
+	OpExit     = "exit"
+	OpError    = "error"
+	OpSession  = "session"
+	OpPresence = "presence"
+)
+
+// WebSocket close codes of sessions ended by the server. They are in the range reserved
+// for applications, so clients can tell them apart from errors and process exits.
+const (
+	CloseIdleTimeout = 4000
+	CloseMaxDuration = 4001
+	CloseTerminated  = 4002
+	// CloseReplaced closes a connection when the session is attached elsewhere
+	CloseReplaced = 4003
+	// CloseRevoked closes the connection of a viewer whose access was revoked
+	CloseRevoked = 4004
+	// CloseStreamLost closes an attached terminal whose stream to the container broke
+	// while the process still runs
+	CloseStreamLost = 4005
+)
+
+// TerminalMessage is a control frame of the terminal WebSocket protocol
+type TerminalMessage struct {
+	Op      string `json:"op"`
+	Data    string `json:"data,omitempty"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+	Code    *int   `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Time and Speed are used by recording playback
+	Time  *float64 `json:"time,omitempty"`
+	Speed float64  `json:"speed,omitempty"`
+	// Session and Token let the browser reattach to the session
+	Session string `json:"session,omitempty"`
+	Token   string `json:"token,omitempty"`
+	// Event, User, Viewer and Mode describe a presence change in a shared session
+	Event  string `json:"event,omitempty"`
+	User   string `json:"user,omitempty"`
+	Viewer string `json:"viewer,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// TerminalSession adapts a browser WebSocket to the stdin, stdout and resize
+// streams expected by remotecommand. The session outlives its WebSocket when
+// DetachGrace is set: a browser that disconnects can reattach within the grace period
+// and is sent the scrollback before live output resumes. Viewers invited by the owner
+// receive the same output; see sharing.go.
+type TerminalSession struct {
+	sessionOptions
+
+	// writeMu guards wsConn, which is nil while the session is detached, the viewers and
+	// everything written or queued to them, so output is never lost or repeated when a
+	// browser reattaches or joins
+	writeMu     sync.Mutex
+	wsConn      *websocket.Conn
+	viewers     map[string]*viewer
+	scrollback  *ringBuffer
+	detachTimer *time.Timer
+
+	stdin   chan stdinChunk
+	pending []byte
+	// pendingInput is the writer of pending
+	pendingInput *terminalInput
+
+	sizeChan chan remotecommand.TerminalSize
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// bytesIn and bytesOut count the keystrokes and output passed through the session
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// lastActivity is the time of the last keystroke or output in Unix nanoseconds
+	lastActivity atomic.Int64
+	// closeReason is set when the server ended the session, such as for exceeding a limit
+	closeReason atomic.Pointer[string]
+}
+
+// sessionOptions are the optional parts of a terminal session
+type sessionOptions struct {
+	// User is the owner of the session, announced to viewers
+	User string
+	// Recorder records the session
+	Recorder *recording.Recorder
+	// Input follows the command lines the owner types
+	Input terminalInput
+	// NewInput returns the line tracking of a read-write viewer, whose commands are
+	// audited and checked under the viewer's own request record
+	NewInput func(record *audit.Record) (terminalInput, error)
+	// DetachGrace keeps the session open this long after its WebSocket is lost so the
+	// browser can reattach. Without it the session ends with the WebSocket.
+	DetachGrace time.Duration
+	// Scrollback is how many bytes of recent output are replayed when a browser
+	// reattaches or a viewer joins
+	Scrollback int
+}
+
+// terminalInput follows the command lines one user types into a session. Each writer has
+// its own, so keystrokes of the owner and of read-write viewers are not mixed into one
+// line and their commands are audited and denied under their own identity. The methods
+// do nothing on a nil input.
+type terminalInput struct {
+	// Commands audits the command lines typed
+	Commands *audit.LineAssembler
+	// Guard keeps the lines it blocks from reaching the shell
+	Guard *commandGuard
+}
+
+// output lets the line tracking follow the terminal output, such as the lines the shell
+// recalls from its history
+func (in *terminalInput) output(p []byte) {
+	if in != nil {
+		in.Commands.Output(p)
+		in.Guard.output(p)
+	}
+}
+
+// stdinChunk is input waiting for the exec stream and the writer it came from
+type stdinChunk struct {
+	data  []byte
+	input *terminalInput
+}
+
+// newTerminalSession wraps a WebSocket connection and starts decoding frames from it
+func newTerminalSession(conn *websocket.Conn, opts sessionOptions) *TerminalSession {
+	t := &TerminalSession{
+		sessionOptions: opts,
+		wsConn:         conn,
+		viewers:        make(map[string]*viewer),
+		scrollback:     newRingBuffer(opts.Scrollback),
+		stdin:          make(chan stdinChunk),
+		sizeChan:       make(chan remotecommand.TerminalSize, 1),
+		done:           make(chan struct{}),
+	}
+	t.lastActivity.Store(time.Now().UnixNano())
+	go t.readLoop(conn)
+	return t
+}
+
+// readLoop decodes incoming frames until the connection fails or the session is closed
+func (t *TerminalSession) readLoop(conn *websocket.Conn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			t.detach(conn)
+			return
+		}
+
+		if messageType == websocket.BinaryMessage {
+			if !t.sendStdin(data, &t.Input) {
+				return
+			}
+			continue
+		}
+
+		var msg TerminalMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.sendMessage(TerminalMessage{Op: OpError, Message: fmt.Sprintf("invalid message: %v", err)})
+			continue
+		}
+		if !t.handleMessage(msg) {
+			return
+		}
+	}
+}
+
+// detach drops conn if it is still the session's connection. The session ends unless
+// the browser reattaches within the grace period.
+func (t *TerminalSession) detach(conn *websocket.Conn) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.detachLocked(conn)
+}
+
+func (t *TerminalSession) detachLocked(conn *websocket.Conn) {
+	if t.wsConn != conn {
+		return
+	}
+	t.wsConn = nil
+	if t.DetachGrace <= 0 {
+		t.Close()
+		return
+	}
+	t.detachTimer = time.AfterFunc(t.DetachGrace, func() {
+		t.writeMu.Lock()
+		attached := t.wsConn != nil
+		t.writeMu.Unlock()
+		if !attached {
+			t.Close()
+		}
+	})
+}
+
+// attach makes conn the session's connection, replacing and closing any other, and
+// replays the scrollback to it. The returned channel is closed when conn is detached.
+func (t *TerminalSession) attach(conn *websocket.Conn) (<-chan struct{}, error) {
+	t.writeMu.Lock()
+	select {
+	case <-t.done:
+		t.writeMu.Unlock()
+		return nil, fmt.Errorf("session has ended")
+	default:
+	}
+	if t.detachTimer != nil {
+		t.detachTimer.Stop()
+		t.detachTimer = nil
+	}
+	if old := t.wsConn; old != nil {
+		old.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseReplaced, "attached elsewhere"))
+		old.Close()
+	}
+	t.wsConn = conn
+	var err error
+	if replay := t.scrollback.Bytes(); len(replay) > 0 {
+		err = conn.WriteMessage(websocket.BinaryMessage, replay)
+	}
+	t.writeMu.Unlock()
+
+	detached := make(chan struct{})
+	go func() {
+		defer close(detached)
+		t.readLoop(conn)
+	}()
+	return detached, err
+}
+
+// attached reports whether a browser is connected to the session
+func (t *TerminalSession) attached() bool {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.wsConn != nil
+}
+
+// handleMessage applies a single control frame. It returns false once the session is closed.
+func (t *TerminalSession) handleMessage(msg TerminalMessage) bool {
+	switch msg.Op {
+	case OpStdin:
+		return t.sendStdin([]byte(msg.Data), &t.Input)
+	case OpResize:
+		if msg.Cols == 0 || msg.Rows == 0 {
+			t.sendMessage(TerminalMessage{Op: OpError, Message: "resize requires non-zero cols and rows"})
+			return true
+		}
+		t.resize(remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows})
+		t.Recorder.Resize(msg.Cols, msg.Rows)
+	case OpPing:
+		t.sendMessage(TerminalMessage{Op: OpPong})
+	default:
+		t.sendMessage(TerminalMessage{Op: OpError, Message: fmt.Sprintf("unsupported op %q", msg.Op)})
+	}
+	return true
+}
+
+// sendStdin hands data typed by input's user to the exec stream, returning false if the
+// session closed first
+func (t *TerminalSession) sendStdin(data []byte, input *terminalInput) bool {
+	if len(data) == 0 {
+		return true
+	}
+	select {
+	case t.stdin <- stdinChunk{data: data, input: input}:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// resize queues a new terminal size, replacing any size the exec stream has not picked up yet
+func (t *TerminalSession) resize(size remotecommand.TerminalSize) {
+	for {
+		select {
+		case t.sizeChan <- size:
+			return
+		default:
+		}
+		select {
+		case <-t.sizeChan:
+		default:
+		}
+	}
+}
+
+// sendMessage writes a JSON control frame to the browser, if one is attached
+func (t *TerminalSession) sendMessage(msg TerminalMessage) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if t.wsConn == nil {
+		return nil
+	}
+	return t.wsConn.WriteJSON(msg)
+}
+
+// broadcast writes a JSON control frame to the owner and all viewers. It returns the
+// error of writing to the owner.
+func (t *TerminalSession) broadcast(msg TerminalMessage) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.broadcastLocked(msg)
+}
+
+func (t *TerminalSession) broadcastLocked(msg TerminalMessage) error {
+	for _, v := range t.viewers {
+		v.sendJSON(msg)
+	}
+	if t.wsConn == nil {
+		return nil
+	}
+	return t.wsConn.WriteJSON(msg)
+}
+
+// Exit reports the exit code of the remote process and closes the WebSocket cleanly
+func (t *TerminalSession) Exit(code int) error {
+	if err := t.broadcast(TerminalMessage{Op: OpExit, Code: &code}); err != nil {
+		return err
+	}
+	return t.closeConn(websocket.CloseNormalClosure, "process exited")
+}
+
+// Fail reports an error to the browser and closes the WebSocket
+func (t *TerminalSession) Fail(err error) error {
+	if sendErr := t.broadcast(TerminalMessage{Op: OpError, Message: err.Error()}); sendErr != nil {
+		return sendErr
+	}
+	return t.closeConn(websocket.CloseInternalServerErr, "terminal error")
+}
+
+// Lost tells the browser that the stream to an attached process broke while the process
+// keeps running, so it can attach again, and closes the WebSocket
+func (t *TerminalSession) Lost(err error) error {
+	t.writeNotice("Connection to the container was lost, the process is still running.")
+	return t.closeConn(CloseStreamLost, "stream lost")
+}
+
+// writeNotice writes a highlighted line into the terminal without counting as activity
+func (t *TerminalSession) writeNotice(message string) {
+	t.write([]byte("\r\n\x1b[1;33m"+message+"\x1b[0m\r\n"), false)
+}
+
+// terminate ends the session on behalf of the server. It shows message in the terminal
+// and closes the WebSocket with a close code that tells the cause apart from errors and
+// process exits.
+func (t *TerminalSession) terminate(code int, reason, message string) {
+	t.writeNotice(message)
+	t.closeReason.Store(&reason)
+	t.closeConn(code, reason)
+	t.Close()
+}
+
+// closeConn sends a WebSocket close frame with the given code and reason to the viewers
+// and, if a browser is attached, the owner
+func (t *TerminalSession) closeConn(code int, reason string) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	for _, v := range t.viewers {
+		v.send(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	}
+	if t.wsConn == nil {
+		return nil
+	}
+	return t.wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+// Close marks the session as finished and unblocks pending reads and resize requests
+func (t *TerminalSession) Close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+// Next implements remotecommand.TerminalSizeQueue
+func (t *TerminalSession) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-t.sizeChan:
+		return &size
+	case <-t.done:
+		return nil
+	}
+}
+
+// Read implements io.Reader
+func (t *TerminalSession) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		select {
+		case chunk := <-t.stdin:
+			data, blocked := chunk.input.Guard.filter(chunk.data)
+			for _, err := range blocked {
+				t.Write(blockedMessage(err))
+			}
+			t.pending, t.pendingInput = data, chunk.input
+		case <-t.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	t.lastActivity.Store(time.Now().UnixNano())
+	t.bytesIn.Add(int64(n))
+	t.Recorder.Input(p[:n])
+	t.pendingInput.Commands.Input(p[:n])
+	return n, nil
+}
+
+// Write implements io.Writer
+func (t *TerminalSession) Write(p []byte) (int, error) {
+	return t.write(p, true)
+}
+
+// write sends terminal output to the browser and the viewers. Output of the process is
+// activity, notices of the server are not. While detached, output is only kept in the
+// scrollback; a failed write detaches the browser if it may reattach.
+func (t *TerminalSession) write(p []byte, active bool) (int, error) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if len(t.viewers) > 0 {
+		// The viewers' writers send p after it has been returned to the caller
+		data := append([]byte(nil), p...)
+		for _, v := range t.viewers {
+			v.send(websocket.BinaryMessage, data)
+			v.input.output(p)
+		}
+	}
+	if t.wsConn != nil {
+		if err := t.wsConn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			if t.DetachGrace <= 0 {
+				return 0, err
+			}
+			t.detachLocked(t.wsConn)
+		}
+	}
+	if active {
+		t.lastActivity.Store(time.Now().UnixNano())
+	}
+	t.scrollback.Write(p)
+	t.bytesOut.Add(int64(len(p)))
+	t.Recorder.Output(p)
+	t.Input.output(p)
+	return len(p), nil
+}
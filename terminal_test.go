@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// testTerminal is the browser side of the terminal WebSocket protocol
+type testTerminal struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	output strings.Builder
+}
+
+// dialTerminal opens a terminal WebSocket against a test HTTP server
+func dialTerminal(t *testing.T, serverURL, query string) *testTerminal {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/api/terminal?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial terminal: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testTerminal{t: t, conn: conn}
+}
+
+// dialTerminalAs opens a terminal WebSocket with the bearer token of a test user
+func dialTerminalAs(t *testing.T, serverURL, query, token string) *testTerminal {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/api/terminal?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("Failed to dial terminal: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testTerminal{t: t, conn: conn}
+}
+
+// stdin sends raw keystrokes as a binary frame
+func (tt *testTerminal) stdin(data string) {
+	tt.t.Helper()
+	if err := tt.conn.WriteMessage(websocket.BinaryMessage, []byte(data)); err != nil {
+		tt.t.Fatalf("Failed to write stdin: %v", err)
+	}
+}
+
+// send writes a JSON control frame
+func (tt *testTerminal) send(msg TerminalMessage) {
+	tt.t.Helper()
+	if err := tt.conn.WriteJSON(msg); err != nil {
+		tt.t.Fatalf("Failed to write %s message: %v", msg.Op, err)
+	}
+}
+
+// next reads a single frame, accumulating terminal output and decoding control frames
+func (tt *testTerminal) next() *TerminalMessage {
+	tt.t.Helper()
+	tt.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	messageType, data, err := tt.conn.ReadMessage()
+	if err != nil {
+		tt.t.Fatalf("Failed to read from terminal (output so far %q): %v", tt.output.String(), err)
+	}
+	if messageType == websocket.BinaryMessage {
+		tt.output.Write(data)
+		return nil
+	}
+	var msg TerminalMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		tt.t.Fatalf("Received invalid control frame %q: %v", data, err)
+	}
+	return &msg
+}
+
+// expectOutput reads until the terminal output contains want
+func (tt *testTerminal) expectOutput(want string) {
+	tt.t.Helper()
+	for !strings.Contains(tt.output.String(), want) {
+		tt.next()
+	}
+}
+
+// expectMessage reads until a control frame with the given op arrives
+func (tt *testTerminal) expectMessage(op string) TerminalMessage {
+	tt.t.Helper()
+	for {
+		if msg := tt.next(); msg != nil && msg.Op == op {
+			return *msg
+		}
+	}
+}
+
+// expectExit waits for the exit frame and returns the reported exit code
+func (tt *testTerminal) expectExit() int {
+	tt.t.Helper()
+	msg := tt.expectMessage(OpExit)
+	if msg.Code == nil {
+		tt.t.Fatalf("Exit frame is missing the exit code")
+	}
+	return *msg.Code
+}
+
+func TestTerminalProtocol(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int {
+		// Report every resize, then exit with the code typed on stdin
+		go func() {
+			for size := range req.Resize {
+				fmt.Fprintf(req.Stdout, "%dx%d", size.Width, size.Height)
+			}
+		}()
+		buf := make([]byte, 64)
+		n, err := req.Stdin.Read(buf)
+		if err != nil {
+			return 1
+		}
+		if string(buf[:n]) == "fail" {
+			return 42
+		}
+		return 0
+	})
+	tc := terminalConfigObject(t, &terminalv1.TerminalConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "protocol", Namespace: "default"},
+	})
+	server := newTestServer(t, execServer, tc)
+	httpServer := httptest.NewServer(http.HandlerFunc(server.terminalHandler))
+	defer httpServer.Close()
+
+	// Without a pod the terminal attaches to the pod provisioned for the config
+	term := dialTerminal(t, httpServer.URL, "config=protocol")
+
+	term.send(TerminalMessage{Op: OpPing})
+	term.expectMessage(OpPong)
+
+	term.send(TerminalMessage{Op: "bogus"})
+	if msg := term.expectMessage(OpError); !strings.Contains(msg.Message, "bogus") {
+		t.Errorf("Expected error about unsupported op, got %q", msg.Message)
+	}
+
+	term.send(TerminalMessage{Op: OpResize, Cols: 132, Rows: 43})
+	term.expectOutput("132x43")
+
+	term.send(TerminalMessage{Op: OpStdin, Data: "fail"})
+	if code := term.expectExit(); code != 42 {
+		t.Errorf("Expected exit code 42, got %d", code)
+	}
+
+	term.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, _, err := term.conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected a normal close after exit, got %v", err)
+	}
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/protocol-terminal/exec" {
+		t.Errorf("Unexpected exec path: %s", req.Path)
+	}
+}
+
+func TestTerminalSessionResizeKeepsLatest(t *testing.T) {
+	session := &TerminalSession{
+		sizeChan: make(chan remotecommand.TerminalSize, 1),
+		done:     make(chan struct{}),
+	}
+	session.resize(remotecommand.TerminalSize{Width: 80, Height: 24})
+	session.resize(remotecommand.TerminalSize{Width: 100, Height: 30})
+
+	size := session.Next()
+	if size == nil || size.Width != 100 || size.Height != 30 {
+		t.Fatalf("Expected latest size 100x30, got %v", size)
+	}
+
+	session.Close()
+	if size := session.Next(); size != nil {
+		t.Errorf("Expected nil size after close, got %v", size)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ScriptRunRequest is the optional body of POST /api/scripts/{name}/run. A podName
+// replaces the script's default target.
+type ScriptRunRequest struct {
+	PodName        string                 `json:"podName,omitempty"`
+	Namespace      string                 `json:"namespace,omitempty"`
+	Container      string                 `json:"container,omitempty"`
+	TimeoutSeconds int                    `json:"timeoutSeconds,omitempty"`
+	Parameters     map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// validateTerminalScript checks a TerminalScript before it is stored
+func validateTerminalScript(ts *terminalv1.TerminalScript) error {
+	if ts.Name == "" {
+		return fmt.Errorf("metadata.name is required")
+	}
+	if _, ok := scriptRuntimes[ts.Spec.Type]; !ok {
+		return fmt.Errorf("unsupported script type %q", ts.Spec.Type)
+	}
+	if ts.Spec.Body == "" {
+		return fmt.Errorf("spec.body is required")
+	}
+	if err := validateParameterDefinitions(ts.Spec.Parameters); err != nil {
+		return err
+	}
+	declared := make(map[string]string, len(ts.Spec.Parameters))
+	for _, param := range ts.Spec.Parameters {
+		declared[param.Name] = ""
+	}
+	if _, err := renderScript(ts.Spec.Type, ts.Spec.Body, declared); err != nil {
+		return err
+	}
+	if ts.Spec.DefaultTarget != nil && ts.Spec.DefaultTarget.PodName == "" {
+		return fmt.Errorf("spec.defaultTarget.podName is required")
+	}
+	return nil
+}
+
+// writeAPIError maps Kubernetes API errors to HTTP status codes
+func writeAPIError(w http.ResponseWriter, err error) {
+	if writeForbidden(w, err) {
+		return
+	}
+	code := http.StatusInternalServerError
+	switch {
+	case apierrors.IsNotFound(err):
+		code = http.StatusNotFound
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err):
+		code = http.StatusConflict
+	case apierrors.IsInvalid(err):
+		code = http.StatusUnprocessableEntity
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func (s *Server) listTerminalScriptsHandler(w http.ResponseWriter, r *http.Request) {
+	scripts, err := s.scriptClient.List(r.Context())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scripts)
+}
+
+func (s *Server) getTerminalScriptHandler(w http.ResponseWriter, r *http.Request) {
+	script, err := s.scriptClient.Get(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(script)
+}
+
+func (s *Server) createTerminalScriptHandler(w http.ResponseWriter, r *http.Request) {
+	var script terminalv1.TerminalScript
+	if err := json.NewDecoder(r.Body).Decode(&script); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateTerminalScript(&script); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	script.APIVersion = terminalv1.SchemeGroupVersion.String()
+	script.Kind = "TerminalScript"
+	script.Namespace = s.namespace
+
+	created, err := s.scriptClient.Create(r.Context(), &script)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// updateTerminalScriptHandler replaces the spec of a script. Without a resourceVersion in
+// the body the latest version is overwritten; with one, concurrent edits fail with 409.
+func (s *Server) updateTerminalScriptHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	var script terminalv1.TerminalScript
+	if err := json.NewDecoder(r.Body).Decode(&script); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if script.Name == "" {
+		script.Name = name
+	}
+	if script.Name != name {
+		http.Error(w, "metadata.name does not match the URL", http.StatusBadRequest)
+		return
+	}
+	if err := validateTerminalScript(&script); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := s.scriptClient.Get(r.Context(), name)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if script.ResourceVersion != "" {
+		existing.ResourceVersion = script.ResourceVersion
+	}
+	existing.Spec = script.Spec
+
+	updated, err := s.scriptClient.Update(r.Context(), existing)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (s *Server) deleteTerminalScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.scriptClient.Delete(r.Context(), mux.Vars(r)["name"]); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runTerminalScriptHandler runs a saved script like /api/execute-script and returns its execution ID
+func (s *Server) runTerminalScriptHandler(w http.ResponseWriter, r *http.Request) {
+	script, err := s.scriptClient.Get(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	var run ScriptRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&run); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	s.startScript(w, r, scriptRequestFor(script, run))
+}
+
+// scriptRequestFor builds the request that runs a saved script. The caller's target takes
+// precedence over the script's default target; a namespace without a pod runs the script
+// as a Job in that namespace. startScript checks the caller may exec into the pod or
+// create the Job there.
+func scriptRequestFor(script *terminalv1.TerminalScript, run ScriptRunRequest) ScriptRequest {
+	req := ScriptRequest{
+		Script:               script.Spec.Body,
+		Type:                 script.Spec.Type,
+		TimeoutSeconds:       run.TimeoutSeconds,
+		ParameterDefinitions: script.Spec.Parameters,
+		Parameters:           run.Parameters,
+	}
+	switch {
+	case run.PodName != "":
+		req.PodName, req.Namespace, req.Container = run.PodName, run.Namespace, run.Container
+	case run.Namespace != "":
+		req.Namespace = run.Namespace
+	case script.Spec.DefaultTarget != nil:
+		target := script.Spec.DefaultTarget
+		req.PodName, req.Namespace, req.Container = target.PodName, target.Namespace, target.Container
+	}
+	if req.Namespace == "" {
+		req.Namespace = script.Namespace
+	}
+	return req
+}
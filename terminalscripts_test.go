@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	terminalv1 "github.com/jraymond/kubernetes-web-terminal/pkg/apis/terminal/v1"
+	"github.com/jraymond/kubernetes-web-terminal/pkg/auth"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// callScriptAPI invokes a TerminalScript handler with the script name as the {name} route variable
+func callScriptAPI(handler http.HandlerFunc, method, name, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/scripts/"+name, strings.NewReader(body))
+	if name != "" {
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// terminalScriptObject converts a TerminalScript into the unstructured form used by the fake dynamic client
+func terminalScriptObject(t *testing.T, ts *terminalv1.TerminalScript) runtime.Object {
+	t.Helper()
+	ts.APIVersion = terminalv1.SchemeGroupVersion.String()
+	ts.Kind = "TerminalScript"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ts)
+	if err != nil {
+		t.Fatalf("Failed to convert TerminalScript: %v", err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestTerminalScriptCRUD(t *testing.T) {
+	server := newTestServer(t, newFakeExecServer(t, func(fakeExecRequest) int { return 0 }))
+	script := `{"metadata":{"name":"list-pods"},"spec":{"type":"kubectl","body":"kubectl get pods","parameters":[{"name":"namespace","default":"default"}]}}`
+
+	if rec := callScriptAPI(server.createTerminalScriptHandler, http.MethodPost, "", script); rec.Code != http.StatusCreated {
+		t.Fatalf("Create: expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := callScriptAPI(server.createTerminalScriptHandler, http.MethodPost, "", script); rec.Code != http.StatusConflict {
+		t.Errorf("Duplicate create: expected 409, got %d", rec.Code)
+	}
+
+	rec := callScriptAPI(server.listTerminalScriptsHandler, http.MethodGet, "", "")
+	var list terminalv1.TerminalScriptList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Items) != 1 {
+		t.Fatalf("List: expected one script, got %d (%v)", len(list.Items), err)
+	}
+	if list.Items[0].Spec.Parameters[0].Default != "default" {
+		t.Errorf("List: parameters not stored: %+v", list.Items[0].Spec)
+	}
+
+	update := `{"spec":{"type":"kubectl","body":"kubectl get pods -o wide"}}`
+	if rec := callScriptAPI(server.updateTerminalScriptHandler, http.MethodPut, "list-pods", update); rec.Code != http.StatusOK {
+		t.Fatalf("Update: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = callScriptAPI(server.getTerminalScriptHandler, http.MethodGet, "list-pods", "")
+	var got terminalv1.TerminalScript
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Spec.Body != "kubectl get pods -o wide" {
+		t.Errorf("Get: expected the updated body, got %+v (%v)", got.Spec, err)
+	}
+
+	if rec := callScriptAPI(server.deleteTerminalScriptHandler, http.MethodDelete, "list-pods", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Delete: expected 204, got %d", rec.Code)
+	}
+	if rec := callScriptAPI(server.getTerminalScriptHandler, http.MethodGet, "list-pods", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Get after delete: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestTerminalScriptValidation(t *testing.T) {
+	server := newTestServer(t, newFakeExecServer(t, func(fakeExecRequest) int { return 0 }))
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"spec":{"type":"bash","body":"true"}}`},
+		{"unknown type", `{"metadata":{"name":"s"},"spec":{"type":"ruby","body":"puts 1"}}`},
+		{"empty body", `{"metadata":{"name":"s"},"spec":{"type":"bash"}}`},
+		{"duplicate parameter", `{"metadata":{"name":"s"},"spec":{"type":"bash","body":"true","parameters":[{"name":"a"},{"name":"a"}]}}`},
+		{"target without pod", `{"metadata":{"name":"s"},"spec":{"type":"bash","body":"true","defaultTarget":{"namespace":"x"}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := callScriptAPI(server.createTerminalScriptHandler, http.MethodPost, "", tc.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d", rec.Code)
+			}
+		})
+	}
+
+	rec := callScriptAPI(server.updateTerminalScriptHandler, http.MethodPut, "a", `{"metadata":{"name":"b"},"spec":{"type":"bash","body":"true"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Renaming through update: expected 400, got %d", rec.Code)
+	}
+
+	// Go-templates in scripts are not mistaken for parameters
+	body := `{"metadata":{"name":"pod-names"},"spec":{"type":"kubectl","body":"kubectl get pods -o go-template='{{range .items}}{{.metadata.name}}{{end}}'"}}`
+	if rec := callScriptAPI(server.createTerminalScriptHandler, http.MethodPost, "", body); rec.Code != http.StatusCreated {
+		t.Errorf("Expected a script with a go-template to be created, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRunTerminalScriptByName(t *testing.T) {
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	script := &terminalv1.TerminalScript{
+		ObjectMeta: metav1.ObjectMeta{Name: "disk-usage", Namespace: "default"},
+		Spec: terminalv1.TerminalScriptSpec{
+			Type:          "bash",
+			Body:          "df -h",
+			DefaultTarget: &terminalv1.ScriptTarget{PodName: "tools-0", Container: "shell"},
+		},
+	}
+	server := newTestServer(t, execServer, terminalScriptObject(t, script))
+
+	rec := callScriptAPI(server.runTerminalScriptHandler, http.MethodPost, "disk-usage", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var execution ExecutionResponse
+	json.NewDecoder(rec.Body).Decode(&execution)
+	streamExecution(t, server, execution.ID, "")
+
+	req := execServer.lastRequest(t)
+	if req.Path != "/api/v1/namespaces/default/pods/tools-0/exec" {
+		t.Errorf("Expected the default target, got %s", req.Path)
+	}
+	if strings.Join(req.Command, " ") != "bash -c df -h" {
+		t.Errorf("Unexpected command %v", req.Command)
+	}
+
+	rec = callScriptAPI(server.runTerminalScriptHandler, http.MethodPost, "disk-usage", `{"podName":"web-1","namespace":"team-a"}`)
+	json.NewDecoder(rec.Body).Decode(&execution)
+	streamExecution(t, server, execution.ID, "")
+	if req := execServer.lastRequest(t); req.Path != "/api/v1/namespaces/team-a/pods/web-1/exec" {
+		t.Errorf("Expected the requested target, got %s", req.Path)
+	}
+
+	if rec := callScriptAPI(server.runTerminalScriptHandler, http.MethodPost, "missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Running an unknown script: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestRunTerminalScriptInNamespace(t *testing.T) {
+	script := &terminalv1.TerminalScript{
+		ObjectMeta: metav1.ObjectMeta{Name: "disk-usage", Namespace: "default"},
+		Spec: terminalv1.TerminalScriptSpec{
+			Type:          "bash",
+			Body:          "df -h",
+			DefaultTarget: &terminalv1.ScriptTarget{PodName: "tools-0"},
+		},
+	}
+	req := scriptRequestFor(script, ScriptRunRequest{Namespace: "team-a"})
+	if req.PodName != "" || req.Namespace != "team-a" {
+		t.Errorf("Expected a Job in team-a, got pod %q in %q", req.PodName, req.Namespace)
+	}
+
+	execServer := newFakeExecServer(t, func(req fakeExecRequest) int { return 0 })
+	server := newTestServer(t, execServer, terminalScriptObject(t, script))
+	server.authorizer = newFakeAuthorizer("alice create jobs.batch default")
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/api/scripts/disk-usage/run", strings.NewReader(`{"namespace":"team-a"}`))
+	httpReq = mux.SetURLVars(httpReq, map[string]string{"name": "disk-usage"})
+	httpReq = httpReq.WithContext(auth.WithUser(httpReq.Context(), &auth.User{Name: "alice"}))
+	rec := httptest.NewRecorder()
+	server.runTerminalScriptHandler(rec, httpReq)
+	if response := decodeForbidden(t, rec.Code, rec.Body.String()); response.Resource != "jobs" || response.Namespace != "team-a" {
+		t.Errorf("Unexpected 403 response %+v", response)
+	}
+}